@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/subtle"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// adminToken guards admin requests. Admin requests are refused while it is
+// empty.
+var adminToken string
+
+func checkAdminToken(token string) bool {
+	if adminToken == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// requireAdmin wraps an admin HTTP handler, accepting the token as a bearer
+// Authorization header.
+func requireAdmin(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !checkAdminToken(token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func handleAdminReset(w http.ResponseWriter, r *http.Request) {
+	stateMu.Lock()
+	resetCapture()
+	stateMu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resetCapture stops any session, drops queued frames, re-probes devices and
+// returns to idle without closing connections. It waits for arecord to
+// release the device first, so a mic-listen right after the reset can open
+// it. Callers hold stateMu.
+func resetCapture() {
+	setMicState(StateResetting)
+	broadcastState()
+
+	if session := audioSession; session != nil {
+		stopSession()
+		select {
+		case <-session.Done():
+		case <-time.After(stopWait):
+			log.Println("Capture reset while arecord is still running")
+		}
+	}
+	endSessionToken()
+	clientsMu.Lock()
+	for c := range clients {
+		c.flush()
+	}
+	clientsMu.Unlock()
+	if devices, err := probeCaptureDevices(); err != nil {
+		log.Println("Device probe error:", err)
+	} else {
+		log.Printf("Capture reset, %d capture device(s) found", len(devices))
+	}
+
+	setMicState(StateIdle)
+	broadcastState()
+}
+
+func handleAdminDisconnectAll(w http.ResponseWriter, r *http.Request) {
+	stateMu.Lock()
+	kicked := disconnectAll()
+	stateMu.Unlock()
+	closeForMaintenance(kicked)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maintenanceReason is the close reason sent by disconnectAll.
+const maintenanceReason = "server maintenance"
+
+// disconnectAll stops any session, broadcasts the idle state and returns
+// every connection for closeForMaintenance. Unlike shutdown the server keeps
+// accepting new connections. Callers hold stateMu and close the returned
+// connections after releasing it, since each close may wait closeWait on
+// a stalled peer.
+func disconnectAll() []*client {
+	if audioSession != nil {
+		stopSession()
+	}
+	endSessionToken()
+	setMicState(StateIdle)
+	broadcastState()
+	clientsMu.Lock()
+	kicked := make([]*client, 0, len(clients))
+	for c := range clients {
+		kicked = append(kicked, c)
+	}
+	clientsMu.Unlock()
+	return kicked
+}
+
+// closeForMaintenance closes each connection with a maintenance close
+// frame.
+func closeForMaintenance(kicked []*client) {
+	for _, c := range kicked {
+		c.closeWith(websocket.CloseTryAgainLater, maintenanceReason)
+	}
+	log.Printf("Disconnected %d client(s) for maintenance", len(kicked))
+}
+
+// handleAdminLogLevel changes the log level, taken from the level query
+// parameter or the request body.
+func handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	value := r.URL.Query().Get("level")
+	if value == "" {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 64))
+		value = string(body)
+	}
+	if err := setLogLevel(value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setLogLevel(value string) error {
+	level, err := parseLogLevel(value)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+	slog.Info("Log level changed", "level", level)
+	return nil
+}
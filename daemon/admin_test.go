@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResetCaptureReleasesDevice(t *testing.T) {
+	defer func(d []CaptureDevice) {
+		devicesMu.Lock()
+		captureDevices = d
+		devicesMu.Unlock()
+	}(captureDevices)
+	defer setMicState(StateIdle)
+	dir := t.TempDir()
+	pid, overlap := filepath.Join(dir, "pid"), filepath.Join(dir, "overlap")
+	// the device probe notes whether the capture process is still alive
+	fakeArecord(t, `if [ "$1" = -l ]; then kill -0 "$(cat `+pid+`)" 2>/dev/null && touch `+overlap+`; exit 0; fi
+echo $$ > `+pid+`
+exec sh -c '`+zeros+`'`)
+	session, err := StartAudioStream(testCapture, func(AudioConfig, []byte) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateMu.Lock()
+	audioSession = session
+	resetCapture()
+	stateMu.Unlock()
+	if audioSession != nil || micState != StateIdle {
+		t.Fatalf("reset left session %v, state %s", audioSession, micState)
+	}
+	if _, err := os.Stat(overlap); err == nil {
+		t.Fatal("reset re-probed devices while arecord still held the device")
+	}
+}
@@ -1,98 +1,600 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"os"
 	"os/exec"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type AudioConfig struct {
-    SampleRate     int
-    Channels       int
-    BytesPerSample int
-    SecondsPerChunk float64
+	SampleRate      int
+	Channels        int
+	BytesPerSample  int
+	SecondsPerChunk float64
+	// PeriodFrames and BufferFrames map to arecord's --period-size and
+	// --buffer-size. Zero leaves arecord's defaults in place.
+	PeriodFrames int
+	BufferFrames int
+	// Device is the ALSA PCM to capture from, e.g. "hw:1,0" or
+	// "plughw:1,0". Empty selects defaultDevice.
+	//
+	// hw: devices open the hardware directly, so the requested format, rate
+	// and channel count must be one the device supports natively. plughw:
+	// lets ALSA convert to whatever was requested at the cost of some CPU and
+	// a possible resampling step.
+	//
+	// "pulse:SOURCE" captures from a PulseAudio/PipeWire source through the
+	// ALSA pulse plugin, e.g. "pulse:alsa_output.usb-foo.analog-stereo.monitor"
+	// to stream what is playing.
+	Device string
+	// UsePlug rewrites an hw: device to its plughw: equivalent.
+	UsePlug bool
+	// RingSeconds keeps the last RingSeconds of PCM in memory so it can be
+	// dumped on request. Zero disables the ring.
+	RingSeconds float64
+	// NoiseGate, when set, replaces audio below its threshold with silence.
+	NoiseGate *NoiseGateConfig
+	// Preset is carried for reporting; its values are already expanded.
+	Preset string
+	// Devices are tried in order after Device until one opens. The
+	// effective config names the one that did, with Devices cleared.
+	Devices []string
+	// SourceChannel, when set, captures Channels channels but delivers only
+	// this one (0-based) as mono.
+	SourceChannel *int
+	// FadeMs ramps the first and last FadeMs of a session from and to
+	// silence, with FadeShape "cosine" (default) or "linear".
+	FadeMs    int
+	FadeShape string
+	// CaptureChannels is the channel count arecord captures and
+	// OutputChannels the count delivered; either defaults to Channels. The
+	// effective config reports both.
+	CaptureChannels int
+	OutputChannels  int
+	// Downmix picks how several channels become mono: "average" (default),
+	// "left", "right" or "max".
+	Downmix string
+	// SampleFormat names the sample encoding. When set, BytesPerSample is
+	// derived from it; the effective config always reports both.
+	SampleFormat SampleFormat
+}
+
+// delivered is the layout capture with cfg delivers: mono when a source
+// channel is picked out of a wider capture, then remapped to OutputChannels
+// when set. Callers pass the capture layout.
+func (cfg AudioConfig) delivered() AudioConfig {
+	captured := cfg.Channels
+	if cfg.SourceChannel != nil {
+		cfg.Channels = 1
+	}
+	if cfg.OutputChannels > 0 {
+		cfg.Channels = cfg.OutputChannels
+	}
+	cfg.CaptureChannels, cfg.OutputChannels = captured, cfg.Channels
+	cfg.SampleFormat = sampleFormats[cfg.BytesPerSample]
+	return cfg
+}
+
+// extractChannel copies channel ch out of interleaved pcm with the given
+// channel count and sample width, independent of bit depth.
+func extractChannel(pcm []byte, channels, bps, ch int) []byte {
+	frame := channels * bps
+	out := make([]byte, 0, len(pcm)/frame*bps)
+	for i := ch * bps; i+bps <= len(pcm); i += frame {
+		out = append(out, pcm[i:i+bps]...)
+	}
+	return out
+}
+
+// maxSecondsPerChunk bounds SecondsPerChunk, which sets the size of the
+// capture buffer and the wait before the first chunk.
+var maxSecondsPerChunk = 10.0
+
+// maxFallbackDevices bounds the Devices list.
+const maxFallbackDevices = 8
+
+// sameFormat reports whether a and b produce the same PCM stream layout,
+// whatever device it comes from.
+func (cfg AudioConfig) sameFormat(o AudioConfig) bool {
+	return cfg.SampleRate == o.SampleRate && cfg.Channels == o.Channels &&
+		cfg.BytesPerSample == o.BytesPerSample && cfg.SecondsPerChunk == o.SecondsPerChunk
+}
+
+// candidates lists the configs to try, one per device in fallback order.
+func (cfg AudioConfig) candidates() []AudioConfig {
+	var devices []string
+	if cfg.Device != "" || len(cfg.Devices) == 0 {
+		devices = append(devices, cfg.Device)
+	}
+	devices = append(devices, cfg.Devices...)
+	out := make([]AudioConfig, len(devices))
+	for i, d := range devices {
+		out[i] = cfg
+		out[i].Device, out[i].Devices = d, nil
+	}
+	return out
+}
+
+const defaultDevice = "hw:0,0"
+
+// NativeSampleRate asks StartAudioStream to capture at the device's preferred
+// rate. It is written as "native" in JSON.
+const NativeSampleRate = -1
+
+var (
+	hwDevicePattern    = regexp.MustCompile(`^(plug)?hw:[A-Za-z0-9_]+(,[0-9]+){0,2}$`)
+	alsaDevicePattern  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_:,=.\-]*$`)
+	pulseSourcePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.:\-]*$`)
+)
+
+// pulseDevicePrefix selects a PulseAudio source instead of an ALSA PCM.
+const pulseDevicePrefix = "pulse:"
+
+// ResolvedDevice returns the device string handed to arecord -D.
+func (cfg AudioConfig) ResolvedDevice() string {
+	device := cfg.Device
+	if device == "" {
+		device = defaultDevice
+	}
+	if cfg.UsePlug && strings.HasPrefix(device, "hw:") {
+		device = "plug" + device
+	}
+	return device
+}
+
+// Validate checks the optional arecord tuning fields.
+func (cfg AudioConfig) Validate() error {
+	if cfg.SampleRate < 0 && cfg.SampleRate != NativeSampleRate {
+		return errors.New(`sampleRate must be positive or "native"`)
+	}
+	if _, ok := sampleFormats[cfg.BytesPerSample]; cfg.BytesPerSample != 0 && !ok {
+		return errors.New("bytesPerSample must be 2, 3 or 4")
+	}
+	if err := validateSampleFormat(cfg.SampleFormat, cfg.BytesPerSample); err != nil {
+		return err
+	}
+	if cfg.SecondsPerChunk < 0 || cfg.SecondsPerChunk > maxSecondsPerChunk {
+		return fmt.Errorf("secondsPerChunk must be between 0 and %g", maxSecondsPerChunk)
+	}
+	if err := cfg.validateSizes(); err != nil {
+		return err
+	}
+	if cfg.PeriodFrames < 0 {
+		return errors.New("periodFrames must not be negative")
+	}
+	if cfg.BufferFrames < 0 {
+		return errors.New("bufferFrames must not be negative")
+	}
+	if cfg.PeriodFrames > 0 && cfg.BufferFrames > 0 && cfg.BufferFrames < 2*cfg.PeriodFrames {
+		return errors.New("bufferFrames must be at least twice periodFrames")
+	}
+	if cfg.RingSeconds < 0 || cfg.RingSeconds > maxRingSeconds {
+		return fmt.Errorf("ringSeconds must be between 0 and %d", maxRingSeconds)
+	}
+	if cfg.NoiseGate != nil {
+		if err := cfg.NoiseGate.validate(); err != nil {
+			return err
+		}
+	}
+	if err := cfg.validateChannels(); err != nil {
+		return err
+	}
+	if err := validateDownmix(cfg.Downmix); err != nil {
+		return err
+	}
+	if err := validateFade(cfg.FadeMs, cfg.FadeShape); err != nil {
+		return err
+	}
+	if len(cfg.Devices) > maxFallbackDevices {
+		return fmt.Errorf("at most %d fallback devices are allowed", maxFallbackDevices)
+	}
+	if len(cfg.Devices) > 0 {
+		for _, c := range cfg.candidates() {
+			if err := c.Validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	device := cfg.ResolvedDevice()
+	if source, ok := strings.CutPrefix(device, pulseDevicePrefix); ok {
+		if !pulseSourcePattern.MatchString(source) {
+			return fmt.Errorf("invalid pulse source %q", source)
+		}
+		return nil
+	}
+	if strings.HasPrefix(device, "hw:") || strings.HasPrefix(device, "plughw:") {
+		if !hwDevicePattern.MatchString(device) {
+			return fmt.Errorf("invalid device %q: expected hw:CARD[,DEV[,SUBDEV]]", device)
+		}
+	} else if !alsaDevicePattern.MatchString(device) {
+		return fmt.Errorf("invalid device %q", device)
+	}
+	return nil
+}
+
+// validateStart is Validate plus the fields capture cannot start without,
+// which Validate leaves optional so partial configs can be checked.
+func (cfg AudioConfig) validateStart() error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case cfg.SampleRate == 0:
+		return errors.New("sampleRate is not set")
+	case cfg.captureLayout().Channels <= 0:
+		return errors.New("channels is not set")
+	case cfg.BytesPerSample == 0:
+		return errors.New("bytesPerSample is not set")
+	case cfg.SecondsPerChunk <= 0:
+		return errors.New("secondsPerChunk is not set")
+	}
+	return nil
 }
 
 type AudioSession struct {
-    cmd      *exec.Cmd
-    stdout   io.ReadCloser
-    stopChan chan struct{}
-}
-
-func StartAudioStream(cfg AudioConfig, sendChunk func([]byte)) (*AudioSession, error) {
-    buf := make([]byte, int(float64(cfg.SampleRate)*cfg.SecondsPerChunk)*cfg.BytesPerSample)
-    session := &AudioSession{
-        stopChan: make(chan struct{}),
-    }
-    var err error
-    session.cmd = exec.Command("arecord",
-        "-D", "hw:0,0",
-        "-f", "S16_LE",
-        "-c", strconv.Itoa(cfg.Channels),
-        "-r", strconv.Itoa(cfg.SampleRate),
-        "-t", "raw",
-    )
-    session.stdout, err = session.cmd.StdoutPipe()
-    if err != nil {
-        return nil, err
-    }
-    if err := session.cmd.Start(); err != nil {
-        return nil, err
-    }
-    go func() {
-        for {
-            select {
-            case <-session.stopChan:
-                return
-            default:
-                _, err := io.ReadFull(session.stdout, buf)
-                if err != nil {
-                    log.Println("arecord read error:", err)
-                    return
-                }
-                wavBuf := wavChunk(buf, cfg.SampleRate, cfg.Channels, cfg.BytesPerSample)
-                sendChunk(wavBuf)
-                time.Sleep(time.Duration(cfg.SecondsPerChunk * float64(time.Second)))
-            }
-        }
-    }()
-    return session, nil
+	cmd      *exec.Cmd
+	stdout   io.ReadCloser
+	stopChan chan struct{}
+	// draining is closed when Stop asks arecord to exit cleanly, so the
+	// capture goroutine reads until EOF instead of stopping at once.
+	draining chan struct{}
+	cfg      AudioConfig
+	// capture is what arecord is asked for, which differs from cfg when a
+	// source channel is extracted.
+	capture AudioConfig
+	ring    *pcmRing
+	// playback names the file played in place of capture, if any.
+	playback string
+	// started, chunks and bytes back the session's metrics.
+	started time.Time
+	chunks  atomic.Uint64
+	bytes   atomic.Uint64
+	latency *latencyTracker
+	stderr  *tailBuffer
+	// outDepth, when non-zero, is the bytes per sample delivered in place of
+	// cfg's, converted after capture so arecord keeps running.
+	outDepth atomic.Int32
+	// done is closed when capture ends; err says why, and is nil after Stop.
+	done chan struct{}
+	err  error
+}
+
+// stopGrace is how long Stop waits for arecord to exit after SIGINT, so
+// the samples it still holds are delivered, before killing it. Zero kills
+// at once.
+var stopGrace time.Duration
+
+// maxStopGrace bounds stopGrace; Stop runs with the mic state locked.
+const maxStopGrace = 5 * time.Second
+
+// fadeStopGrace stands in for stopGrace when a session fades out, which needs
+// arecord's final chunk.
+const fadeStopGrace = 500 * time.Millisecond
+
+// stopWait bounds how long Stop waits, after killing arecord, for capture
+// to end, so the device is free for whatever opens it next.
+const stopWait = 2 * time.Second
+
+// startupProbe is how long StartAudioStream waits for arecord to fail on a
+// bad device or format before reporting the session as started.
+const startupProbe = 300 * time.Millisecond
+
+// arecordCommand builds the capture process for cfg.
+func arecordCommand(cfg AudioConfig) *exec.Cmd {
+	args, env := arecordArgs(cfg)
+	cmd := exec.Command("arecord", args...)
+	cmd.Env = env
+	return cmd
+}
+
+// arecordArgs returns the arecord arguments for cfg and, when non-nil, the
+// environment to run it with. Pulse sources are captured through the pulse
+// PCM with PULSE_SOURCE naming the source, so the rest of the pipeline sees
+// the same raw stream as any ALSA device.
+func arecordArgs(cfg AudioConfig) ([]string, []string) {
+	device := cfg.ResolvedDevice()
+	var env []string
+	if source, ok := strings.CutPrefix(device, pulseDevicePrefix); ok {
+		device = "pulse"
+		env = append(os.Environ(), "PULSE_SOURCE="+source)
+	}
+	args := []string{
+		"-D", device,
+		"-f", sampleFormat(cfg.BytesPerSample),
+		"-c", strconv.Itoa(cfg.Channels),
+		"-r", strconv.Itoa(cfg.SampleRate),
+		"-t", "raw",
+	}
+	if cfg.PeriodFrames > 0 {
+		args = append(args, "--period-size="+strconv.Itoa(cfg.PeriodFrames))
+	}
+	if cfg.BufferFrames > 0 {
+		args = append(args, "--buffer-size="+strconv.Itoa(cfg.BufferFrames))
+	}
+	return args, env
+}
+
+// StartAudioStream starts capture and calls sendChunk with each chunk of raw
+// PCM and the config it was captured with. Framing and encoding are left to
+// the receiver. With fallback Devices, each device is tried in order and
+// the first that opens is used; if none does, the error lists why each
+// failed.
+func StartAudioStream(cfg AudioConfig, sendChunk func(cfg AudioConfig, pcm []byte)) (*AudioSession, error) {
+	if err := cfg.validateStart(); err != nil {
+		return nil, &CaptureError{Code: ErrInvalidConfig, Err: err}
+	}
+	candidates := cfg.candidates()
+	if len(candidates) == 1 {
+		return startAudioStream(candidates[0], sendChunk)
+	}
+	var reasons []string
+	var code ErrorCode
+	for i, c := range candidates {
+		session, err := startAudioStream(c, sendChunk)
+		if err == nil {
+			if i > 0 {
+				log.Println("Capturing from fallback device", c.ResolvedDevice())
+			}
+			return session, nil
+		}
+		log.Printf("Device %s failed: %v", c.ResolvedDevice(), err)
+		reasons = append(reasons, c.ResolvedDevice()+": "+err.Error())
+		if i == 0 {
+			code = errorCodeOf(err)
+		} else if code != errorCodeOf(err) {
+			code = ErrRecorderExited
+		}
+	}
+	return nil, &CaptureError{Code: code, Err: errors.New("no capture device opened; " + strings.Join(reasons, "; "))}
+}
+
+func startAudioStream(cfg AudioConfig, sendChunk func(cfg AudioConfig, pcm []byte)) (*AudioSession, error) {
+	if cfg.SampleRate == NativeSampleRate {
+		rate, err := probeNativeRate(cfg)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("Capturing at native rate", rate)
+		cfg.SampleRate = rate
+		if err := cfg.validateSizes(); err != nil {
+			return nil, &CaptureError{Code: ErrFormatUnsupported, Err: fmt.Errorf("native rate %d: %w", rate, err)}
+		}
+	} else if err := checkDeviceRate(cfg); err != nil {
+		return nil, err
+	}
+	capture := cfg.captureLayout()
+	cfg = capture.delivered()
+	buf := make([]byte, chunkBytes(capture))
+	aligner := newFrameAligner(capture.Channels * capture.BytesPerSample)
+	var gate *noiseGate
+	if cfg.NoiseGate != nil {
+		gate = newNoiseGate(*cfg.NoiseGate, cfg.SampleRate, cfg.Channels, cfg.BytesPerSample)
+	}
+	session := &AudioSession{
+		stopChan: make(chan struct{}),
+		draining: make(chan struct{}),
+		cfg:      cfg,
+		capture:  capture,
+		started:  time.Now(),
+		stderr:   &tailBuffer{max: 4096},
+		done:     make(chan struct{}),
+	}
+	if cfg.RingSeconds > 0 {
+		session.ring = newPCMRing(cfg, cfg.RingSeconds)
+	}
+	if measureLatency {
+		session.latency = newLatencyTracker(time.Duration(cfg.SecondsPerChunk * float64(time.Second)))
+	}
+	var err error
+	session.cmd = arecordCommand(capture)
+	session.cmd.Stderr = session.stderr
+	session.stdout, err = session.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.cmd.Start(); err != nil {
+		return nil, captureError(err, "")
+	}
+	dump := newDebugDump(capture)
+	fade := newFader(cfg)
+	prepare := func(raw []byte) []byte {
+		pcm := aligner.Align(raw)
+		if dump != nil {
+			dump.Write(pcm)
+		}
+		pcm = toDelivered(capture, cfg, pcm)
+		if gate != nil {
+			gate.Process(pcm)
+		}
+		return pcm
+	}
+	emit := func(pcm []byte, readAt time.Time) {
+		if fade != nil {
+			fade.In(pcm)
+		}
+		if session.ring != nil {
+			session.ring.Write(pcm)
+		}
+		if session.latency != nil {
+			session.latency.observe(readAt, time.Now())
+		}
+		session.count(pcm)
+		sendChunk(session.outgoing(cfg, pcm))
+	}
+	go func() {
+		defer close(session.done)
+		if dump != nil {
+			defer dump.Close()
+		}
+		var held []byte
+		for {
+			select {
+			case <-session.stopChan:
+				session.cmd.Wait()
+				return
+			default:
+				n, err := readChunk(session.stdout, buf)
+				if err != nil {
+					select {
+					case <-session.stopChan:
+						session.cmd.Wait()
+						return
+					case <-session.draining:
+						// arecord flushed and exited after SIGINT; keep
+						// its last partial chunk and fade out the end
+						final := held
+						if n > 0 {
+							final = append(final, prepare(buf[:n])...)
+						}
+						if fade != nil {
+							fade.Out(final)
+						}
+						if len(final) > 0 {
+							emit(final, time.Now())
+						}
+						session.cmd.Wait()
+						return
+					default:
+					}
+					readErrorLog.Printf("arecord read error: %v", err)
+					if waitErr := session.cmd.Wait(); waitErr != nil {
+						err = waitErr
+					}
+					session.err = captureError(err, session.stderr.String())
+					return
+				}
+				select {
+				case <-session.draining:
+					// hold each chunk back until the next read shows
+					// whether it is the last, so it can be faded out
+					if held != nil {
+						emit(held, time.Now())
+					}
+					held = slices.Clone(prepare(buf))
+				default:
+					emit(prepare(buf), time.Now())
+				}
+			}
+		}
+	}()
+	select {
+	case <-session.done:
+		return nil, session.err
+	case <-time.After(startupProbe):
+	}
+	return session, nil
+}
+
+// Config returns the config capture is actually using, with any native
+// sample rate resolved and any live sample format change applied.
+func (s *AudioSession) Config() AudioConfig {
+	cfg := s.cfg
+	if bps := int(s.outDepth.Load()); bps != 0 {
+		cfg.BytesPerSample, cfg.SampleFormat = bps, sampleFormats[bps]
+	}
+	return cfg
+}
+
+// outgoing applies any live sample format change to a chunk of cfg's PCM,
+// returning the config and samples to deliver.
+func (s *AudioSession) outgoing(cfg AudioConfig, pcm []byte) (AudioConfig, []byte) {
+	if bps := int(s.outDepth.Load()); bps != 0 && bps != cfg.BytesPerSample {
+		pcm = convertDepth(pcm, cfg.BytesPerSample, bps)
+		cfg.BytesPerSample, cfg.SampleFormat = bps, sampleFormats[bps]
+	}
+	return cfg, pcm
+}
+
+// count tallies one chunk handed to listeners.
+func (s *AudioSession) count(pcm []byte) {
+	s.chunks.Add(1)
+	s.bytes.Add(uint64(len(pcm)))
+	chunksCaptured.Add(1)
+	bytesCaptured.Add(uint64(len(pcm)))
+}
+
+func (s *AudioSession) metrics() *SessionMetrics {
+	return &SessionMetrics{
+		StartedAt: s.started,
+		Seconds:   time.Since(s.started).Seconds(),
+		Chunks:    s.chunks.Load(),
+		Bytes:     s.bytes.Load(),
+	}
+}
+
+// Playback returns the file being played in place of capture, or "".
+func (s *AudioSession) Playback() string { return s.playback }
+
+// CaptureConfig returns the config arecord runs with.
+func (s *AudioSession) CaptureConfig() AudioConfig { return s.capture }
+
+// Done is closed when capture ends, either through Stop or because arecord
+// exited.
+func (s *AudioSession) Done() <-chan struct{} { return s.done }
+
+// Err reports why capture ended. It is nil while running and after Stop.
+func (s *AudioSession) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Dump returns the ring buffer contents as a single WAV file, or nil when
+// the session was started without a ring.
+func (s *AudioSession) Dump() []byte {
+	if s.ring == nil {
+		return nil
+	}
+	return wavChunk(s.ring.Snapshot(), s.cfg.SampleRate, s.cfg.Channels, s.cfg.BytesPerSample)
+}
+
+// Latency returns rolling latency stats, or nil when measurement is off.
+func (s *AudioSession) Latency() *LatencyStats {
+	if s.latency == nil {
+		return nil
+	}
+	return s.latency.stats()
 }
 
+// Stop ends capture and returns once arecord has exited, or after stopWait.
 func (s *AudioSession) Stop() {
-    close(s.stopChan)
-    if s.cmd != nil {
-        s.cmd.Process.Kill()
-    }
-}
-
-// wavChunk creates a WAV file in memory for a PCM chunk
-func wavChunk(pcm []byte, sampleRate, channels, bytesPerSample int) []byte {
-	dataLen := len(pcm)
-	blockAlign := channels * bytesPerSample
-	byteRate := sampleRate * blockAlign
-
-	buf := &bytes.Buffer{}
-	// RIFF header
-	buf.WriteString("RIFF")
-	binary.Write(buf, binary.LittleEndian, uint32(36+dataLen))
-	buf.WriteString("WAVE")
-	// fmt chunk
-	buf.WriteString("fmt ")
-	binary.Write(buf, binary.LittleEndian, uint32(16))               // Subchunk1Size
-	binary.Write(buf, binary.LittleEndian, uint16(1))                // AudioFormat PCM
-	binary.Write(buf, binary.LittleEndian, uint16(channels))         // NumChannels
-	binary.Write(buf, binary.LittleEndian, uint32(sampleRate))       // SampleRate
-	binary.Write(buf, binary.LittleEndian, uint32(byteRate))         // ByteRate
-	binary.Write(buf, binary.LittleEndian, uint16(blockAlign))       // BlockAlign
-	binary.Write(buf, binary.LittleEndian, uint16(bytesPerSample*8)) // BitsPerSample
-	// data chunk
-	buf.WriteString("data")
-	binary.Write(buf, binary.LittleEndian, uint32(dataLen))
-	buf.Write(pcm)
-	return buf.Bytes()
+	grace := stopGrace
+	if grace == 0 && s.cfg.FadeMs > 0 {
+		grace = fadeStopGrace
+	}
+	if grace > 0 && s.cmd != nil && s.cmd.Process != nil {
+		close(s.draining)
+		if err := s.cmd.Process.Signal(os.Interrupt); err == nil {
+			select {
+			case <-s.done:
+				slog.Debug("arecord exited cleanly on SIGINT")
+				return
+			case <-time.After(grace):
+				log.Printf("arecord still running %v after SIGINT, killing it", grace)
+			}
+		}
+	}
+	close(s.stopChan)
+	if s.cmd != nil {
+		s.cmd.Process.Kill()
+	}
+	select {
+	case <-s.done:
+	case <-time.After(stopWait):
+		log.Printf("Capture still running %v after stop", stopWait)
+	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeArecord puts a shell script named arecord first on PATH. It ignores
+// its arguments and runs body.
+func fakeArecord(t *testing.T, body string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "arecord"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// testCapture is 16 kHz mono 16-bit audio in 0.1 s chunks.
+var testCapture = AudioConfig{SampleRate: 16000, Channels: 1, BytesPerSample: 2, SecondsPerChunk: 0.1}
+
+// zeros writes 0.1 s of testCapture silence every 0.1 s.
+const zeros = "while :; do head -c 3200 /dev/zero; sleep 0.1; done"
+
+// stopTimed starts capture, waits for audio and stops it, returning how
+// long Stop took and how arecord exited.
+func stopTimed(t *testing.T, grace time.Duration) (time.Duration, syscall.WaitStatus) {
+	t.Helper()
+	defer func(g time.Duration) { stopGrace = g }(stopGrace)
+	stopGrace = grace
+	got := make(chan struct{}, 100)
+	s, err := StartAudioStream(testCapture, func(AudioConfig, []byte) { got <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-got:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no audio from the fake arecord")
+	}
+	start := time.Now()
+	s.Stop()
+	took := time.Since(start)
+	select {
+	case <-s.Done():
+	default:
+		// the next session would find the device still held
+		t.Error("Stop returned before arecord exited")
+		<-s.Done()
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("session ended with %v after Stop", err)
+	}
+	return took, s.cmd.ProcessState.Sys().(syscall.WaitStatus)
+}
+
+func TestStopGraceCleanExit(t *testing.T) {
+	fakeArecord(t, "trap 'exit 0' INT\n"+zeros)
+	took, status := stopTimed(t, 3*time.Second)
+	if status.Signaled() || status.ExitStatus() != 0 {
+		t.Fatalf("arecord exited with %v, want a clean exit", status)
+	}
+	if took >= time.Second {
+		t.Fatalf("Stop took %v, want it to return once arecord exits", took)
+	}
+}
+
+func TestStopGraceKillsAfterTimeout(t *testing.T) {
+	fakeArecord(t, "trap '' INT\n"+zeros)
+	took, status := stopTimed(t, 200*time.Millisecond)
+	if !status.Signaled() || status.Signal() != syscall.SIGKILL {
+		t.Fatalf("arecord exited with %v, want SIGKILL", status)
+	}
+	if took < 200*time.Millisecond {
+		t.Fatalf("Stop took %v, less than the grace period", took)
+	}
+}
+
+func TestStopWithoutGraceKills(t *testing.T) {
+	fakeArecord(t, zeros)
+	if _, status := stopTimed(t, 0); !status.Signaled() || status.Signal() != syscall.SIGKILL {
+		t.Fatalf("arecord exited with %v, want it killed", status)
+	}
+}
+
+func TestSecondsPerChunkLimit(t *testing.T) {
+	defer func(m float64) { maxSecondsPerChunk = m }(maxSecondsPerChunk)
+	maxSecondsPerChunk = 2
+	for _, tc := range []struct {
+		seconds float64
+		ok      bool
+	}{
+		{0.01, true},
+		{2, true},
+		{2.001, false},
+		{3600, false},
+		{-1, false},
+	} {
+		cfg := testCapture
+		cfg.SecondsPerChunk = tc.seconds
+		if err := cfg.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate with secondsPerChunk %g: %v, want ok %v", tc.seconds, err, tc.ok)
+		}
+	}
+	// an oversized chunk is refused before arecord runs or a buffer is
+	// allocated
+	fakeArecord(t, "exit 1")
+	cfg := testCapture
+	cfg.SecondsPerChunk = 3600
+	if _, err := StartAudioStream(cfg, func(AudioConfig, []byte) {}); errorCodeOf(err) != ErrInvalidConfig {
+		t.Fatalf("StartAudioStream with an hour-long chunk: %v, want %s", err, ErrInvalidConfig)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// flacRatio is the share of PCM size FLAC typically keeps for speech from
+// a desk mic. Real ratios depend on the audio: silence compresses far
+// better, noise barely at all.
+const flacRatio = 0.6
+
+// BandwidthRequest is the payload of mic-bandwidth: a mic config plus the
+// delivery options that change the size of what is sent.
+type BandwidthRequest struct {
+	Format      string `json:"format"`
+	CoalesceMs  int    `json:"coalesceMs"`
+	FrameHeader bool   `json:"frameHeader"`
+	Checksum    bool   `json:"checksum"`
+}
+
+// BandwidthPayload is the reply to mic-bandwidth.
+type BandwidthPayload struct {
+	Format string `json:"format"`
+	// PCMBytesPerSecond is sampleRate × channels × bytesPerSample of what
+	// is delivered.
+	PCMBytesPerSecond int64   `json:"pcmBytesPerSecond"`
+	FramesPerSecond   float64 `json:"framesPerSecond"`
+	// OverheadBytesPerFrame counts WAV, frame and websocket headers.
+	OverheadBytesPerFrame int   `json:"overheadBytesPerFrame"`
+	BytesPerSecond        int64 `json:"bytesPerSecond"`
+	BitsPerSecond         int64 `json:"bitsPerSecond"`
+	// Estimated is set for compressed formats, whose size depends on the
+	// audio.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// websocketHeaderSize is the framing a server adds to a binary message of
+// n bytes; server frames are not masked.
+func websocketHeaderSize(n int) int {
+	switch {
+	case n < 126:
+		return 2
+	case n <= math.MaxUint16:
+		return 4
+	}
+	return 10
+}
+
+// estimateBandwidth computes what delivering cfg in the requested format
+// costs on the wire.
+func estimateBandwidth(cfg AudioConfig, req BandwidthRequest) (BandwidthPayload, error) {
+	if cfg.SampleRate == NativeSampleRate {
+		return BandwidthPayload{}, errors.New(`sampleRate "native" is only known once capture starts; give a rate`)
+	}
+	if err := cfg.validateStart(); err != nil {
+		return BandwidthPayload{}, err
+	}
+	format := req.Format
+	if format == "" {
+		format = FormatWAVChunks
+	}
+	if !validFormat(format) {
+		return BandwidthPayload{}, errors.New("unknown format " + format)
+	}
+	out := cfg.captureLayout().delivered()
+	chunk := chunkBytes(out)
+	if chunk <= 0 {
+		return BandwidthPayload{}, errors.New("secondsPerChunk is too short for one frame at this rate")
+	}
+	// a chunk holds whole frames, so it can be shorter than secondsPerChunk;
+	// time it as delivery does
+	chunkSeconds := float64(chunk) / float64(out.byteRate())
+	n := 1
+	if req.CoalesceMs > 0 {
+		n = max(1, int(math.Ceil(float64(req.CoalesceMs)/(chunkSeconds*1000))))
+	}
+	frameSeconds := chunkSeconds * float64(n)
+	payload := chunk * n
+	p := BandwidthPayload{
+		Format:            format,
+		PCMBytesPerSecond: out.byteRate(),
+		FramesPerSecond:   1 / frameSeconds,
+	}
+	switch format {
+	case FormatWAVChunks:
+		p.OverheadBytesPerFrame = wavHeaderLen(out.Channels, out.BytesPerSample)
+	case FormatFLAC:
+		payload = int(float64(payload) * flacRatio)
+		p.Estimated = true
+	}
+	if req.FrameHeader {
+		p.OverheadBytesPerFrame += frameHeaderSize
+		if req.Checksum {
+			p.OverheadBytesPerFrame += 4
+		}
+	}
+	p.OverheadBytesPerFrame += websocketHeaderSize(payload + p.OverheadBytesPerFrame)
+	p.BytesPerSecond = int64(math.Round(float64(payload+p.OverheadBytesPerFrame) / frameSeconds))
+	p.BitsPerSecond = p.BytesPerSecond * 8
+	return p, nil
+}
+
+// parseBandwidthRequest reads a mic-bandwidth payload, estimating base when
+// it carries no config fields.
+func parseBandwidthRequest(payload json.RawMessage, base MicConfig) (MicConfig, BandwidthRequest, error) {
+	var req BandwidthRequest
+	if len(payload) == 0 {
+		return base, req, nil
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return MicConfig{}, req, err
+	}
+	var cfg MicConfig
+	if err := json.Unmarshal(payload, &cfg); err != nil {
+		return MicConfig{}, req, err
+	}
+	if cfg.isEmpty() {
+		cfg = base
+	}
+	return cfg, req, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateBandwidthWholeFrames(t *testing.T) {
+	// 0.0301 s at 44.1 kHz is 1327.41 frames, delivered as 1327
+	cfg := AudioConfig{SampleRate: 44100, Channels: 2, BytesPerSample: 3, SecondsPerChunk: 0.0301}
+	chunk := 1327 * 2 * 3
+	for _, tc := range []struct {
+		coalesceMs, n int
+	}{
+		{0, 1},
+		{120, 4},
+		// 40 chunks of 30.09 ms fall just short of 1204 ms
+		{1204, 41},
+	} {
+		p, err := estimateBandwidth(cfg, BandwidthRequest{Format: FormatRaw, CoalesceMs: tc.coalesceMs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		frameSeconds := float64(1327*tc.n) / 44100
+		if want := 1 / frameSeconds; math.Abs(p.FramesPerSecond-want) > 1e-9 {
+			t.Errorf("coalesce %d ms: %v frames per second, want %v", tc.coalesceMs, p.FramesPerSecond, want)
+		}
+		payload := chunk * tc.n
+		want := int64(math.Round(float64(payload+websocketHeaderSize(payload)) / frameSeconds))
+		if p.BytesPerSecond != want {
+			t.Errorf("coalesce %d ms: %d bytes per second, want %d", tc.coalesceMs, p.BytesPerSecond, want)
+		}
+	}
+}
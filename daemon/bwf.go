@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// bextSize is the fixed part of a version 1 Broadcast Wave bext chunk
+// (EBU Tech 3285), before the variable coding history.
+const bextSize = 602
+
+// bextInfo holds the bext fields the daemon fills in.
+type bextInfo struct {
+	Description string
+	Originator  string
+	// Start is when capture of the first sample began.
+	Start      time.Time
+	SampleRate int
+}
+
+// bextBody lays out a bext chunk body. Text fields are fixed-width ASCII,
+// NUL padded; the time reference counts samples since local midnight of
+// Start.
+func bextBody(info bextInfo) []byte {
+	buf := &bytes.Buffer{}
+	writeFixed(buf, info.Description, 256)
+	writeFixed(buf, info.Originator, 32)
+	writeFixed(buf, "", 32) // OriginatorReference
+	writeFixed(buf, info.Start.Format("2006-01-02"), 10)
+	writeFixed(buf, info.Start.Format("15:04:05"), 8)
+
+	midnight := time.Date(info.Start.Year(), info.Start.Month(), info.Start.Day(), 0, 0, 0, 0, info.Start.Location())
+	ref := uint64(info.Start.Sub(midnight).Seconds() * float64(info.SampleRate))
+	binary.Write(buf, binary.LittleEndian, uint32(ref))     // TimeReferenceLow
+	binary.Write(buf, binary.LittleEndian, uint32(ref>>32)) // TimeReferenceHigh
+	binary.Write(buf, binary.LittleEndian, uint16(1))       // Version
+	buf.Write(make([]byte, 64))                             // UMID
+	buf.Write(make([]byte, 190))                            // Reserved
+	return buf.Bytes()
+}
+
+// writeFixed writes s truncated or NUL padded to exactly n bytes.
+func writeFixed(buf *bytes.Buffer, s string, n int) {
+	b := make([]byte, n)
+	copy(b, s)
+	buf.Write(b)
+}
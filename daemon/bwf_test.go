@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBextBodyLayout(t *testing.T) {
+	start := time.Date(2024, 3, 9, 1, 2, 3, 0, time.UTC)
+	b := bextBody(bextInfo{
+		Description: "kitchen mic",
+		Originator:  strings.Repeat("o", 40),
+		Start:       start,
+		SampleRate:  48000,
+	})
+	if len(b) != bextSize {
+		t.Fatalf("body is %d bytes, want %d", len(b), bextSize)
+	}
+	field := func(off, n int) string { return strings.TrimRight(string(b[off:off+n]), "\x00") }
+	for _, tc := range []struct {
+		name   string
+		off, n int
+		want   string
+	}{
+		{"Description", 0, 256, "kitchen mic"},
+		{"Originator", 256, 32, strings.Repeat("o", 32)},
+		{"OriginatorReference", 288, 32, ""},
+		{"OriginationDate", 320, 10, "2024-03-09"},
+		{"OriginationTime", 330, 8, "01:02:03"},
+	} {
+		if got := field(tc.off, tc.n); got != tc.want {
+			t.Errorf("%s at %d = %q, want %q", tc.name, tc.off, got, tc.want)
+		}
+	}
+	ref := uint64(binary.LittleEndian.Uint32(b[338:])) | uint64(binary.LittleEndian.Uint32(b[342:]))<<32
+	if want := uint64(3723 * 48000); ref != want {
+		t.Errorf("TimeReference = %d, want %d", ref, want)
+	}
+	if v := binary.LittleEndian.Uint16(b[346:]); v != 1 {
+		t.Errorf("Version = %d, want 1", v)
+	}
+	for i, c := range b[348:] {
+		if c != 0 {
+			t.Fatalf("UMID/Reserved byte %d is %#x, want 0", 348+i, c)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import "fmt"
+
+// captureLayout is cfg as arecord is asked for it: CaptureChannels, when
+// set, replaces Channels.
+func (cfg AudioConfig) captureLayout() AudioConfig {
+	if cfg.CaptureChannels > 0 {
+		cfg.Channels = cfg.CaptureChannels
+	}
+	return cfg
+}
+
+// validateChannels checks the channel fields and that the conversion layer
+// can bridge the captured and delivered counts: equal counts, a downmix or
+// source channel to mono, or mono duplicated to any count.
+func (cfg AudioConfig) validateChannels() error {
+	if cfg.CaptureChannels < 0 || cfg.CaptureChannels > maxChannels ||
+		cfg.OutputChannels < 0 || cfg.OutputChannels > maxChannels {
+		return fmt.Errorf("captureChannels and outputChannels must be between 1 and %d", maxChannels)
+	}
+	captured := cfg.captureLayout().Channels
+	if sc := cfg.SourceChannel; sc != nil && (*sc < 0 || *sc >= captured) {
+		return fmt.Errorf("sourceChannel must be between 0 and %d for %d channel(s)", captured-1, captured)
+	}
+	if cfg.SourceChannel != nil {
+		// the extracted channel is mono, which upmixes to anything
+		return nil
+	}
+	out := cfg.OutputChannels
+	if out == 0 || out == captured || out == 1 || captured == 1 || captured == 0 {
+		return nil
+	}
+	return fmt.Errorf("no conversion from %d to %d channels: capture must be mono, output mono, or both the same", captured, out)
+}
+
+// Downmix strategies for turning several channels into mono.
+const (
+	// DownmixAverage averages every channel, right when each carries the
+	// same source.
+	DownmixAverage = "average"
+	// DownmixLeft and DownmixRight keep one channel, for a mic wired to
+	// only that side, which averaging would halve in level.
+	DownmixLeft  = "left"
+	DownmixRight = "right"
+	// DownmixMax keeps whichever channel is loudest in each frame.
+	DownmixMax = "max"
+)
+
+func validateDownmix(s string) error {
+	switch s {
+	case "", DownmixAverage, DownmixLeft, DownmixRight, DownmixMax:
+		return nil
+	}
+	return fmt.Errorf("unknown downmix %q; expected average, left, right or max", s)
+}
+
+// downmixFrame reduces one frame of samples to mono by strategy. right is
+// the second channel, falling back to the first for mono input.
+func downmixFrame(frame []int32, strategy string) int32 {
+	switch strategy {
+	case DownmixLeft:
+		return frame[0]
+	case DownmixRight:
+		return frame[min(1, len(frame)-1)]
+	case DownmixMax:
+		loudest := frame[0]
+		for _, v := range frame[1:] {
+			if abs64(int64(v)) > abs64(int64(loudest)) {
+				loudest = v
+			}
+		}
+		return loudest
+	}
+	var sum int64
+	for _, v := range frame {
+		sum += int64(v)
+	}
+	return int32(sum / int64(len(frame)))
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// toDelivered converts whole frames of pcm in capture's layout to out's:
+// the source channel, if any, is extracted and the result remapped to the
+// delivered channel count.
+func toDelivered(capture, out AudioConfig, pcm []byte) []byte {
+	if capture.SourceChannel != nil {
+		pcm = extractChannel(pcm, capture.Channels, capture.BytesPerSample, *capture.SourceChannel)
+		return remapChannels(pcm, 1, out.Channels, out.BytesPerSample, "")
+	}
+	return remapChannels(pcm, capture.Channels, out.Channels, out.BytesPerSample, out.Downmix)
+}
+
+// remapChannels converts interleaved pcm of any supported bit depth from in
+// to out channels, downmixing into mono by strategy or duplicating mono.
+// Other pairs are refused by validateChannels.
+func remapChannels(pcm []byte, in, out, bps int, strategy string) []byte {
+	if in == out || in <= 0 || out <= 0 {
+		return pcm
+	}
+	frames := len(pcm) / (in * bps)
+	dst := make([]byte, frames*out*bps)
+	frame := make([]int32, in)
+	for f := 0; f < frames; f++ {
+		switch {
+		case out == 1:
+			for c := range frame {
+				frame[c] = sampleAt(pcm, f*in+c, bps)
+			}
+			putSample(dst, f, bps, downmixFrame(frame, strategy))
+		case in == 1:
+			src := pcm[f*bps : (f+1)*bps]
+			for c := 0; c < out; c++ {
+				copy(dst[(f*out+c)*bps:], src)
+			}
+		}
+	}
+	return dst
+}
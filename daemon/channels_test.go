@@ -0,0 +1,83 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// interleave packs frames of equal-width channels into PCM of bps bytes.
+func interleave(bps int, channels ...[]int32) []byte {
+	frames := len(channels[0])
+	pcm := make([]byte, frames*len(channels)*bps)
+	for f := 0; f < frames; f++ {
+		for c, ch := range channels {
+			putSample(pcm, f*len(channels)+c, bps, ch[f])
+		}
+	}
+	return pcm
+}
+
+// samplesOf decodes every sample of pcm.
+func samplesOf(pcm []byte, bps int) []int32 {
+	out := make([]int32, len(pcm)/bps)
+	for i := range out {
+		out[i] = sampleAt(pcm, i, bps)
+	}
+	return out
+}
+
+func TestSourceChannel(t *testing.T) {
+	left := []int32{1, -2, 3, -4}
+	right := []int32{100, 200, -300, 400}
+	for _, bps := range []int{2, 3, 4} {
+		pcm := interleave(bps, left, right)
+		for ch, want := range [][]int32{left, right} {
+			capture := AudioConfig{Channels: 2, BytesPerSample: bps, SourceChannel: &ch}
+			out := capture.delivered()
+			if out.Channels != 1 {
+				t.Fatalf("sourceChannel %d delivers %d channels, want 1", ch, out.Channels)
+			}
+			if got := samplesOf(toDelivered(capture, out, pcm), bps); !slices.Equal(got, want) {
+				t.Errorf("%d bytes, channel %d: got %v, want %v", bps, ch, got, want)
+			}
+		}
+	}
+	two := 2
+	if err := (AudioConfig{Channels: 2, SourceChannel: &two}).validateChannels(); err == nil {
+		t.Error("sourceChannel 2 of a stereo capture was accepted")
+	}
+}
+
+func TestDownmixStrategies(t *testing.T) {
+	left := []int32{1000, -400, 30, 0}
+	right := []int32{200, -800, -60, 0}
+	for _, tc := range []struct {
+		strategy string
+		want     []int32
+	}{
+		{"", []int32{600, -600, -15, 0}},
+		{DownmixAverage, []int32{600, -600, -15, 0}},
+		{DownmixLeft, left},
+		{DownmixRight, right},
+		{DownmixMax, []int32{1000, -800, -60, 0}},
+	} {
+		for _, bps := range []int{2, 3, 4} {
+			got := samplesOf(remapChannels(interleave(bps, left, right), 2, 1, bps, tc.strategy), bps)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("%q at %d bytes: %v, want %v", tc.strategy, bps, got, tc.want)
+			}
+		}
+	}
+	// right falls back to the only channel of mono input
+	if got := downmixFrame([]int32{7}, DownmixRight); got != 7 {
+		t.Errorf("right of mono = %d, want 7", got)
+	}
+	// averaging full-scale channels does not overflow
+	full := []int32{1<<31 - 1, 1<<31 - 1}
+	if got := downmixFrame(full, DownmixAverage); got != 1<<31-1 {
+		t.Errorf("average of full scale = %d", got)
+	}
+	if err := validateDownmix("loudest"); err == nil {
+		t.Error("unknown downmix accepted")
+	}
+}
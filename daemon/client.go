@@ -0,0 +1,692 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DropPolicy decides what a client's writer does when its outbound queue is
+// full.
+type DropPolicy string
+
+const (
+	// DropOldest discards the oldest queued frame so the client stays current.
+	DropOldest DropPolicy = "drop-oldest"
+	// DropNewest discards the frame being queued.
+	DropNewest DropPolicy = "drop-newest"
+	// DropDisconnect closes the connection rather than lose any frame: when
+	// the queue fills, or when it stays above highWater for highWaterFor.
+	DropDisconnect DropPolicy = "disconnect"
+)
+
+func parseDropPolicy(s string) (DropPolicy, error) {
+	switch p := DropPolicy(s); p {
+	case "":
+		return DropOldest, nil
+	case DropOldest, DropNewest, DropDisconnect:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown drop policy %q", s)
+}
+
+// ClientStats counts what the server has delivered to one connection, so a
+// client can reconcile it against what it received.
+type ClientStats struct {
+	ChunksSent    uint64     `json:"chunksSent"`
+	BytesSent     uint64     `json:"bytesSent"`
+	ChunksDropped uint64     `json:"chunksDropped"`
+	ConnectedAt   time.Time  `json:"connectedAt"`
+	LastChunkAt   *time.Time `json:"lastChunkAt,omitempty"`
+	// Label is the name the client gave itself with mic-listen.
+	Label string `json:"label,omitempty"`
+	// Subprotocol and Extensions are what the handshake negotiated;
+	// OfferedExtensions is the client's Sec-WebSocket-Extensions header,
+	// which shows when a proxy strips an offer such as compression.
+	Subprotocol       string   `json:"subprotocol"`
+	Extensions        []string `json:"extensions"`
+	OfferedExtensions string   `json:"offeredExtensions,omitempty"`
+}
+
+// clientQueueSize bounds how many frames may wait for a slow client.
+const clientQueueSize = 32
+
+// highWater is the queue depth above which a disconnect-policy connection
+// counts as falling behind, and highWaterFor how long it may stay there
+// before it is closed; zero waits for the queue to fill.
+var (
+	highWater    = clientQueueSize * 3 / 4
+	highWaterFor time.Duration
+)
+
+// writeTimeout bounds each websocket write. Broadcasts only queue frames,
+// so a stalled peer never holds up other connections, but without a
+// deadline its writer would block on the full TCP buffer forever and the
+// connection would linger, dropping everything sent to it. Zero disables
+// the deadline.
+var writeTimeout = 10 * time.Second
+
+// closeTooSlow is the close reason sent to a disconnect-policy connection
+// that fell behind, with code 1008 (policy violation).
+const closeTooSlow = "too slow"
+
+type outFrame struct {
+	messageType int
+	data        []byte
+}
+
+// client owns a websocket connection. All writes go through its queue so
+// only writePump ever touches the connection's write side.
+type client struct {
+	id     uint64
+	conn   *websocket.Conn
+	remote string
+	// protocol is fixed at connect time.
+	protocol Protocol
+
+	mu      sync.Mutex
+	policy  DropPolicy
+	dropped uint64
+	subs    map[string]bool
+	stats   ClientStats
+	// highSince is when the queue last rose above highWater; tooSlow is
+	// set once the connection is being closed for falling behind.
+	highSince time.Time
+	tooSlow   bool
+
+	// encMu guards delivery state, which is driven from the capture
+	// goroutine.
+	encMu      sync.Mutex
+	format     string
+	config     *MicConfig
+	pipeline   *deliveryPipeline
+	encoder    Encoder
+	encoderCfg AudioConfig
+	// outCfg is the layout the encoder is fed.
+	outCfg AudioConfig
+	// framed prefixes audio frames with a header numbered by seq and
+	// stamped from clock; timeline places frames on the sample clock.
+	framed   bool
+	clock    FrameClock
+	seq      uint32
+	timeline timeline
+	// audioMeta announces every audio frame with an audio-meta message.
+	audioMeta bool
+	// checksum adds a CRC32 of each payload to its header and metadata.
+	checksum bool
+	// coalesceMs groups delivery chunks into frames of at least this long;
+	// coalesced holds chunks waiting to fill one.
+	coalesceMs     int
+	coalesceChunks int
+	coalesced      []byte
+	coalescedN     int
+	// vad gates delivery on speech when the connection listens with the
+	// vad trigger.
+	vad *vadTrigger
+	// power withholds audio during silence when the connection listens
+	// with powerSave.
+	power *powerSaver
+	// clipSeconds switches delivery to whole WAV clips of that length.
+	clipSeconds float64
+	clipRepeat  bool
+	// wavSize sets the size fields of WAV headers; empty keeps the
+	// format's default.
+	wavSize WAVSizePolicy
+	// lastAudio is when captured audio was last delivered; the silence
+	// pump, when running, fills gaps from silenceCfg until there is one.
+	lastAudio   time.Time
+	silenceCfg  AudioConfig
+	stopSilence chan struct{}
+
+	// listener is set once the connection sends mic-listen. Guarded by
+	// stateMu.
+	listener bool
+	// idle is the connection's idle timeout; zero keeps it open. Set
+	// before the connection is shared.
+	idle time.Duration
+	// initialConfig is the config given in the connect URL, used to start
+	// capture when mic-listen carries none. Set before the connection is
+	// shared.
+	initialConfig *MicConfig
+	// replyID is the id of the command being handled for this connection,
+	// echoed on what it is sent meanwhile. Guarded by stateMu.
+	replyID json.RawMessage
+
+	queue     chan outFrame
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(id uint64, conn *websocket.Conn, protocol Protocol) *client {
+	c := &client{
+		protocol: protocol,
+		id:       id,
+		conn:     conn,
+		remote:   conn.RemoteAddr().String(),
+		policy:   DropOldest,
+		subs:     defaultSubscriptions(),
+		queue:    make(chan outFrame, clientQueueSize),
+		done:     make(chan struct{}),
+		stats:    ClientStats{ConnectedAt: time.Now()},
+	}
+	go c.writePump()
+	return c
+}
+
+// setHandshake records what the websocket handshake negotiated.
+func (c *client) setHandshake(subprotocol, offered string, extensions []string) {
+	c.mu.Lock()
+	c.stats.Subprotocol = subprotocol
+	c.stats.OfferedExtensions = offered
+	c.stats.Extensions = extensions
+	c.mu.Unlock()
+}
+
+func (c *client) setPolicy(p DropPolicy) {
+	c.mu.Lock()
+	c.policy = p
+	c.mu.Unlock()
+}
+
+// Message kinds a connection can subscribe to.
+const (
+	SubState = "state"
+	SubLevel = "level"
+	SubVAD   = "vad"
+	SubAudio = "audio"
+)
+
+var subscriptionKinds = []string{SubState, SubLevel, SubVAD, SubAudio}
+
+// defaultSubscriptions subscribes to everything, matching connections that
+// never send mic-subscribe.
+func defaultSubscriptions() map[string]bool {
+	subs := make(map[string]bool, len(subscriptionKinds))
+	for _, kind := range subscriptionKinds {
+		subs[kind] = true
+	}
+	return subs
+}
+
+func validSubscription(kind string) bool {
+	for _, k := range subscriptionKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// setSubscribed turns delivery of each kind on or off.
+func (c *client) setSubscribed(kinds []string, on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, kind := range kinds {
+		c.subs[kind] = on
+	}
+}
+
+func (c *client) subscribed(kind string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subs[kind]
+}
+
+func (c *client) subscriptions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var kinds []string
+	for _, kind := range subscriptionKinds {
+		if c.subs[kind] {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+func (c *client) setStreaming(on bool) {
+	c.setSubscribed([]string{SubAudio}, on)
+}
+
+func (c *client) isStreaming() bool {
+	return c.subscribed(SubAudio)
+}
+
+// setFormat picks the output format; the encoder is rebuilt on the next
+// chunk.
+func (c *client) setFormat(format string) {
+	c.encMu.Lock()
+	c.format = format
+	c.dropEncoder()
+	c.encMu.Unlock()
+}
+
+// setConfig sets the config this connection's audio is delivered in,
+// independent of the shared capture config. nil delivers capture as is.
+func (c *client) setConfig(cfg *MicConfig) {
+	c.encMu.Lock()
+	c.config = cfg
+	c.dropEncoder()
+	c.pipeline = nil
+	c.encMu.Unlock()
+}
+
+// maxCoalesceMs bounds how long a connection may ask chunks to be held.
+const maxCoalesceMs = 5000
+
+// setCoalesce groups chunks into frames of at least ms milliseconds; zero
+// sends every chunk as it is ready.
+func (c *client) setCoalesce(ms int) {
+	c.encMu.Lock()
+	c.coalesceMs = ms
+	c.dropEncoder()
+	c.encMu.Unlock()
+}
+
+// frameInterval is how often, in milliseconds, this connection receives an
+// audio frame from capture in cfg.
+func (c *client) frameInterval(cfg AudioConfig) float64 {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	chunk, n := c.chunkTiming(c.deliveryConfig(cfg))
+	return chunk * float64(n)
+}
+
+// chunkTiming returns the duration of one delivery chunk of out in
+// milliseconds and how many chunks go into each frame. Callers hold encMu.
+func (c *client) chunkTiming(out AudioConfig) (float64, int) {
+	bytesPerMs := float64(out.SampleRate*out.Channels*out.BytesPerSample) / 1000
+	if bytesPerMs <= 0 {
+		return 0, 1
+	}
+	chunk := float64(chunkBytes(out)) / bytesPerMs
+	if c.coalesceMs <= 0 || chunk <= 0 {
+		return chunk, 1
+	}
+	return chunk, max(1, int(math.Ceil(float64(c.coalesceMs)/chunk)))
+}
+
+// dropEncoder discards the encoder, releasing anything it holds such as an
+// external process. Callers hold encMu.
+func (c *client) dropEncoder() {
+	if c.encoder != nil {
+		c.encoder.Close()
+		c.encoder = nil
+	}
+	c.coalesced, c.coalescedN = nil, 0
+}
+
+// finishStream closes a FLAC encoder when capture ends and returns its
+// final frames, so the connection receives a complete stream and the next
+// session starts a new one. Other formats carry on across sessions.
+func (c *client) finishStream() audioFrame {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	if c.encoder == nil || c.format != FormatFLAC {
+		return audioFrame{}
+	}
+	tail, err := c.encoder.Close()
+	if err != nil {
+		log.Printf("Client %s encoder close error: %v", c, err)
+	}
+	c.encoder = nil
+	return c.wrap(tail, 0, nil)
+}
+
+func (c *client) connectionConfig() *MicConfig {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	return c.config
+}
+
+// deliveryConfig overlays the connection's config on the capture config.
+// Fields the connection leaves unset follow capture.
+func (c *client) deliveryConfig(in AudioConfig) AudioConfig {
+	out := in
+	if c.config == nil {
+		return out
+	}
+	if c.config.SampleRate > 0 {
+		out.SampleRate = c.config.SampleRate
+	}
+	if c.config.OutputChannels > 0 {
+		out.Channels = c.config.OutputChannels
+	} else if c.config.Channels > 0 {
+		out.Channels = c.config.Channels
+	}
+	out.OutputChannels = out.Channels
+	if c.config.Downmix != "" {
+		out.Downmix = c.config.Downmix
+	}
+	if c.config.BytesPerSample > 0 {
+		out.BytesPerSample = c.config.BytesPerSample
+		out.SampleFormat = sampleFormats[out.BytesPerSample]
+	}
+	if c.config.SecondsPerChunk > 0 {
+		out.SecondsPerChunk = c.config.SecondsPerChunk
+	}
+	return out
+}
+
+// chunkSize is the size of the audio chunks delivered to this connection
+// from capture in cfg.
+func (c *client) chunkSize(cfg AudioConfig) int {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	return chunkBytes(c.deliveryConfig(cfg))
+}
+
+// setClip delivers clips of seconds each, once or repeatedly; zero returns
+// to streaming. Setting it again re-arms a one-shot clip.
+func (c *client) setClip(seconds float64, repeat bool) {
+	c.encMu.Lock()
+	c.clipSeconds, c.clipRepeat = seconds, repeat
+	c.dropEncoder()
+	c.encMu.Unlock()
+}
+
+// setTrigger gates delivery on v, or delivers everything when v is nil.
+func (c *client) setTrigger(v *vadTrigger) {
+	c.encMu.Lock()
+	c.vad = v
+	c.encMu.Unlock()
+}
+
+// gate splits a captured chunk into what this connection should receive.
+// Without a trigger that is the whole chunk.
+func (c *client) gate(cfg AudioConfig, pcm []byte) []vadPiece {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	if c.vad == nil {
+		return []vadPiece{{pcm: pcm}}
+	}
+	return c.vad.Process(cfg, pcm)
+}
+
+// drain encodes audio held back for chunking or coalescing, so a segment
+// is delivered in full when it ends.
+func (c *client) drain() ([]audioFrame, error) {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	if c.encoder == nil {
+		return nil, nil
+	}
+	rest := c.coalesced
+	if c.pipeline != nil {
+		rest = append(rest, c.pipeline.Flush()...)
+	}
+	c.coalesced, c.coalescedN = nil, 0
+	if len(rest) == 0 {
+		return nil, nil
+	}
+	frame, err := c.encoder.Encode(rest)
+	if f := c.wrap(frame, 0, rest); err == nil && f.data != nil {
+		return []audioFrame{f}, nil
+	}
+	return nil, err
+}
+
+// ensureEncoder creates the pipeline and encoder for audio captured with
+// cfg, rebuilding them when the capture config changes, and returns the
+// final frames of any encoder it replaced. Callers hold encMu.
+func (c *client) ensureEncoder(cfg AudioConfig) ([]audioFrame, error) {
+	var frames []audioFrame
+	if c.encoder == nil || !c.encoderCfg.sameFormat(cfg) {
+		if c.encoder != nil {
+			if tail, _ := c.encoder.Close(); tail != nil {
+				frames = append(frames, c.wrap(tail, 0, nil))
+			}
+		}
+		out := c.deliveryConfig(cfg)
+		c.pipeline = nil
+		if !out.sameFormat(cfg) {
+			if canConvert(cfg, out) {
+				c.pipeline = newDeliveryPipeline(cfg, out)
+				if out.SampleRate > cfg.SampleRate {
+					// resampling cannot restore what capture never had
+					log.Printf("Client %s: capture runs at %d Hz, upsampling to %d Hz; start capture at the highest rate any listener needs",
+						c, cfg.SampleRate, out.SampleRate)
+				}
+			} else {
+				log.Printf("Client %s: cannot convert %+v to %+v, delivering capture as is", c, cfg, out)
+				out = cfg
+			}
+		}
+		var enc Encoder
+		var err error
+		if c.clipSeconds > 0 {
+			enc = newClipEncoder(out, c.clipSeconds, c.clipRepeat)
+		} else {
+			enc, err = newEncoder(c.format, out)
+			if errors.Is(err, errFLACMissing) {
+				// the encoder went away after the format was accepted;
+				// keep audio flowing rather than leave a dead stream
+				log.Printf("Client %s: %v; falling back to %s", c, err, FormatWAVChunks)
+				c.sendEvent("warning", WarningPayload{
+					Code:    ErrFormatUnavailable,
+					Message: fmt.Sprintf("%s unavailable (%v); delivering %s", c.format, err, FormatWAVChunks),
+				})
+				c.format = FormatWAVChunks
+				enc, err = newEncoder(c.format, out)
+			}
+		}
+		if err != nil {
+			c.encoder = nil
+			return frames, err
+		}
+		if s, ok := enc.(wavSizer); ok && c.wavSize != "" {
+			s.setWAVSize(c.wavSize)
+		}
+		c.encoder, c.encoderCfg, c.outCfg = enc, cfg, out
+		_, c.coalesceChunks = c.chunkTiming(out)
+		c.coalesced, c.coalescedN = nil, 0
+	}
+	return frames, nil
+}
+
+// deliver converts pcm captured with cfg into this client's config and
+// encodes the resulting chunks. The pipeline and encoder are created on first
+// use and rebuilt when the capture config changes.
+func (c *client) deliver(cfg AudioConfig, pcm []byte) ([]audioFrame, error) {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	c.lastAudio = time.Now()
+	frames, err := c.ensureEncoder(cfg)
+	if err != nil {
+		return frames, err
+	}
+	chunks := [][]byte{pcm}
+	if c.pipeline != nil {
+		chunks = c.pipeline.Process(pcm)
+	}
+	for _, chunk := range chunks {
+		if c.coalesceChunks > 1 {
+			c.coalesced = append(c.coalesced, chunk...)
+			if c.coalescedN++; c.coalescedN < c.coalesceChunks {
+				continue
+			}
+			chunk, c.coalesced, c.coalescedN = c.coalesced, nil, 0
+		}
+		frame, err := c.encoder.Encode(chunk)
+		if err != nil {
+			return frames, err
+		}
+		if f := c.wrap(frame, 0, chunk); f.data != nil {
+			frames = append(frames, f)
+		}
+	}
+	return frames, nil
+}
+
+func (c *client) droppedCount() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// send queues a frame without blocking, applying the drop policy when the
+// queue is full.
+func (c *client) send(messageType int, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	frame := outFrame{messageType, data}
+	select {
+	case <-c.done:
+		return
+	case c.queue <- frame:
+		if c.policy == DropDisconnect {
+			c.checkHighWater()
+		}
+		return
+	default:
+	}
+	c.dropped++
+	switch c.policy {
+	case DropNewest:
+		c.countDropped(frame)
+	case DropDisconnect:
+		c.countDropped(frame)
+		c.disconnectTooSlow("queue full")
+	default:
+		select {
+		case old := <-c.queue:
+			c.countDropped(old)
+		default:
+		}
+		select {
+		case c.queue <- frame:
+		default:
+			c.countDropped(frame)
+		}
+	}
+}
+
+// checkHighWater closes the connection once its queue has stayed above
+// highWater for longer than highWaterFor. Callers hold c.mu.
+func (c *client) checkHighWater() {
+	if highWaterFor <= 0 {
+		return
+	}
+	if len(c.queue) <= highWater {
+		c.highSince = time.Time{}
+		return
+	}
+	now := time.Now()
+	if c.highSince.IsZero() {
+		c.highSince = now
+		return
+	}
+	if now.Sub(c.highSince) > highWaterFor {
+		c.disconnectTooSlow(fmt.Sprintf("queue above %d frames for %v", highWater, highWaterFor))
+	}
+}
+
+// disconnectTooSlow closes a connection that cannot keep up with code 1008
+// instead of dropping its audio. Callers hold c.mu.
+func (c *client) disconnectTooSlow(why string) {
+	if c.tooSlow {
+		return
+	}
+	c.tooSlow = true
+	log.Printf("Client %s too slow (%s), disconnecting", describeClient(c.id, c.stats.Label), why)
+	go c.closeWith(websocket.ClosePolicyViolation, closeTooSlow)
+}
+
+// countDropped tallies a discarded audio frame. Callers hold c.mu.
+func (c *client) countDropped(frame outFrame) {
+	if frame.messageType == websocket.BinaryMessage {
+		c.stats.ChunksDropped++
+	}
+}
+
+// flush discards every queued frame.
+func (c *client) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		select {
+		case <-c.queue:
+		default:
+			return
+		}
+	}
+}
+
+func (c *client) writePump() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case frame := <-c.queue:
+			if writeTimeout > 0 {
+				c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			}
+			if err := c.conn.WriteMessage(frame.messageType, frame.data); err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					log.Printf("Client %s write timed out after %v, disconnecting", c, writeTimeout)
+				} else {
+					log.Printf("Client %s write error: %v", c, err)
+				}
+				c.close()
+				return
+			}
+			slog.Debug("Frame written", "conn", c.id, "binary", frame.messageType == websocket.BinaryMessage, "bytes", len(frame.data))
+			if frame.messageType == websocket.BinaryMessage {
+				now := time.Now()
+				c.mu.Lock()
+				c.stats.ChunksSent++
+				c.stats.BytesSent += uint64(len(frame.data))
+				c.stats.LastChunkAt = &now
+				c.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (c *client) statsSnapshot() ClientStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// closed reports whether the connection has been closed from this side.
+func (c *client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeWait bounds how long closeWith waits to write the close frame.
+const closeWait = time.Second
+
+// closeWith sends a close frame with code and reason, then closes the
+// connection.
+func (c *client) closeWith(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWait)); err != nil {
+		log.Printf("Client %s close frame error: %v", c, err)
+	}
+	c.close()
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+		c.encMu.Lock()
+		c.dropEncoder()
+		c.encMu.Unlock()
+	})
+}
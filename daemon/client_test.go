@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testConn returns the server and client ends of a websocket connection.
+func testConn(t *testing.T) (server, peer *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(srv.Close)
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+srv.URL[len("http"):], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	server = <-accepted
+	t.Cleanup(func() { server.Close() })
+	return server, peer
+}
+
+// stalledClient is a client whose writer never runs, so its queue fills.
+func stalledClient(t *testing.T, policy DropPolicy) (*client, *websocket.Conn) {
+	t.Helper()
+	conn, peer := testConn(t)
+	c := &client{
+		id:     1,
+		conn:   conn,
+		remote: conn.RemoteAddr().String(),
+		policy: policy,
+		subs:   defaultSubscriptions(),
+		queue:  make(chan outFrame, clientQueueSize),
+		done:   make(chan struct{}),
+	}
+	return c, peer
+}
+
+// fillQueue queues clientQueueSize audio frames numbered from 0.
+func fillQueue(c *client) {
+	for i := 0; i < clientQueueSize; i++ {
+		c.send(websocket.BinaryMessage, []byte{byte(i)})
+	}
+}
+
+// queued drains c's queue and returns the frame numbers in order.
+func queued(c *client) []byte {
+	var out []byte
+	for len(c.queue) > 0 {
+		out = append(out, (<-c.queue).data[0])
+	}
+	return out
+}
+
+func TestDropOldest(t *testing.T) {
+	c, _ := stalledClient(t, DropOldest)
+	fillQueue(c)
+	c.send(websocket.BinaryMessage, []byte{clientQueueSize})
+	got := queued(c)
+	if len(got) != clientQueueSize || got[0] != 1 || got[len(got)-1] != clientQueueSize {
+		t.Fatalf("queue holds %v, want 1..%d", got, clientQueueSize)
+	}
+	if stats := c.statsSnapshot(); stats.ChunksDropped != 1 || c.droppedCount() != 1 {
+		t.Fatalf("dropped %d (%d chunks), want 1", c.droppedCount(), stats.ChunksDropped)
+	}
+}
+
+func TestDropNewest(t *testing.T) {
+	c, _ := stalledClient(t, DropNewest)
+	fillQueue(c)
+	c.send(websocket.BinaryMessage, []byte{clientQueueSize})
+	got := queued(c)
+	if len(got) != clientQueueSize || got[0] != 0 || got[len(got)-1] != clientQueueSize-1 {
+		t.Fatalf("queue holds %v, want 0..%d", got, clientQueueSize-1)
+	}
+	if stats := c.statsSnapshot(); stats.ChunksDropped != 1 || c.droppedCount() != 1 {
+		t.Fatalf("dropped %d (%d chunks), want 1", c.droppedCount(), stats.ChunksDropped)
+	}
+}
+
+// wantTooSlow reads from peer until the server closes it for being too slow.
+func wantTooSlow(t *testing.T, peer *websocket.Conn) {
+	t.Helper()
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := peer.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != websocket.ClosePolicyViolation || ce.Text != closeTooSlow {
+			t.Fatalf("read error %v, want close %d %q", err, websocket.ClosePolicyViolation, closeTooSlow)
+		}
+		return
+	}
+}
+
+func TestDropDisconnect(t *testing.T) {
+	c, peer := stalledClient(t, DropDisconnect)
+	fillQueue(c)
+	c.send(websocket.BinaryMessage, []byte{clientQueueSize})
+	wantTooSlow(t, peer)
+	if stats := c.statsSnapshot(); stats.ChunksDropped != 1 {
+		t.Fatalf("dropped %d chunks, want 1", stats.ChunksDropped)
+	}
+}
+
+func TestParseDropPolicy(t *testing.T) {
+	for in, want := range map[string]DropPolicy{"": DropOldest, "drop-oldest": DropOldest, "drop-newest": DropNewest, "disconnect": DropDisconnect} {
+		if got, err := parseDropPolicy(in); err != nil || got != want {
+			t.Errorf("parseDropPolicy(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseDropPolicy("block"); err == nil {
+		t.Error("parseDropPolicy accepted an unknown policy")
+	}
+}
+
+// backlog queues frames until the queue is just above highWater.
+func backlog(c *client) {
+	for len(c.queue) <= highWater {
+		c.send(websocket.BinaryMessage, []byte{byte(len(c.queue))})
+	}
+}
+
+func TestHighWaterDisconnect(t *testing.T) {
+	defer func(d time.Duration) { highWaterFor = d }(highWaterFor)
+	highWaterFor = 50 * time.Millisecond
+	c, peer := stalledClient(t, DropDisconnect)
+	backlog(c)
+	time.Sleep(2 * highWaterFor)
+	c.send(websocket.BinaryMessage, []byte{0})
+	wantTooSlow(t, peer)
+	if stats := c.statsSnapshot(); stats.ChunksDropped != 0 {
+		t.Fatalf("dropped %d chunks, want the connection closed with none lost", stats.ChunksDropped)
+	}
+}
+
+func TestHighWaterRecovers(t *testing.T) {
+	defer func(d time.Duration) { highWaterFor = d }(highWaterFor)
+	highWaterFor = 50 * time.Millisecond
+	c, _ := stalledClient(t, DropDisconnect)
+	backlog(c)
+	// the writer catches up before the deadline
+	for len(c.queue) > highWater/2 {
+		<-c.queue
+	}
+	time.Sleep(2 * highWaterFor)
+	backlog(c)
+	c.mu.Lock()
+	closed := c.tooSlow
+	c.mu.Unlock()
+	if closed {
+		t.Fatal("closed a connection whose queue dropped below the high-water mark")
+	}
+}
+
+func TestHighWaterIgnoredByDropOldest(t *testing.T) {
+	defer func(d time.Duration) { highWaterFor = d }(highWaterFor)
+	highWaterFor = 50 * time.Millisecond
+	c, _ := stalledClient(t, DropOldest)
+	backlog(c)
+	time.Sleep(2 * highWaterFor)
+	fillQueue(c)
+	c.mu.Lock()
+	closed := c.tooSlow
+	c.mu.Unlock()
+	if closed {
+		t.Fatal("drop-oldest connection was closed for being slow")
+	}
+	if c.droppedCount() == 0 {
+		t.Fatal("drop-oldest connection dropped nothing from a full queue")
+	}
+}
+
+func TestTeeTwoConfigsFromOneCapture(t *testing.T) {
+	capture := AudioConfig{SampleRate: 48000, Channels: 2, BytesPerSample: 2, SecondsPerChunk: 0.1}
+	recorder := &client{format: FormatPCMChunks}
+	transcriber := &client{format: FormatPCMChunks, config: &MicConfig{SampleRate: 16000, Channels: 1}}
+
+	// one second of a 1 kHz stereo tone, captured in 0.1 s chunks
+	const amp = 10000
+	mono := tone(1000, amp, 48000)
+	var sent, raw, converted []byte
+	for i := 0; i < 10; i++ {
+		frames := mono[i*4800 : (i+1)*4800]
+		pcm := interleave(2, toInt32(frames), toInt32(frames))
+		sent = append(sent, pcm...)
+		for _, tc := range []struct {
+			c   *client
+			out *[]byte
+		}{{recorder, &raw}, {transcriber, &converted}} {
+			frames, err := tc.c.deliver(capture, pcm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range frames {
+				*tc.out = append(*tc.out, f.data...)
+			}
+		}
+	}
+
+	if !bytes.Equal(raw, sent) {
+		t.Fatalf("recorder got %d bytes differing from the %d captured", len(raw), len(sent))
+	}
+	if got := transcriber.outCfg; got.SampleRate != 16000 || got.Channels != 1 {
+		t.Fatalf("transcriber fed %d Hz %d channel(s), want 16000 Hz mono", got.SampleRate, got.Channels)
+	}
+	// a third of the frames at half the channels, less what the
+	// converter still holds
+	if want := len(sent) / 6; len(converted) > want || len(converted) < want-2*3200 {
+		t.Fatalf("transcriber got %d bytes, want about %d", len(converted), want)
+	}
+	var sum float64
+	samples := samplesOf(converted, 2)
+	for _, s := range samples[len(samples)/2:] {
+		sum += float64(s) * float64(s)
+	}
+	if rms := math.Sqrt(sum / float64(len(samples)/2)); math.Abs(rms-amp/math.Sqrt2) > amp*0.05 {
+		t.Fatalf("transcriber tone rms %.0f, want about %.0f", rms, amp/math.Sqrt2)
+	}
+}
+
+func toInt32(s []int16) []int32 {
+	out := make([]int32, len(s))
+	for i, v := range s {
+		out[i] = int32(v)
+	}
+	return out
+}
+
+func TestWriteTimeoutIsolatesSlowClient(t *testing.T) {
+	defer func(d time.Duration) { writeTimeout = d }(writeTimeout)
+	writeTimeout = 200 * time.Millisecond
+
+	slowConn, _ := testConn(t) // its peer never reads
+	healthyConn, healthyPeer := testConn(t)
+	slow := newClient(1, slowConn, ProtocolV2)
+	healthy := newClient(2, healthyConn, ProtocolV2)
+	clientsMu.Lock()
+	clients[slow], clients[healthy] = struct{}{}, struct{}{}
+	clientsMu.Unlock()
+	t.Cleanup(func() {
+		clientsMu.Lock()
+		delete(clients, slow)
+		delete(clients, healthy)
+		clientsMu.Unlock()
+		slow.close()
+		healthy.close()
+	})
+
+	// more than the socket buffers hold, so the slow writer blocks
+	big := make([]byte, 1<<20)
+	for i := 0; i < 16; i++ {
+		slow.send(websocket.BinaryMessage, big)
+	}
+
+	start := time.Now()
+	stateMu.Lock()
+	broadcastState()
+	stateMu.Unlock()
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Fatalf("broadcastState took %v with a stalled client", took)
+	}
+	healthyPeer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, msg, err := healthyPeer.ReadMessage(); err != nil || !bytes.Contains(msg, []byte(`"state"`)) {
+		t.Fatalf("healthy client read %s, %v; want the state", msg, err)
+	}
+
+	select {
+	case <-slow.done:
+	case <-time.After(writeTimeout + 2*time.Second):
+		t.Fatal("stalled client was not closed after the write timeout")
+	}
+	if healthy.closed() {
+		t.Fatal("healthy client was closed")
+	}
+}
+
+func TestDeliveryChunksUnaligned(t *testing.T) {
+	capture := AudioConfig{SampleRate: 16000, Channels: 1, BytesPerSample: 2, SecondsPerChunk: 0.1}
+	// 0.0237 s is 379.2 frames, which divides neither the capture chunk
+	// nor a second
+	out := MicConfig{SampleRate: 16000, Channels: 1, BytesPerSample: 2, SecondsPerChunk: 0.0237}
+	const size = 379 * 2
+	for _, coalesceMs := range []int{0, 50} {
+		c := &client{format: FormatPCMChunks, config: &out, coalesceMs: coalesceMs}
+		perFrame := 1
+		if coalesceMs > 0 {
+			perFrame = 3 // ceil(50 / 23.6875)
+		}
+		var sent, got []byte
+		for i := 0; i < 10; i++ {
+			pcm := make([]byte, 3200)
+			for j := range pcm {
+				pcm[j] = byte(i*3200 + j)
+			}
+			sent = append(sent, pcm...)
+			frames, err := c.deliver(capture, pcm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range frames {
+				if len(f.data) != perFrame*size {
+					t.Fatalf("coalesce %d: frame of %d bytes, want %d", coalesceMs, len(f.data), perFrame*size)
+				}
+				got = append(got, f.data...)
+			}
+		}
+		if want := len(sent) / (perFrame * size) * perFrame * size; len(got) != want {
+			t.Fatalf("coalesce %d: delivered %d bytes, want %d", coalesceMs, len(got), want)
+		}
+		if !bytes.Equal(got, sent[:len(got)]) {
+			t.Fatalf("coalesce %d: delivered audio is not the capture in order", coalesceMs)
+		}
+	}
+}
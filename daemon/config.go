@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// micConfigJSON mirrors MicConfig without its methods so the custom
+// marshalling below can reuse the default field handling.
+type micConfigJSON MicConfig
+
+// UnmarshalJSON accepts "native" for sampleRate in addition to a number,
+// expands a preset before applying the fields set alongside it, and
+// derives bytesPerSample from sampleFormat.
+func (c *MicConfig) UnmarshalJSON(data []byte) error {
+	var named struct {
+		Preset         string       `json:"preset"`
+		BytesPerSample *int         `json:"bytesPerSample"`
+		SampleFormat   SampleFormat `json:"sampleFormat"`
+	}
+	if err := json.Unmarshal(data, &named); err != nil {
+		return err
+	}
+	if named.SampleFormat != "" {
+		explicit := 0
+		if named.BytesPerSample != nil {
+			explicit = *named.BytesPerSample
+		}
+		if err := validateSampleFormat(named.SampleFormat, explicit); err != nil {
+			return err
+		}
+	}
+	if named.Preset != "" {
+		preset, ok := presets[named.Preset]
+		if !ok {
+			return fmt.Errorf("unknown preset %q; expected one of %s", named.Preset, strings.Join(presetNames(), ", "))
+		}
+		*c = preset
+	}
+	aux := struct {
+		*micConfigJSON
+		SampleRate json.RawMessage `json:"sampleRate"`
+	}{micConfigJSON: (*micConfigJSON)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if f := named.SampleFormat; f != "" {
+		c.BytesPerSample = sampleFormatSpecs[f].bytes
+	}
+	if len(aux.SampleRate) == 0 || string(aux.SampleRate) == "null" {
+		return nil
+	}
+	var name string
+	if json.Unmarshal(aux.SampleRate, &name) == nil {
+		if name != "native" {
+			return fmt.Errorf("unknown sampleRate %q", name)
+		}
+		c.SampleRate = NativeSampleRate
+		return nil
+	}
+	return json.Unmarshal(aux.SampleRate, &c.SampleRate)
+}
+
+// MarshalJSON writes the native sample rate sentinel as "native".
+func (c MicConfig) MarshalJSON() ([]byte, error) {
+	if c.SampleRate != NativeSampleRate {
+		return json.Marshal(micConfigJSON(c))
+	}
+	return json.Marshal(struct {
+		micConfigJSON
+		SampleRate string `json:"sampleRate"`
+	}{micConfigJSON(c), "native"})
+}
+
+// queryConfig overlays the rate, channels and device query parameters of a
+// websocket URL on base. A device with defaults takes them in place of
+// base's values, before rate and channels apply. It returns nil when none
+// of the parameters is present. Callers hold stateMu.
+func queryConfig(q url.Values, base MicConfig) (*MicConfig, error) {
+	cfg := base
+	set := false
+	if v := q.Get("device"); v != "" {
+		if defaults, ok := deviceDefaults[v]; ok {
+			cfg = MicConfig{Device: v}
+			fillUnset(&cfg, defaults)
+			fillUnset(&cfg, base)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"rate", &cfg.SampleRate}, {"channels", &cfg.Channels}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", p.name, v)
+			}
+			*p.dst = n
+			set = true
+		}
+	}
+	if v := q.Get("device"); v != "" {
+		cfg.Device, cfg.Devices = v, nil
+		set = true
+	}
+	if !set {
+		return nil, nil
+	}
+	if err := AudioConfig(cfg).Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import "encoding/binary"
+
+// deliveryPipeline adapts the shared capture stream to one connection's own
+// config: bit depth, channel count, sample rate and chunk duration. Settings that only
+// make sense at capture time (device, period sizes) are ignored.
+type deliveryPipeline struct {
+	in, out   AudioConfig
+	resampler rateConverter
+	pending   []byte
+	chunkSize int
+}
+
+func newDeliveryPipeline(in, out AudioConfig) *deliveryPipeline {
+	p := &deliveryPipeline{in: in, out: out, chunkSize: chunkBytes(out)}
+	if in.SampleRate != out.SampleRate {
+		p.resampler = newResampler(in.SampleRate, out.SampleRate, out.Channels)
+	}
+	return p
+}
+
+// canConvert reports whether the pipeline can bridge in and out. Channel
+// and rate conversion work on 16-bit samples, so deeper audio is dithered
+// down on the way in and widened again on the way out.
+func canConvert(in, out AudioConfig) bool {
+	_, inOK := sampleFormats[in.BytesPerSample]
+	_, outOK := sampleFormats[out.BytesPerSample]
+	return inOK && outOK &&
+		in.Channels > 0 && out.Channels > 0 && in.SampleRate > 0 && out.SampleRate > 0
+}
+
+// Process converts one captured chunk and returns the delivery chunks that
+// are now complete.
+func (p *deliveryPipeline) Process(pcm []byte) [][]byte {
+	samples := decodePCM(pcm, p.in.BytesPerSample)
+	samples = convertChannels(samples, p.in.Channels, p.out.Channels, p.out.Downmix)
+	if p.resampler != nil {
+		samples = p.resampler.Process(samples)
+	}
+	p.pending = append(p.pending, encodePCM(samples, p.out.BytesPerSample)...)
+	if p.chunkSize <= 0 {
+		out := p.pending
+		p.pending = nil
+		return [][]byte{out}
+	}
+	var chunks [][]byte
+	for len(p.pending) >= p.chunkSize {
+		chunk := make([]byte, p.chunkSize)
+		copy(chunk, p.pending)
+		p.pending = p.pending[p.chunkSize:]
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// Flush returns the partial chunk still pending, e.g. at the end of a
+// speech segment.
+func (p *deliveryPipeline) Flush() []byte {
+	out := p.pending
+	p.pending = nil
+	return out
+}
+
+func decodePCM16(pcm []byte) []int16 {
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+	}
+	return samples
+}
+
+func encodePCM16(samples []int16) []byte {
+	pcm := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(s))
+	}
+	return pcm
+}
+
+// convertChannels remaps interleaved samples from inCh to outCh channels.
+// Downmixing to mono follows strategy, upmixing from mono duplicates it,
+// and other layouts keep the leading channels and fill the rest with
+// silence.
+func convertChannels(samples []int16, inCh, outCh int, strategy string) []int16 {
+	if inCh == outCh {
+		return samples
+	}
+	frames := len(samples) / inCh
+	out := make([]int16, frames*outCh)
+	frame := make([]int32, inCh)
+	for f := 0; f < frames; f++ {
+		in := samples[f*inCh : (f+1)*inCh]
+		dst := out[f*outCh : (f+1)*outCh]
+		switch {
+		case outCh == 1:
+			for c, s := range in {
+				frame[c] = int32(s)
+			}
+			dst[0] = int16(downmixFrame(frame, strategy))
+		case inCh == 1:
+			for c := range dst {
+				dst[c] = in[0]
+			}
+		default:
+			copy(dst, in)
+		}
+	}
+	return out
+}
+
+// resampler converts interleaved 16-bit audio between rates with linear
+// interpolation, carrying its position and the previous frame across calls
+// so chunk boundaries are seamless.
+type resampler struct {
+	inRate, outRate int
+	channels        int
+	// pos is the fractional input position of the next output frame,
+	// relative to the start of the current input.
+	pos  float64
+	last []int16
+}
+
+// rateConverter changes the sample rate of interleaved 16-bit audio,
+// keeping whatever state it needs across calls.
+type rateConverter interface {
+	Process(samples []int16) []int16
+}
+
+// newResampler returns a converter from inRate to outRate. 48 kHz to 16 kHz
+// gets the filtered decimator; any other pair falls back to interpolation.
+func newResampler(inRate, outRate, channels int) rateConverter {
+	if inRate == 48000 && outRate == 16000 {
+		return newDecimator(channels)
+	}
+	return &resampler{inRate: inRate, outRate: outRate, channels: channels}
+}
+
+func (r *resampler) Process(samples []int16) []int16 {
+	ch := r.channels
+	// Prepend the last frame of the previous call so interpolation can
+	// reach back across the boundary.
+	in := samples
+	offset := 0.0
+	if r.last != nil {
+		in = append(append([]int16{}, r.last...), samples...)
+		offset = 1
+	}
+	frames := len(in) / ch
+	if frames == 0 {
+		return nil
+	}
+	step := float64(r.inRate) / float64(r.outRate)
+	var out []int16
+	pos := r.pos + offset
+	for ; pos <= float64(frames-1); pos += step {
+		i := int(pos)
+		frac := pos - float64(i)
+		for c := 0; c < ch; c++ {
+			a := float64(in[i*ch+c])
+			b := a
+			if i+1 < frames {
+				b = float64(in[(i+1)*ch+c])
+			}
+			out = append(out, int16(a+(b-a)*frac))
+		}
+	}
+	r.pos = pos - float64(frames-1) - 1
+	r.last = append(r.last[:0], in[(frames-1)*ch:frames*ch]...)
+	return out
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// CaptureCommand is the reply to mic-debug-cmd: the arecord invocation the
+// daemon would run, ready to paste into a shell.
+type CaptureCommand struct {
+	Program string   `json:"program"`
+	Args    []string `json:"args"`
+	// Env lists variables set on top of the daemon's environment.
+	Env         []string `json:"env,omitempty"`
+	CommandLine string   `json:"commandLine"`
+	// Running reports whether this is the command of the running session
+	// rather than a preview of the configured one.
+	Running bool `json:"running"`
+	// RateUnprobed reports that a native sample rate was shown as 48000
+	// because the device has not been probed yet.
+	RateUnprobed bool `json:"rateUnprobed,omitempty"`
+	// Fallbacks are the commands for the next devices of a Devices list,
+	// in the order capture tries them when this one fails to open.
+	Fallbacks []CaptureCommand `json:"fallbacks,omitempty"`
+}
+
+// captureCommand describes the capture process for cfg without starting it:
+// the command for the first device capture tries, with the rest of a
+// Devices list as fallbacks.
+func captureCommand(cfg AudioConfig) CaptureCommand {
+	candidates := cfg.candidates()
+	cmd := deviceCommand(candidates[0])
+	for _, c := range candidates[1:] {
+		cmd.Fallbacks = append(cmd.Fallbacks, deviceCommand(c))
+	}
+	return cmd
+}
+
+// deviceCommand describes the capture process for cfg's single device. A
+// native sample rate is resolved from the cached device probe only, so
+// describing a command never opens the device.
+func deviceCommand(cfg AudioConfig) CaptureCommand {
+	unprobed := false
+	if cfg.SampleRate == NativeSampleRate {
+		cfg.SampleRate, unprobed = 48000, true
+		if params, ok := cachedHWParams(cfg); ok {
+			if rate, err := nativeRate(params); err == nil {
+				cfg.SampleRate, unprobed = rate, false
+			}
+		}
+	}
+	args, env := arecordArgs(cfg)
+	var extra []string
+	base := os.Environ()
+	for _, kv := range env {
+		if !slices.Contains(base, kv) {
+			extra = append(extra, kv)
+		}
+	}
+	words := append(slices.Clone(extra), "arecord")
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return CaptureCommand{
+		Program:      "arecord",
+		Args:         args,
+		Env:          extra,
+		CommandLine:  strings.Join(words, " "),
+		RateUnprobed: unprobed,
+	}
+}
+
+// shellQuote quotes s for a POSIX shell when it contains anything beyond
+// plain word characters.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=:,./+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
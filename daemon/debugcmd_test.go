@@ -0,0 +1,37 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCaptureCommandDevices(t *testing.T) {
+	base := AudioConfig{SampleRate: 16000, Channels: 1, BytesPerSample: 2, SecondsPerChunk: 0.1}
+	device := func(cmd CaptureCommand) string {
+		if i := slices.Index(cmd.Args, "-D"); i >= 0 && i+1 < len(cmd.Args) {
+			return cmd.Args[i+1]
+		}
+		return ""
+	}
+	for _, tc := range []struct {
+		device  string
+		devices []string
+		want    []string
+	}{
+		{"", nil, []string{"hw:0,0"}},
+		{"plughw:1,0", nil, []string{"plughw:1,0"}},
+		{"", []string{"plughw:1,0", "hw:2,0"}, []string{"plughw:1,0", "hw:2,0"}},
+		{"hw:3,0", []string{"plughw:1,0"}, []string{"hw:3,0", "plughw:1,0"}},
+	} {
+		cfg := base
+		cfg.Device, cfg.Devices = tc.device, tc.devices
+		cmd := captureCommand(cfg)
+		got := []string{device(cmd)}
+		for _, f := range cmd.Fallbacks {
+			got = append(got, device(f))
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("device %q, devices %v: commands open %v, want %v", tc.device, tc.devices, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// secretFlags are reported as set or unset, never by value.
+var secretFlags = map[string]bool{"admin-token": true}
+
+// DebugConfig is the body of GET /debug/config: everything needed to
+// reproduce a setup in a bug report.
+type DebugConfig struct {
+	Flags         map[string]string `json:"flags"`
+	Addr          string            `json:"addr"`
+	DefaultConfig MicConfig         `json:"defaultConfig"`
+	CurrentConfig MicConfig         `json:"currentConfig"`
+	State         MicState          `json:"state"`
+	Error         string            `json:"error,omitempty"`
+	// Session is present while capture is running.
+	Session     *DebugSession     `json:"session,omitempty"`
+	Connections []DebugConnection `json:"connections"`
+	Build       DebugBuild        `json:"build"`
+}
+
+type DebugSession struct {
+	Config          MicConfig `json:"config"`
+	EffectiveConfig MicConfig `json:"effectiveConfig"`
+	Device          string    `json:"device"`
+	Owner           uint64    `json:"owner,omitempty"`
+	Recording       string    `json:"recording,omitempty"`
+}
+
+type DebugConnection struct {
+	ID               uint64      `json:"id"`
+	Remote           string      `json:"remote"`
+	Protocol         Protocol    `json:"protocol"`
+	Subscriptions    []string    `json:"subscriptions"`
+	ConnectionConfig *MicConfig  `json:"connectionConfig,omitempty"`
+	Stats            ClientStats `json:"stats"`
+}
+
+type DebugBuild struct {
+	GoVersion string `json:"goVersion"`
+	Module    string `json:"module,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// serverAddr is the address the websocket server was started on.
+var serverAddr string
+
+// handleDebugConfig reports the daemon's effective configuration. It is
+// read-only and guarded by the admin token.
+func handleDebugConfig(w http.ResponseWriter, r *http.Request) {
+	out := DebugConfig{
+		Flags: make(map[string]string),
+		Addr:  serverAddr,
+		Build: buildInfo(),
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "(set)"
+		}
+		out.Flags[f.Name] = value
+	})
+
+	stateMu.Lock()
+	out.DefaultConfig = defaultMicConfig
+	out.CurrentConfig = currentConfig
+	out.State = micState
+	out.Error = micError
+	if audioSession != nil {
+		effective := MicConfig(audioSession.Config())
+		out.Session = &DebugSession{
+			Config:          sessionConfig,
+			EffectiveConfig: effective,
+			Device:          audioSession.Config().ResolvedDevice(),
+			Owner:           sessionOwner,
+			Recording:       recordingPath(),
+		}
+	}
+	stateMu.Unlock()
+
+	clientsMu.Lock()
+	for c := range clients {
+		out.Connections = append(out.Connections, DebugConnection{
+			ID:               c.id,
+			Remote:           c.remote,
+			Protocol:         c.protocol,
+			Subscriptions:    c.subscriptions(),
+			ConnectionConfig: c.connectionConfig(),
+			Stats:            c.statsSnapshot(),
+		})
+	}
+	clientsMu.Unlock()
+	sort.Slice(out.Connections, func(i, j int) bool { return out.Connections[i].ID < out.Connections[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+}
+
+func buildInfo() DebugBuild {
+	b := DebugBuild{GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Module, b.Version = info.Main.Path, info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
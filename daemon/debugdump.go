@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// debugDumpDir, when set, receives a raw copy of every session's capture.
+// It is a support tool, separate from mic-record-start.
+var debugDumpDir string
+
+const (
+	// maxDebugDumps is how many sessions are kept; older dumps are
+	// removed as new ones start.
+	maxDebugDumps = 8
+	// maxDebugDumpBytes caps one session's dump.
+	maxDebugDumpBytes = 512 << 20
+)
+
+// debugDumpInfo is written next to each dump so the raw bytes can be read
+// back, e.g. with sox or Audacity's raw import.
+type debugDumpInfo struct {
+	StartedAt time.Time      `json:"startedAt"`
+	Config    MicConfig      `json:"config"`
+	Format    string         `json:"format"`
+	Command   CaptureCommand `json:"command"`
+}
+
+// debugDump writes the bytes arecord produced, before any processing.
+type debugDump struct {
+	file    *os.File
+	path    string
+	written int64
+	full    bool
+}
+
+// newDebugDump starts a dump for capture with cfg, or returns nil when
+// dumping is off or the files cannot be created.
+func newDebugDump(cfg AudioConfig) *debugDump {
+	if debugDumpDir == "" {
+		return nil
+	}
+	start := time.Now()
+	base := filepath.Join(debugDumpDir, "capture-"+start.Format("20060102-150405.000"))
+	info, _ := json.MarshalIndent(debugDumpInfo{
+		StartedAt: start,
+		Config:    MicConfig(cfg),
+		Format:    sampleFormat(cfg.BytesPerSample),
+		Command:   captureCommand(cfg),
+	}, "", "  ")
+	if err := os.WriteFile(base+".json", info, 0o644); err != nil {
+		log.Println("DEBUG dump disabled for this session:", err)
+		return nil
+	}
+	file, err := os.OpenFile(base+".pcm", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		log.Println("DEBUG dump disabled for this session:", err)
+		return nil
+	}
+	log.Printf("DEBUG dump: writing raw capture to %s.pcm (%s, %d Hz, %d channel(s))",
+		base, sampleFormat(cfg.BytesPerSample), cfg.SampleRate, cfg.Channels)
+	pruneDebugDumps()
+	return &debugDump{file: file, path: base + ".pcm"}
+}
+
+// Write appends raw capture, stopping at maxDebugDumpBytes or on the first
+// write error.
+func (d *debugDump) Write(pcm []byte) {
+	if d.full {
+		return
+	}
+	if d.written+int64(len(pcm)) > maxDebugDumpBytes {
+		log.Printf("DEBUG dump: %s reached %d bytes, no longer writing", d.path, maxDebugDumpBytes)
+		d.full = true
+		return
+	}
+	n, err := d.file.Write(pcm)
+	d.written += int64(n)
+	if err != nil {
+		log.Printf("DEBUG dump: write to %s failed: %v", d.path, err)
+		d.full = true
+	}
+}
+
+func (d *debugDump) Close() {
+	if err := d.file.Close(); err != nil {
+		log.Printf("DEBUG dump: close %s: %v", d.path, err)
+	}
+}
+
+// pruneDebugDumps removes the oldest dumps beyond maxDebugDumps. Names sort
+// by start time.
+func pruneDebugDumps() {
+	dumps, err := filepath.Glob(filepath.Join(debugDumpDir, "capture-*.pcm"))
+	if err != nil || len(dumps) <= maxDebugDumps {
+		return
+	}
+	slices.Sort(dumps)
+	for _, old := range dumps[:len(dumps)-maxDebugDumps] {
+		base := strings.TrimSuffix(old, ".pcm")
+		for _, path := range []string{old, base + ".json"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Println("DEBUG dump: cleanup:", err)
+			}
+		}
+	}
+	log.Printf("DEBUG dump: removed %d old dump(s)", len(dumps)-maxDebugDumps)
+}
+
+// checkDebugDumpDir makes sure the dump directory exists at startup.
+func checkDebugDumpDir() error {
+	if debugDumpDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(debugDumpDir, 0o755); err != nil {
+		return fmt.Errorf("-debug-dump-dir: %w", err)
+	}
+	log.Println("DEBUG: raw capture of every session is saved to", debugDumpDir)
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// tone is frames of a 48 kHz mono sine at freq Hz and amplitude amp.
+func tone(freq, amp float64, frames int) []int16 {
+	out := make([]int16, frames)
+	for i := range out {
+		out[i] = int16(math.Round(amp * math.Sin(2*math.Pi*freq*float64(i)/48000)))
+	}
+	return out
+}
+
+// gainDB decimates a tone, in uneven chunks, and returns the output level
+// relative to the input once the filter has filled.
+func gainDB(t *testing.T, freq float64) float64 {
+	t.Helper()
+	const amp = 16000
+	in := tone(freq, amp, 48000)
+	d := newDecimator(1)
+	var out []int16
+	for rest := in; len(rest) > 0; {
+		n := min(len(rest), 1237)
+		out = append(out, d.Process(rest[:n])...)
+		rest = rest[n:]
+	}
+	if len(out) != len(in)/3 {
+		t.Fatalf("%g Hz: %d output frames from %d, want a third", freq, len(out), len(in))
+	}
+	var sum float64
+	settled := out[decimateTaps:]
+	for _, s := range settled {
+		sum += float64(s) * float64(s)
+	}
+	rms := math.Sqrt(sum / float64(len(settled)))
+	return 20 * math.Log10(rms/(amp/math.Sqrt2))
+}
+
+func TestDecimatorFrequencyResponse(t *testing.T) {
+	for _, freq := range []float64{100, 1000, 3000, 6000} {
+		if g := gainDB(t, freq); math.Abs(g) > 0.5 {
+			t.Errorf("passband %g Hz: %.2f dB, want flat within 0.5 dB", freq, g)
+		}
+	}
+	if g := gainDB(t, 8000); g > -40 {
+		t.Errorf("8 kHz: %.1f dB, want at most -40 dB", g)
+	}
+	for _, freq := range []float64{9000, 12000, 16000, 20000} {
+		if g := gainDB(t, freq); g > -60 {
+			t.Errorf("stopband %g Hz: %.1f dB, want at most -60 dB", freq, g)
+		}
+	}
+}
+
+func TestDecimatorChunkSeams(t *testing.T) {
+	in := tone(440, 12000, 4800)
+	whole := newDecimator(1).Process(in)
+	d := newDecimator(1)
+	var pieces []int16
+	for _, n := range []int{1, 2, 3, 500, 7, 4287} {
+		pieces = append(pieces, d.Process(in[:n])...)
+		in = in[n:]
+	}
+	if !slices.Equal(whole, pieces) {
+		t.Fatal("chunked decimation differs from decimating the whole buffer")
+	}
+}
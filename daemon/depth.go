@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// SampleFormat names a PCM sample encoding. The sample width, the arecord
+// format and the WAV header fields all follow from it, so they cannot
+// disagree.
+type SampleFormat string
+
+const (
+	SampleS16LE SampleFormat = "s16le"
+	// SampleS24LE is 24-bit packed into three bytes.
+	SampleS24LE SampleFormat = "s24le"
+	SampleS32LE SampleFormat = "s32le"
+	// sampleF32LE is named only to refuse it clearly.
+	sampleF32LE SampleFormat = "f32le"
+)
+
+// sampleFormatSpec is what a SampleFormat fixes.
+type sampleFormatSpec struct {
+	bytes int
+	// alsa is the arecord -f name.
+	alsa string
+	// wavFormat and bits are the WAV fmt chunk's AudioFormat and
+	// BitsPerSample.
+	wavFormat uint16
+	bits      int
+}
+
+// sampleFormatSpecs lists the supported encodings, all signed
+// little-endian integers. Float samples (f32le, WAV format tag 3) are not
+// offered: every stage from levels to mixing identifies an encoding by its
+// width, and f32le shares its 4 bytes with s32le.
+var sampleFormatSpecs = map[SampleFormat]sampleFormatSpec{
+	SampleS16LE: {bytes: 2, alsa: "S16_LE", wavFormat: 1, bits: 16},
+	SampleS24LE: {bytes: 3, alsa: "S24_3LE", wavFormat: 1, bits: 24},
+	SampleS32LE: {bytes: 4, alsa: "S32_LE", wavFormat: 1, bits: 32},
+}
+
+// sampleFormats maps bytes per sample to the encoding of that width.
+var sampleFormats = func() map[int]SampleFormat {
+	m := make(map[int]SampleFormat, len(sampleFormatSpecs))
+	for f, spec := range sampleFormatSpecs {
+		m[spec.bytes] = f
+	}
+	return m
+}()
+
+// sampleFormatNames lists the supported encodings in width order.
+func sampleFormatNames() []string {
+	return []string{string(SampleS16LE), string(SampleS24LE), string(SampleS32LE)}
+}
+
+// specFor returns the encoding of bytesPerSample, defaulting to 16-bit.
+func specFor(bytesPerSample int) sampleFormatSpec {
+	if f, ok := sampleFormats[bytesPerSample]; ok {
+		return sampleFormatSpecs[f]
+	}
+	return sampleFormatSpecs[SampleS16LE]
+}
+
+// sampleFormat returns the arecord format for bytesPerSample, defaulting to
+// 16-bit.
+func sampleFormat(bytesPerSample int) string {
+	return specFor(bytesPerSample).alsa
+}
+
+// validateSampleFormat checks that a named format is supported and, when
+// bytesPerSample is also set, that the two agree.
+func validateSampleFormat(f SampleFormat, bytesPerSample int) error {
+	if f == "" {
+		return nil
+	}
+	spec, ok := sampleFormatSpecs[f]
+	if f == sampleF32LE {
+		return fmt.Errorf("sampleFormat %s is not supported; float samples are not offered, use %s", f, SampleS32LE)
+	}
+	if !ok {
+		return fmt.Errorf("unknown sampleFormat %q; expected one of %s", f, strings.Join(sampleFormatNames(), ", "))
+	}
+	if bytesPerSample != 0 && bytesPerSample != spec.bytes {
+		return fmt.Errorf("bytesPerSample %d disagrees with sampleFormat %s, which has %d", bytesPerSample, f, spec.bytes)
+	}
+	return nil
+}
+
+// decodePCM reads little-endian samples of bps bytes and reduces them to
+// 16 bits. Deeper samples are rounded with TPDF dither, which trades the
+// correlated distortion of plain truncation for a small amount of noise.
+func decodePCM(pcm []byte, bps int) []int16 {
+	if bps == 2 {
+		return decodePCM16(pcm)
+	}
+	samples := make([]int16, len(pcm)/bps)
+	for i := range samples {
+		samples[i] = ditherTo16(sampleAt(pcm, i, bps), bps)
+	}
+	return samples
+}
+
+// ditherTo16 rounds v, a sample of bps bytes, to 16 bits with triangular
+// dither of one 16-bit step peak to peak either side.
+func ditherTo16(v int32, bps int) int16 {
+	return int16(ditherTo(v, bps, 2))
+}
+
+// ditherTo rounds v, a sample of from bytes, to a sample of to bytes with
+// triangular dither of one output step peak to peak either side.
+func ditherTo(v int32, from, to int) int32 {
+	shift := uint(8 * (from - to))
+	step := int64(1) << shift
+	dither := rand.Int64N(step) - rand.Int64N(step)
+	r := (int64(v) + dither + step/2) >> shift
+	limit := int64(1) << (8*to - 1)
+	return int32(min(max(r, -limit), limit-1))
+}
+
+// convertDepth rewrites samples of from bytes as samples of to bytes.
+// Widening shifts into the high bits, so it adds no resolution; narrowing
+// is dithered as in decodePCM.
+func convertDepth(pcm []byte, from, to int) []byte {
+	n := len(pcm) / from
+	out := make([]byte, n*to)
+	for i := 0; i < n; i++ {
+		v := sampleAt(pcm, i, from)
+		if to > from {
+			v <<= uint(8 * (to - from))
+		} else {
+			v = ditherTo(v, from, to)
+		}
+		putSample(out, i, to, v)
+	}
+	return out
+}
+
+// encodePCM writes 16-bit samples as little-endian samples of bps bytes,
+// widening by shifting into the high bits.
+func encodePCM(samples []int16, bps int) []byte {
+	if bps == 2 {
+		return encodePCM16(samples)
+	}
+	pcm := make([]byte, bps*len(samples))
+	for i, s := range samples {
+		switch bps {
+		case 3:
+			v := uint32(int32(s) << 8)
+			pcm[3*i], pcm[3*i+1], pcm[3*i+2] = byte(v), byte(v>>8), byte(v>>16)
+		case 4:
+			binary.LittleEndian.PutUint32(pcm[4*i:], uint32(int32(s)<<16))
+		}
+	}
+	return pcm
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestConvert24To16(t *testing.T) {
+	const n = 20000
+	// 10.25 steps of 16-bit, a value plain truncation would bias
+	const v = 10*256 + 64
+	pcm := make([]byte, 3*n)
+	for i := 0; i < n; i++ {
+		putSample(pcm, i, 3, v)
+	}
+	samples := decodePCM(pcm, 3)
+	if len(samples) != n {
+		t.Fatalf("decoded %d samples, want %d", len(samples), n)
+	}
+	sum := 0.0
+	for _, s := range samples {
+		if math.Abs(float64(s)-v/256.0) >= 1.5 {
+			t.Fatalf("sample %d is more than one step from %v", s, v/256.0)
+		}
+		sum += float64(s)
+	}
+	if mean := sum / n; math.Abs(mean-v/256.0) > 0.02 {
+		t.Errorf("mean %v, want %v: rounding is biased", mean, v/256.0)
+	}
+
+	// full scale saturates instead of wrapping
+	putSample(pcm, 0, 3, 1<<23-1)
+	putSample(pcm, 1, 3, -1<<23)
+	if s := decodePCM(pcm[:6], 3); s[0] != math.MaxInt16 || s[1] != math.MinInt16 {
+		t.Errorf("full scale converted to %v", s)
+	}
+}
+
+func TestConvertDepthWidens(t *testing.T) {
+	pcm := make([]byte, 4)
+	putSample(pcm, 0, 2, 1234)
+	putSample(pcm, 1, 2, -32768)
+	out := convertDepth(pcm, 2, 3)
+	if a, b := sampleAt(out, 0, 3), sampleAt(out, 1, 3); a != 1234<<8 || b != -1<<23 {
+		t.Errorf("widened to %d, %d", a, b)
+	}
+	if back := convertDepth(out, 3, 2); sampleAt(back, 0, 2) < 1233 || sampleAt(back, 0, 2) > 1235 {
+		t.Errorf("round trip gave %d, want 1234 within one step", sampleAt(back, 0, 2))
+	}
+}
+
+func TestSampleFormatDerivedFields(t *testing.T) {
+	defer func(f string) { wavFormat = f }(wavFormat)
+	wavFormat = WAVFormatPCM
+	for _, tc := range []struct {
+		format    SampleFormat
+		bytes     int
+		alsa      string
+		wavFormat uint16
+		bits      int
+	}{
+		{SampleS16LE, 2, "S16_LE", 1, 16},
+		{SampleS24LE, 3, "S24_3LE", 1, 24},
+		{SampleS32LE, 4, "S32_LE", 1, 32},
+	} {
+		spec := sampleFormatSpecs[tc.format]
+		if spec.bytes != tc.bytes || spec.alsa != tc.alsa || spec.wavFormat != tc.wavFormat || spec.bits != tc.bits {
+			t.Errorf("%s: %+v", tc.format, spec)
+		}
+		if sampleFormats[tc.bytes] != tc.format || sampleFormat(tc.bytes) != tc.alsa {
+			t.Errorf("%d bytes maps to %s, %s", tc.bytes, sampleFormats[tc.bytes], sampleFormat(tc.bytes))
+		}
+		if err := validateSampleFormat(tc.format, tc.bytes); err != nil {
+			t.Errorf("%s with %d bytes: %v", tc.format, tc.bytes, err)
+		}
+		if err := validateSampleFormat(tc.format, tc.bytes%4+2); err == nil {
+			t.Errorf("%s accepted with a disagreeing bytesPerSample", tc.format)
+		}
+		// the header written for the format carries the same fields
+		h := wavChunk(nil, 48000, 2, tc.bytes)
+		if got := binary.LittleEndian.Uint16(h[20:]); got != tc.wavFormat {
+			t.Errorf("%s: header format tag %#x", tc.format, got)
+		}
+		if got := binary.LittleEndian.Uint16(h[34:]); int(got) != tc.bits {
+			t.Errorf("%s: header BitsPerSample %d, want %d", tc.format, got, tc.bits)
+		}
+		if got := binary.LittleEndian.Uint16(h[32:]); int(got) != 2*tc.bytes {
+			t.Errorf("%s: header BlockAlign %d, want %d", tc.format, got, 2*tc.bytes)
+		}
+	}
+	if err := validateSampleFormat("f32le", 0); err == nil || !strings.Contains(err.Error(), "s32le") {
+		t.Errorf("f32le: %v, want a refusal pointing to s32le", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+)
+
+// deviceDefaults maps an ALSA device name to the settings a config naming
+// that device starts from, e.g. 16 kHz mono for a headset and 48 kHz
+// stereo for an interface. It comes from the -config file. Guarded by
+// stateMu.
+var deviceDefaults map[string]MicConfig
+
+// withDeviceDefaults fills the fields cfg leaves at their zero value from
+// the defaults of the device it names. Device, Devices and Preset are
+// never filled, so a default cannot redirect capture elsewhere. Callers
+// hold stateMu.
+func withDeviceDefaults(cfg MicConfig) MicConfig {
+	if defaults, ok := deviceDefaults[cfg.Device]; cfg.Device != "" && ok {
+		fillUnset(&cfg, defaults)
+	}
+	return cfg
+}
+
+// fillUnset copies into cfg every field it leaves at the zero value from
+// src, except Device, Devices and Preset. A set bytesPerSample keeps src's
+// sampleFormat out, since the two must agree.
+func fillUnset(cfg *MicConfig, src MicConfig) {
+	depthSet := cfg.BytesPerSample != 0
+	dst := reflect.ValueOf(cfg).Elem()
+	from := reflect.ValueOf(src)
+	for i := 0; i < dst.NumField(); i++ {
+		switch dst.Type().Field(i).Name {
+		case "Device", "Devices", "Preset":
+			continue
+		case "SampleFormat":
+			if depthSet {
+				continue
+			}
+		}
+		if f := dst.Field(i); f.IsZero() {
+			f.Set(from.Field(i))
+		}
+	}
+}
+
+// validateDeviceDefaults checks every entry as a partial config.
+func validateDeviceDefaults(m map[string]MicConfig) error {
+	for device, cfg := range m {
+		if device == "" {
+			return fmt.Errorf("deviceDefaults: device name must not be empty")
+		}
+		if err := AudioConfig(cfg).Validate(); err != nil {
+			return fmt.Errorf("deviceDefaults %q: %w", device, err)
+		}
+	}
+	return nil
+}
+
+// logDeviceDefaultsChange logs which devices gained, lost or changed
+// defaults between old and next.
+func logDeviceDefaultsChange(source string, old, next map[string]MicConfig) {
+	devices := map[string]bool{}
+	for d := range old {
+		devices[d] = true
+	}
+	for d := range next {
+		devices[d] = true
+	}
+	names := make([]string, 0, len(devices))
+	for d := range devices {
+		names = append(names, d)
+	}
+	sort.Strings(names)
+	for _, d := range names {
+		before, had := old[d]
+		after, has := next[d]
+		switch {
+		case !has:
+			log.Printf("%s: removed defaults for %s", source, d)
+		case !had || !reflect.DeepEqual(before, after):
+			js, _ := json.Marshal(after)
+			log.Printf("%s: defaults for %s set to %s", source, d, js)
+		}
+	}
+}
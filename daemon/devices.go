@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CaptureDevice is one capture PCM reported by arecord -l.
+type CaptureDevice struct {
+	ID          string `json:"id"` // hw:CARD,DEV
+	Card        int    `json:"card"`
+	Device      int    `json:"device"`
+	CardName    string `json:"cardName"`
+	Description string `json:"description"`
+}
+
+// arecord -l lines look like:
+// card 1: Device [USB Audio Device], device 0: USB Audio [USB Audio]
+var arecordListPattern = regexp.MustCompile(`^card (\d+): \S+ \[(.*)\], device (\d+): .*\[(.*)\]$`)
+
+var (
+	devicesMu      sync.Mutex
+	captureDevices []CaptureDevice
+)
+
+// probeCaptureDevices re-enumerates capture hardware and caches the result.
+func probeCaptureDevices() ([]CaptureDevice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "arecord", "-l").Output()
+	if err != nil {
+		return nil, err
+	}
+	devices := parseCaptureDevices(out)
+	devicesMu.Lock()
+	changed := !slices.Equal(devices, captureDevices)
+	captureDevices = devices
+	devicesMu.Unlock()
+	if changed {
+		hwParamsMu.Lock()
+		clear(hwParamsCache)
+		hwParamsMu.Unlock()
+	}
+	return devices, nil
+}
+
+// Availability is the reply to mic-available.
+type Availability struct {
+	Available bool `json:"available"`
+	Count     int  `json:"count"`
+	// Error says why enumeration failed, e.g. arecord is missing.
+	Error string `json:"error,omitempty"`
+}
+
+// micAvailability enumerates capture hardware and reports whether there is
+// any.
+func micAvailability() Availability {
+	devices, err := probeCaptureDevices()
+	if err != nil {
+		return Availability{Error: captureError(err, "").Error()}
+	}
+	return Availability{Available: len(devices) > 0, Count: len(devices)}
+}
+
+func parseCaptureDevices(out []byte) []CaptureDevice {
+	var devices []CaptureDevice
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		m := arecordListPattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		var d CaptureDevice
+		fmt.Sscan(m[1], &d.Card)
+		fmt.Sscan(m[3], &d.Device)
+		d.ID = fmt.Sprintf("hw:%d,%d", d.Card, d.Device)
+		d.CardName = m[2]
+		d.Description = m[4]
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+// hwParamsCache holds each device's probed hardware parameters, keyed by
+// resolved device name. A device's ranges do not change while it stays
+// plugged in, and probing opens it, so each is probed once; the cache is
+// cleared when enumeration finds different hardware.
+var (
+	hwParamsMu    sync.Mutex
+	hwParamsCache = make(map[string]map[string]string)
+)
+
+// dumpHWParams returns the hardware parameter ranges of cfg's device, keyed
+// by name (RATE, FORMAT, CHANNELS, ...), probing it unless the result is
+// cached.
+func dumpHWParams(cfg AudioConfig) (map[string]string, error) {
+	if params, ok := cachedHWParams(cfg); ok {
+		return params, nil
+	}
+	params, err := probeHWParams(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hwParamsMu.Lock()
+	hwParamsCache[cfg.ResolvedDevice()] = params
+	hwParamsMu.Unlock()
+	return params, nil
+}
+
+// cachedHWParams returns cfg's device parameters if they were probed before.
+func cachedHWParams(cfg AudioConfig) (map[string]string, bool) {
+	hwParamsMu.Lock()
+	defer hwParamsMu.Unlock()
+	params, ok := hwParamsCache[cfg.ResolvedDevice()]
+	return params, ok
+}
+
+// hwParamsEnd closes the block arecord --dump-hw-params prints, which is
+// opened by the same line.
+const hwParamsEnd = "--------------------"
+
+// probeHWParams asks arecord for the parameter ranges of cfg's device. The
+// ranges are printed once the device is open, before any audio is read, so
+// arecord is stopped as soon as they are complete rather than left to
+// record.
+func probeHWParams(cfg AudioConfig) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args, env := arecordArgs(cfg)
+	// Only the device selection matters; the format arguments would make
+	// arecord refuse devices that do not support them.
+	cmd := exec.CommandContext(ctx, "arecord", args[0], args[1], "--dump-hw-params", "-d", "1", "/dev/null")
+	cmd.Env = env
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, captureError(err, "")
+	}
+	var out bytes.Buffer
+	rules := 0
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		out.Write(scanner.Bytes())
+		out.WriteByte('\n')
+		if strings.TrimSpace(scanner.Text()) == hwParamsEnd {
+			if rules++; rules == 2 {
+				cmd.Process.Kill()
+				break
+			}
+		}
+	}
+	runErr := cmd.Wait()
+	params := parseHWParams(out.Bytes())
+	if len(params) == 0 {
+		if runErr == nil {
+			runErr = fmt.Errorf("no hardware parameters reported")
+		}
+		return nil, captureError(runErr, out.String())
+	}
+	return params, nil
+}
+
+// needsHWParams reports whether starting cfg probes its device: for a native
+// rate, or a rate above what every device handles through plughw:.
+func needsHWParams(cfg AudioConfig) bool {
+	return cfg.SampleRate == NativeSampleRate || cfg.SampleRate > 48000
+}
+
+// warmHWParams probes the devices cmd may start capture on before its
+// handler runs. Probing opens the device and can take a moment, and
+// handlers run with stateMu held, which would stall every connection and
+// the capture loop; probed here, the checks under the lock are answered
+// from the cache.
+func warmHWParams(cmd Command) {
+	if cmd.Type != "control" || (cmd.Request != "mic-listen" && cmd.Request != "mic-switch-device") {
+		return
+	}
+	var req MicConfig
+	if len(cmd.Payload) > 0 {
+		// a malformed payload is reported by the handler
+		json.Unmarshal(cmd.Payload, &req)
+	}
+	stateMu.Lock()
+	cfg := currentConfig
+	if audioSession != nil {
+		cfg = sessionConfig
+	}
+	stateMu.Unlock()
+	if req.Device != "" || len(req.Devices) > 0 {
+		cfg.Device, cfg.Devices = req.Device, req.Devices
+	}
+	if req.SampleRate != 0 {
+		cfg.SampleRate = req.SampleRate
+	}
+	if !needsHWParams(AudioConfig(cfg)) {
+		return
+	}
+	for _, c := range AudioConfig(cfg).candidates() {
+		dumpHWParams(c)
+	}
+}
+
+func parseHWParams(out []byte) map[string]string {
+	params := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || key == "" || strings.ContainsAny(key, " \t\"") {
+			continue
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	return params
+}
+
+// parseRange reads an hw params value that is either "N" or "[MIN MAX]".
+func parseRange(v string) (lo, hi int, err error) {
+	fields := strings.Fields(strings.Trim(v, "[]()"))
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("empty range")
+	}
+	if lo, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, err
+	}
+	hi = lo
+	if len(fields) > 1 {
+		if hi, err = strconv.Atoi(fields[len(fields)-1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return lo, hi, nil
+}
+
+// describeSupported summarizes what cfg's device accepts, for errors about
+// formats it rejected. It returns "" when the device cannot be queried.
+func describeSupported(cfg AudioConfig) string {
+	params, err := dumpHWParams(cfg)
+	if err != nil {
+		return ""
+	}
+	var parts []string
+	for _, key := range []string{"FORMAT", "CHANNELS", "RATE"} {
+		if v, ok := params[key]; ok {
+			parts = append(parts, strings.ToLower(key)+" "+v)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// probeNativeRate returns the device's preferred capture rate: its only rate
+// if fixed, otherwise 48 kHz or 44.1 kHz when supported, else its maximum.
+func probeNativeRate(cfg AudioConfig) (int, error) {
+	params, err := dumpHWParams(cfg)
+	if err != nil {
+		return 0, err
+	}
+	return nativeRate(params)
+}
+
+// nativeRate picks the rate capture uses for a native request from a
+// device's parameter ranges.
+func nativeRate(params map[string]string) (int, error) {
+	lo, hi, err := parseRange(params["RATE"])
+	if err != nil {
+		return 0, fmt.Errorf("unreadable RATE %q: %w", params["RATE"], err)
+	}
+	for _, preferred := range []int{48000, 44100} {
+		if lo <= preferred && preferred <= hi {
+			return preferred, nil
+		}
+	}
+	return hi, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os/exec"
+	"strings"
+)
+
+// diagnosticTools are the external programs features depend on.
+var diagnosticTools = []string{"arecord", "parecord", "amixer", "flac", "ffmpeg"}
+
+// logDiagnostics prints a one-time picture of the environment at startup:
+// capture devices, external tools, the default config, the bind address
+// and how access is secured. Details such as tool paths and device
+// descriptions are logged at debug. Nothing here is fatal.
+func logDiagnostics(opts ServerOptions) {
+	log.Println("--- startup diagnostics ---")
+	devices, err := probeCaptureDevices()
+	switch {
+	case err != nil:
+		log.Println("Capture devices: probe failed:", captureError(err, "").Error())
+	case len(devices) == 0:
+		log.Println("Capture devices: none found")
+	default:
+		ids := make([]string, len(devices))
+		for i, d := range devices {
+			ids[i] = d.ID
+			slog.Debug("Capture device", "id", d.ID, "card", d.CardName, "description", d.Description)
+		}
+		log.Printf("Capture devices: %d (%s)", len(devices), strings.Join(ids, ", "))
+	}
+
+	var present, missing []string
+	for _, tool := range diagnosticTools {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			missing = append(missing, tool)
+			continue
+		}
+		present = append(present, tool)
+		slog.Debug("Tool found", "tool", tool, "path", path)
+	}
+	log.Printf("Tools: found [%s], missing [%s]", strings.Join(present, " "), strings.Join(missing, " "))
+
+	if cfg, err := json.Marshal(defaultMicConfig); err == nil {
+		log.Println("Default config:", string(cfg))
+	}
+	log.Printf("Device: %s", AudioConfig(defaultMicConfig).ResolvedDevice())
+
+	transport := "ws:// (plaintext)"
+	switch {
+	case opts.TLSCert != "":
+		transport = "wss:// (" + opts.TLSCert + ")"
+	case opts.SelfSigned:
+		transport = "wss:// (self-signed)"
+	}
+	log.Printf("Listen: %s over %s", opts.Addr, transport)
+	if opts.UnixSocket != "" {
+		log.Println("Listen: unix socket", opts.UnixSocket)
+	}
+	if opts.AdminToken != "" {
+		log.Println("Admin requests: enabled (token set)")
+	} else {
+		log.Println("Admin requests: disabled (no -admin-token)")
+	}
+	log.Println("---------------------------")
+}
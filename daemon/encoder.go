@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+)
+
+// Encoder turns captured PCM chunks into the frames delivered to one client.
+// Each client owns its encoders, so clients can receive different formats
+// from a single capture.
+type Encoder interface {
+	// Encode returns the frame for one PCM chunk, or nil when the encoder
+	// has nothing to deliver yet.
+	Encode(pcm []byte) ([]byte, error)
+	// Close flushes anything the encoder is still holding.
+	Close() ([]byte, error)
+}
+
+// Output formats.
+const (
+	// FormatWAVChunks sends every chunk as a self-contained WAV file.
+	FormatWAVChunks = "wav-chunks"
+	// FormatWAVStream sends one WAV header with unknown-length sentinels in
+	// front of the first chunk, then bare PCM, so the whole connection reads
+	// as a single endless WAV file.
+	FormatWAVStream = "wav-stream"
+	// FormatRaw sends bare PCM as it is captured.
+	FormatRaw = "raw"
+	// FormatPCMChunks sends bare PCM in frames of whole chunks, sized by
+	// the config, so clients keep chunk boundaries without headers.
+	FormatPCMChunks = "pcm-chunks"
+)
+
+type encoderFactory func(cfg AudioConfig) (Encoder, error)
+
+var encoders = map[string]encoderFactory{
+	FormatWAVChunks: func(cfg AudioConfig) (Encoder, error) { return &wavChunkEncoder{cfg: cfg}, nil },
+	FormatWAVStream: func(cfg AudioConfig) (Encoder, error) { return &wavStreamEncoder{cfg: cfg}, nil },
+	FormatRaw:       func(cfg AudioConfig) (Encoder, error) { return rawEncoder{}, nil },
+	FormatPCMChunks: func(cfg AudioConfig) (Encoder, error) { return &pcmChunkEncoder{size: chunkBytes(cfg)}, nil },
+	FormatFLAC:      func(cfg AudioConfig) (Encoder, error) { return newFLACEncoder(cfg, "", nil) },
+}
+
+// formatAvailable reports why format cannot be used on this machine, such
+// as a missing external encoder.
+func formatAvailable(format string) error {
+	if format == FormatFLAC {
+		if _, err := exec.LookPath("flac"); err != nil {
+			return errFLACMissing
+		}
+	}
+	return nil
+}
+
+// newEncoder builds the encoder for format; an empty format selects
+// FormatWAVChunks.
+func newEncoder(format string, cfg AudioConfig) (Encoder, error) {
+	if format == "" {
+		format = FormatWAVChunks
+	}
+	factory, ok := encoders[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return factory(cfg)
+}
+
+// validFormat reports whether format names a known encoder.
+func validFormat(format string) bool {
+	_, ok := encoders[format]
+	return format == "" || ok
+}
+
+// formatNames lists the known formats in a stable order.
+func formatNames() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type wavChunkEncoder struct {
+	cfg   AudioConfig
+	sizes WAVSizePolicy
+}
+
+func (e *wavChunkEncoder) Encode(pcm []byte) ([]byte, error) {
+	return wavChunkSized(pcm, e.sizes, e.cfg.SampleRate, e.cfg.Channels, e.cfg.BytesPerSample), nil
+}
+
+func (e *wavChunkEncoder) Close() ([]byte, error) { return nil, nil }
+
+type wavStreamEncoder struct {
+	cfg        AudioConfig
+	headerSent bool
+}
+
+func (e *wavStreamEncoder) Encode(pcm []byte) ([]byte, error) {
+	if e.headerSent {
+		return rawEncoder{}.Encode(pcm)
+	}
+	e.headerSent = true
+	header := wavStreamHeader(e.cfg.SampleRate, e.cfg.Channels, e.cfg.BytesPerSample)
+	return append(header, pcm...), nil
+}
+
+func (e *wavStreamEncoder) Close() ([]byte, error) { return nil, nil }
+
+type rawEncoder struct{}
+
+func (rawEncoder) Encode(pcm []byte) ([]byte, error) {
+	out := make([]byte, len(pcm))
+	copy(out, pcm)
+	return out, nil
+}
+
+func (rawEncoder) Close() ([]byte, error) { return nil, nil }
+
+// pcmChunkEncoder regroups PCM into frames of whole multiples of size
+// bytes, holding any remainder for the next chunk. A frame is one chunk
+// unless coalescing hands it several at once.
+type pcmChunkEncoder struct {
+	size    int
+	pending []byte
+}
+
+func (e *pcmChunkEncoder) Encode(pcm []byte) ([]byte, error) {
+	e.pending = append(e.pending, pcm...)
+	if e.size <= 0 || len(e.pending) < e.size {
+		return nil, nil
+	}
+	n := len(e.pending) / e.size * e.size
+	out := make([]byte, n)
+	copy(out, e.pending)
+	e.pending = append(e.pending[:0], e.pending[n:]...)
+	return out, nil
+}
+
+func (e *pcmChunkEncoder) Close() ([]byte, error) { return nil, nil }
+
+// maxClipSeconds bounds clip mode, which holds a whole clip in memory.
+const maxClipSeconds = 60
+
+// clipEncoder assembles exactly one clip's worth of PCM into a complete WAV
+// file. Without repeat it delivers a single clip and then nothing more.
+type clipEncoder struct {
+	cfg     AudioConfig
+	size    int
+	repeat  bool
+	done    bool
+	pending []byte
+	sizes   WAVSizePolicy
+}
+
+func newClipEncoder(cfg AudioConfig, seconds float64, repeat bool) *clipEncoder {
+	frames := int(float64(cfg.SampleRate) * seconds)
+	return &clipEncoder{cfg: cfg, size: frames * cfg.Channels * cfg.BytesPerSample, repeat: repeat}
+}
+
+func (e *clipEncoder) Encode(pcm []byte) ([]byte, error) {
+	if e.done {
+		return nil, nil
+	}
+	e.pending = append(e.pending, pcm...)
+	if len(e.pending) < e.size {
+		return nil, nil
+	}
+	clip := wavChunkSized(e.pending[:e.size], e.sizes, e.cfg.SampleRate, e.cfg.Channels, e.cfg.BytesPerSample)
+	e.pending = append(e.pending[:0], e.pending[e.size:]...)
+	if !e.repeat {
+		e.done = true
+		e.pending = nil
+	}
+	return clip, nil
+}
+
+func (e *clipEncoder) Close() ([]byte, error) { return nil, nil }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorCode is a stable identifier for an error state, so clients can branch
+// on or localize errors without parsing the message.
+type ErrorCode string
+
+const (
+	ErrDeviceNotFound   ErrorCode = "DEVICE_NOT_FOUND"
+	ErrRecorderMissing  ErrorCode = "RECORDER_MISSING"
+	ErrDeviceBusy       ErrorCode = "DEVICE_BUSY"
+	ErrPermissionDenied ErrorCode = "PERMISSION_DENIED"
+	ErrInvalidConfig    ErrorCode = "INVALID_CONFIG"
+	ErrRecorderExited   ErrorCode = "RECORDER_EXITED"
+	ErrProtocol         ErrorCode = "PROTOCOL_ERROR"
+	ErrConnection       ErrorCode = "CONNECTION_ERROR"
+	// ErrFormatUnsupported means the device rejected the requested sample
+	// format, channel count or rate.
+	ErrFormatUnsupported ErrorCode = "FORMAT_UNSUPPORTED"
+	// ErrFormatUnavailable means an output format needs an encoder that is
+	// not installed on this machine.
+	ErrFormatUnavailable ErrorCode = "FORMAT_UNAVAILABLE"
+)
+
+// setMicError moves the mic into the error state. Callers hold stateMu.
+func setMicError(code ErrorCode, message string) {
+	micState = StateError
+	micError = message
+	micErrorCode = code
+	recordError(code, message)
+}
+
+// maxErrorHistory bounds how many errors mic-errors can return.
+const maxErrorHistory = 50
+
+// ErrorRecord is one error the mic entered.
+type ErrorRecord struct {
+	At      time.Time `json:"at"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// ErrorsPayload is the reply to mic-errors, oldest first.
+type ErrorsPayload struct {
+	Errors []ErrorRecord `json:"errors"`
+}
+
+// errorHistory holds the most recent errors, oldest first. Guarded by
+// stateMu.
+var errorHistory []ErrorRecord
+
+// recordError appends an error to errorHistory, dropping the oldest beyond
+// maxErrorHistory. Callers hold stateMu.
+func recordError(code ErrorCode, message string) {
+	errorHistory = append(errorHistory, ErrorRecord{At: time.Now(), Code: code, Message: message})
+	if len(errorHistory) > maxErrorHistory {
+		errorHistory = append(errorHistory[:0], errorHistory[len(errorHistory)-maxErrorHistory:]...)
+	}
+}
+
+// recentErrors returns up to n of the latest errors, or all of them when n
+// is not positive. Callers hold stateMu.
+func recentErrors(n int) []ErrorRecord {
+	if n <= 0 || n > len(errorHistory) {
+		n = len(errorHistory)
+	}
+	out := make([]ErrorRecord, n)
+	copy(out, errorHistory[len(errorHistory)-n:])
+	return out
+}
+
+// setMicState moves the mic into a non-error state. Callers hold stateMu.
+func setMicState(state MicState) {
+	micState = state
+	micError = ""
+	micErrorCode = ""
+}
+
+// CaptureError is returned when arecord fails to start or exits early.
+type CaptureError struct {
+	Code ErrorCode
+	Err  error
+	// Stderr is the tail of arecord's error output.
+	Stderr string
+}
+
+func (e *CaptureError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("%v: %s", e.Err, e.Stderr)
+	}
+	return e.Err.Error()
+}
+
+func (e *CaptureError) Unwrap() error { return e.Err }
+
+var errRecorderMissing = errors.New("arecord not found; install alsa-utils")
+
+// checkRecorder logs a warning at startup when arecord is not on PATH, so the
+// problem is visible before anyone tries to listen.
+func checkRecorder() {
+	if _, err := exec.LookPath("arecord"); err != nil {
+		slog.Warn(errRecorderMissing.Error())
+	}
+}
+
+// captureError classifies a recorder failure from its error and stderr.
+func captureError(err error, stderr string) *CaptureError {
+	if errors.Is(err, exec.ErrNotFound) {
+		return &CaptureError{Code: ErrRecorderMissing, Err: errRecorderMissing}
+	}
+	code := ErrRecorderExited
+	switch {
+	case strings.Contains(stderr, "No such file or directory"),
+		strings.Contains(stderr, "No such device"),
+		strings.Contains(stderr, "Unknown PCM"):
+		code = ErrDeviceNotFound
+	case strings.Contains(stderr, "Permission denied"):
+		code = ErrPermissionDenied
+	case strings.Contains(stderr, "Device or resource busy"):
+		code = ErrDeviceBusy
+	case strings.Contains(stderr, "format non available"),
+		strings.Contains(stderr, "Channels count non available"),
+		strings.Contains(stderr, "Rate non available"),
+		strings.Contains(stderr, "Broken configuration"),
+		strings.Contains(stderr, "Unable to install hw params"):
+		code = ErrFormatUnsupported
+	}
+	return &CaptureError{Code: code, Err: err, Stderr: strings.TrimSpace(stderr)}
+}
+
+// errorCodeOf extracts the code carried by err, defaulting to
+// RECORDER_EXITED for unclassified start failures.
+func errorCodeOf(err error) ErrorCode {
+	var ce *CaptureError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	return ErrRecorderExited
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
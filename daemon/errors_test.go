@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+func TestCaptureErrorCodes(t *testing.T) {
+	exited := errors.New("exit status 1")
+	for _, tc := range []struct {
+		err    error
+		stderr string
+		want   ErrorCode
+	}{
+		{&exec.Error{Name: "arecord", Err: exec.ErrNotFound}, "", ErrRecorderMissing},
+		{exited, "arecord: main:830: audio open error: No such file or directory", ErrDeviceNotFound},
+		{exited, "ALSA lib pcm.c:2666:(snd_pcm_open_noupdate) Unknown PCM hw:9", ErrDeviceNotFound},
+		{exited, "arecord: main:830: audio open error: No such device", ErrDeviceNotFound},
+		{exited, "arecord: main:830: audio open error: Permission denied", ErrPermissionDenied},
+		{exited, "arecord: main:830: audio open error: Device or resource busy", ErrDeviceBusy},
+		{exited, "arecord: set_params:1343: Sample format non available", ErrFormatUnsupported},
+		{exited, "arecord: set_params:1347: Channels count non available", ErrFormatUnsupported},
+		{exited, "arecord: set_params:1352: Rate non available", ErrFormatUnsupported},
+		{exited, "Broken configuration for this PCM: no configurations available", ErrFormatUnsupported},
+		{exited, "arecord: set_params:1405: Unable to install hw params:", ErrFormatUnsupported},
+		{exited, "arecord: something unexpected", ErrRecorderExited},
+	} {
+		err := captureError(tc.err, tc.stderr)
+		if err.Code != tc.want {
+			t.Errorf("captureError(%v, %q) = %s, want %s", tc.err, tc.stderr, err.Code, tc.want)
+		}
+		if got := errorCodeOf(fmt.Errorf("start: %w", err)); got != tc.want {
+			t.Errorf("errorCodeOf wrapped %s = %s", tc.want, got)
+		}
+	}
+	if got := errorCodeOf(errors.New("plain")); got != ErrRecorderExited {
+		t.Errorf("errorCodeOf(plain error) = %s, want %s", got, ErrRecorderExited)
+	}
+}
+
+func TestSetMicError(t *testing.T) {
+	defer func(h []ErrorRecord) { errorHistory = h }(errorHistory)
+	errorHistory = nil
+	setMicError(ErrInvalidConfig, "Invalid config")
+	if micState != StateError || micErrorCode != ErrInvalidConfig || micError != "Invalid config" {
+		t.Fatalf("state %s, code %s, message %q after setMicError", micState, micErrorCode, micError)
+	}
+	if errs := recentErrors(0); len(errs) != 1 || errs[0].Code != ErrInvalidConfig {
+		t.Fatalf("recorded %v", errs)
+	}
+	setMicState(StateIdle)
+	if micErrorCode != "" || micError != "" {
+		t.Fatalf("setMicState left code %s, message %q", micErrorCode, micError)
+	}
+}
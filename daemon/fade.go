@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Fade shapes.
+const (
+	FadeCosine = "cosine"
+	FadeLinear = "linear"
+)
+
+// maxFadeMs bounds fadeMs.
+const maxFadeMs = 1000
+
+func validateFade(ms int, shape string) error {
+	if ms < 0 || ms > maxFadeMs {
+		return errors.New("fadeMs must be between 0 and 1000")
+	}
+	switch shape {
+	case "", FadeCosine, FadeLinear:
+		return nil
+	}
+	return errors.New(`fadeShape must be "cosine" or "linear"`)
+}
+
+// fadeGain is the gain at position t in [0, 1] of a ramp from silence to
+// full level.
+func fadeGain(shape string, t float64) float64 {
+	if shape == FadeLinear {
+		return t
+	}
+	return 0.5 - 0.5*math.Cos(math.Pi*t)
+}
+
+// fader ramps the start of a session up from silence and, when told the
+// stream is ending, its last chunk down to silence.
+type fader struct {
+	shape    string
+	frames   int
+	channels int
+	bps      int
+	// done counts frames already faded in.
+	done int
+}
+
+func newFader(cfg AudioConfig) *fader {
+	if cfg.FadeMs <= 0 {
+		return nil
+	}
+	return &fader{
+		shape:    cfg.FadeShape,
+		frames:   max(1, cfg.SampleRate*cfg.FadeMs/1000),
+		channels: cfg.Channels,
+		bps:      cfg.BytesPerSample,
+	}
+}
+
+// In applies the fade-in to pcm, in place, until it is complete.
+func (f *fader) In(pcm []byte) {
+	n := len(pcm) / (f.channels * f.bps)
+	for k := 0; k < n && f.done < f.frames; k++ {
+		f.scale(pcm, k, fadeGain(f.shape, float64(f.done)/float64(f.frames)))
+		f.done++
+	}
+}
+
+// Out fades the end of the final chunk, in place, so its last frame is
+// silent. A chunk shorter than the fade is ramped over its whole length,
+// starting from full level so the fade itself does not click.
+func (f *fader) Out(pcm []byte) {
+	n := len(pcm) / (f.channels * f.bps)
+	span := min(n, f.frames)
+	for k := n - span; k < n; k++ {
+		remaining := n - 1 - k
+		gain := 0.0
+		if span > 1 {
+			gain = fadeGain(f.shape, float64(remaining)/float64(span-1))
+		}
+		f.scale(pcm, k, gain)
+	}
+}
+
+// scale multiplies every channel of frame k by gain.
+func (f *fader) scale(pcm []byte, k int, gain float64) {
+	for ch := 0; ch < f.channels; ch++ {
+		i := k*f.channels + ch
+		putSample(pcm, i, f.bps, int32(math.Round(float64(sampleAt(pcm, i, f.bps))*gain)))
+	}
+}
+
+// putSample stores sample i of pcm at the given width, the inverse of
+// sampleAt.
+func putSample(pcm []byte, i, bps int, v int32) {
+	switch bps {
+	case 3:
+		pcm[3*i], pcm[3*i+1], pcm[3*i+2] = byte(v), byte(v>>8), byte(v>>16)
+	case 4:
+		binary.LittleEndian.PutUint32(pcm[4*i:], uint32(v))
+	default:
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(v))
+	}
+}
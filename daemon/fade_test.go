@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestFadeOutShortChunk(t *testing.T) {
+	for _, shape := range []string{FadeCosine, FadeLinear} {
+		// a 100 ms fade at 16 kHz is 1600 frames; the final chunk has 400
+		f := newFader(AudioConfig{SampleRate: 16000, Channels: 2, BytesPerSample: 2, FadeMs: 100, FadeShape: shape})
+		const frames = 400
+		pcm := make([]byte, frames*2*2)
+		for i := 0; i < 2*frames; i++ {
+			putSample(pcm, i, 2, 10000)
+		}
+		f.Out(pcm)
+		if first := sampleAt(pcm, 0, 2); first != 10000 {
+			t.Errorf("%s: fade starts at %d, want full level 10000", shape, first)
+		}
+		if last := sampleAt(pcm, 2*frames-1, 2); last != 0 {
+			t.Errorf("%s: last sample %d, want silence", shape, last)
+		}
+		for k := 1; k < frames; k++ {
+			prev, cur := sampleAt(pcm, 2*(k-1), 2), sampleAt(pcm, 2*k, 2)
+			if cur > prev {
+				t.Fatalf("%s: gain rises at frame %d (%d after %d)", shape, k, cur, prev)
+			}
+			// the step between frames stays small: no click
+			if prev-cur > 10000*4/frames {
+				t.Fatalf("%s: step of %d at frame %d", shape, prev-cur, k)
+			}
+		}
+	}
+}
+
+func TestFadeOutLongChunk(t *testing.T) {
+	f := newFader(AudioConfig{SampleRate: 16000, Channels: 1, BytesPerSample: 3, FadeMs: 10})
+	pcm := make([]byte, 3*1000)
+	for i := 0; i < 1000; i++ {
+		putSample(pcm, i, 3, 1<<20)
+	}
+	f.Out(pcm)
+	// only the last 160 frames fade
+	if v := sampleAt(pcm, 1000-161, 3); v != 1<<20 {
+		t.Errorf("frame before the fade is %d, want untouched", v)
+	}
+	if v := sampleAt(pcm, 1000-160, 3); v != 1<<20 {
+		t.Errorf("fade starts at %d, want full level", v)
+	}
+	if v := sampleAt(pcm, 999, 3); v != 0 {
+		t.Errorf("last frame %d, want silence", v)
+	}
+}
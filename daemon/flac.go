@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FormatFLAC pipes PCM through the flac encoder for lossless compressed
+// audio. The connection reads as one FLAC stream; since a stream cannot be
+// rewound, its STREAMINFO carries no total length.
+const FormatFLAC = "flac"
+
+var errFLACMissing = errors.New("flac not found; install the flac package")
+
+// flacEncoder feeds raw PCM to a flac process and collects what it writes.
+// flac buffers whole blocks, so Encode often returns nothing and Close
+// returns the final frames.
+type flacEncoder struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu       sync.Mutex
+	out      bytes.Buffer
+	readErr  error
+	readDone chan struct{}
+}
+
+// newFLACEncoder starts flac for PCM in cfg. With path set it writes the
+// file itself, which lets flac seek back and complete STREAMINFO;
+// otherwise it streams to stdout. tags become Vorbis comments.
+func newFLACEncoder(cfg AudioConfig, path string, tags map[string]string) (*flacEncoder, error) {
+	if _, err := exec.LookPath("flac"); err != nil {
+		return nil, errFLACMissing
+	}
+	args := []string{
+		"--silent", "--force-raw-format",
+		"--endian=little", "--sign=signed",
+		"--channels=" + strconv.Itoa(cfg.Channels),
+		"--bps=" + strconv.Itoa(cfg.BytesPerSample*8),
+		"--sample-rate=" + strconv.Itoa(cfg.SampleRate),
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--tag="+strings.ToUpper(key)+"="+tags[key])
+	}
+	if path != "" {
+		args = append(args, "--force", "-o", path, "-")
+	} else {
+		args = append(args, "--stdout", "-")
+	}
+	e := &flacEncoder{cmd: exec.Command("flac", args...), readDone: make(chan struct{})}
+	var err error
+	if e.stdin, err = e.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	var stdout io.ReadCloser
+	if path == "" {
+		if stdout, err = e.cmd.StdoutPipe(); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.cmd.Start(); err != nil {
+		return nil, err
+	}
+	if stdout == nil {
+		close(e.readDone)
+		return e, nil
+	}
+	go func() {
+		defer close(e.readDone)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := stdout.Read(buf)
+			e.mu.Lock()
+			e.out.Write(buf[:n])
+			if err != nil && err != io.EOF {
+				e.readErr = err
+			}
+			e.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return e, nil
+}
+
+// take returns and clears what flac has written so far.
+func (e *flacEncoder) take() ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.out.Len() == 0 {
+		return nil, e.readErr
+	}
+	out := bytes.Clone(e.out.Bytes())
+	e.out.Reset()
+	return out, e.readErr
+}
+
+func (e *flacEncoder) Encode(pcm []byte) ([]byte, error) {
+	if _, err := e.stdin.Write(pcm); err != nil {
+		return nil, err
+	}
+	return e.take()
+}
+
+// Close ends the input so flac writes its last frames and, for files, the
+// completed STREAMINFO, then waits for it to exit.
+func (e *flacEncoder) Close() ([]byte, error) {
+	e.stdin.Close()
+	<-e.readDone
+	waitErr := e.cmd.Wait()
+	out, err := e.take()
+	if err == nil {
+		err = waitErr
+	}
+	return out, err
+}
@@ -0,0 +1,40 @@
+package main
+
+// availableFormats lists the formats whose encoders can run on this
+// machine, in formatNames order.
+func availableFormats() []string {
+	var names []string
+	for _, name := range formatNames() {
+		if formatAvailable(name) == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sendFormatUnavailable refuses a format whose encoder is missing, naming
+// the formats that would work instead.
+func sendFormatUnavailable(c *client, request string, err error) {
+	c.sendMessage("error", request, ErrorPayload{
+		Error:            err.Error(),
+		Code:             ErrFormatUnavailable,
+		AvailableFormats: availableFormats(),
+	})
+}
+
+// WarningPayload is a non-fatal problem reported to one connection.
+type WarningPayload struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// InfoPayload is the reply to mic-info: what this daemon build can do.
+type InfoPayload struct {
+	Formats          []string   `json:"formats"`
+	AvailableFormats []string   `json:"availableFormats"`
+	Build            DebugBuild `json:"build"`
+}
+
+func daemonInfo() InfoPayload {
+	return InfoPayload{Formats: formatNames(), AvailableFormats: availableFormats(), Build: buildInfo()}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// SampleFormatPayload is the reply to mic-sample-format.
+type SampleFormatPayload struct {
+	SampleFormat   SampleFormat `json:"sampleFormat"`
+	BytesPerSample int          `json:"bytesPerSample"`
+	// Restarted is set when capture was reopened rather than converted in
+	// place.
+	Restarted bool `json:"restarted"`
+}
+
+// convertsLive reports whether the running session changes its output
+// sample format by rewriting samples with convertDepth, keeping arecord
+// running, rather than reopening capture. A raw hw: device is reopened so
+// that a wider format brings the hardware's own resolution. Reopening a
+// plug device, ALSA's default or PulseAudio would only have ALSA shift the
+// same samples, and file playback is always 16-bit, so converting in place
+// loses nothing there. Callers hold stateMu.
+func convertsLive() bool {
+	if audioSession.Playback() != "" {
+		return true
+	}
+	return !strings.HasPrefix(AudioConfig(sessionConfig).ResolvedDevice(), "hw:")
+}
+
+// changeSampleFormat delivers audio as f from now on and tells c the format
+// in effect. Without a session only the config changes. Connections that
+// listened with their own depth keep it, apart from c itself. Callers hold
+// stateMu.
+func changeSampleFormat(c *client, request string, f SampleFormat) {
+	bps := sampleFormatSpecs[f].bytes
+	reply := func(restarted bool) {
+		if own := c.connectionConfig(); own != nil && own.BytesPerSample != 0 && own.BytesPerSample != bps {
+			// the requester asked for this depth, so its own config
+			// must not convert it back
+			updated := *own
+			updated.BytesPerSample, updated.SampleFormat = bps, f
+			c.setConfig(&updated)
+			sendState(c)
+		}
+		active := SampleFormatPayload{SampleFormat: f, BytesPerSample: bps, Restarted: restarted}
+		if audioSession != nil {
+			cfg := audioSession.Config()
+			active.SampleFormat, active.BytesPerSample = cfg.SampleFormat, cfg.BytesPerSample
+		}
+		c.sendMessage("sample-format", request, active)
+	}
+	if audioSession == nil {
+		currentConfig.SampleFormat, currentConfig.BytesPerSample = f, bps
+		broadcastState()
+		reply(false)
+		return
+	}
+	if audioSession.Config().BytesPerSample == bps {
+		reply(false)
+		return
+	}
+
+	next := sessionConfig
+	next.SampleFormat, next.BytesPerSample = f, bps
+	if convertsLive() {
+		if bps == audioSession.cfg.BytesPerSample {
+			audioSession.outDepth.Store(0)
+		} else {
+			audioSession.outDepth.Store(int32(bps))
+		}
+		sessionConfig = next
+		log.Printf("Delivering %s, converted from %s capture", f, audioSession.cfg.SampleFormat)
+		broadcastState()
+		reply(false)
+		return
+	}
+	if err := restartCapture(next); err != nil {
+		log.Println("Sample format change error:", err)
+		sendError(c, request, "Could not capture "+string(f)+": "+err.Error())
+		return
+	}
+	reply(true)
+}
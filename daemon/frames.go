@@ -0,0 +1,35 @@
+package main
+
+// frameAligner trims byte runs to whole frames, holding back any trailing
+// partial frame and prepending it to the next run so every emitted chunk
+// starts on a frame boundary and channel interleaving is preserved.
+type frameAligner struct {
+	frameSize int
+	residual  []byte
+}
+
+func newFrameAligner(frameSize int) *frameAligner {
+	return &frameAligner{frameSize: max(frameSize, 1)}
+}
+
+// Align returns the whole frames available from the held back residual plus
+// p. The result may alias internal storage and is only valid until the next
+// call.
+func (a *frameAligner) Align(p []byte) []byte {
+	if len(a.residual) == 0 && len(p)%a.frameSize == 0 {
+		return p
+	}
+	joined := append(a.residual, p...)
+	whole := len(joined) - len(joined)%a.frameSize
+	out := make([]byte, whole)
+	copy(out, joined[:whole])
+	a.residual = append(a.residual[:0], joined[whole:]...)
+	return out
+}
+
+// chunkBytes is the read size for one chunk of cfg, rounded down to whole
+// frames.
+func chunkBytes(cfg AudioConfig) int {
+	frames := int(float64(cfg.SampleRate) * cfg.SecondsPerChunk)
+	return frames * cfg.Channels * cfg.BytesPerSample
+}
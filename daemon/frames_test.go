@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameAlignerKeepsFrameBoundaries(t *testing.T) {
+	stream := make([]byte, 1000)
+	for i := range stream {
+		stream[i] = byte(i)
+	}
+	for _, frameSize := range []int{4, 6, 8, 12} {
+		a := newFrameAligner(frameSize)
+		var out []byte
+		rest := stream
+		for _, n := range []int{1, 7, 3, 13, 5, 250, 2, 9, 31, 11} {
+			n = min(n, len(rest))
+			got := a.Align(rest[:n])
+			rest = rest[n:]
+			if len(got)%frameSize != 0 {
+				t.Fatalf("frame size %d: emitted %d bytes, not whole frames", frameSize, len(got))
+			}
+			out = append(out, got...)
+		}
+		consumed := len(stream) - len(rest)
+		if !bytes.Equal(out, stream[:len(out)]) {
+			t.Fatalf("frame size %d: emitted bytes are not the input in order", frameSize)
+		}
+		if held := consumed - len(out); held < 0 || held >= frameSize {
+			t.Fatalf("frame size %d: %d bytes held back, want under one frame", frameSize, held)
+		}
+	}
+}
+
+func TestFrameAlignerPassesWholeFrames(t *testing.T) {
+	a := newFrameAligner(4)
+	p := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if got := a.Align(p); &got[0] != &p[0] {
+		t.Fatal("whole frames were copied instead of passed through")
+	}
+}
+
+func TestChunkBytesWholeFrames(t *testing.T) {
+	// 0.0301 s at 44.1 kHz is 1327.41 frames
+	cfg := AudioConfig{SampleRate: 44100, Channels: 2, BytesPerSample: 3, SecondsPerChunk: 0.0301}
+	if got, want := chunkBytes(cfg), 1327*2*3; got != want {
+		t.Fatalf("chunkBytes = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// NoiseGateConfig zeroes audio below a threshold before it is streamed,
+// preserving timing with true silence. Gain ramps over the attack and
+// release times to avoid clicks at the gate edges.
+type NoiseGateConfig struct {
+	// ThresholdDB is the open level in dBFS, e.g. -45.
+	ThresholdDB float64 `json:"thresholdDb"`
+	AttackMs    float64 `json:"attackMs"`
+	ReleaseMs   float64 `json:"releaseMs"`
+}
+
+func (g *NoiseGateConfig) validate() error {
+	if g.ThresholdDB >= 0 || g.ThresholdDB < -120 {
+		return errors.New("noiseGate.thresholdDb must be between -120 and 0")
+	}
+	if g.AttackMs < 0 || g.AttackMs > 5000 || g.ReleaseMs < 0 || g.ReleaseMs > 5000 {
+		return errors.New("noiseGate attack and release must be between 0 and 5000 ms")
+	}
+	return nil
+}
+
+// envelopeDecayMs is how quickly the level detector falls after a peak,
+// long enough to ride over zero crossings.
+const envelopeDecayMs = 10
+
+// noiseGate applies a NoiseGateConfig to interleaved PCM of any supported
+// width, keeping its envelope and gain across chunks.
+type noiseGate struct {
+	channels    int
+	bps         int
+	threshold   float64
+	attackStep  float64
+	releaseStep float64
+	envDecay    float64
+	env         float64
+	gain        float64
+}
+
+func newNoiseGate(cfg NoiseGateConfig, sampleRate, channels, bps int) *noiseGate {
+	step := func(ms float64) float64 {
+		frames := ms * float64(sampleRate) / 1000
+		if frames < 1 {
+			return 1
+		}
+		return 1 / frames
+	}
+	return &noiseGate{
+		channels:    channels,
+		bps:         bps,
+		threshold:   float64(int64(1)<<(8*bps-1)) * math.Pow(10, cfg.ThresholdDB/20),
+		attackStep:  step(cfg.AttackMs),
+		releaseStep: step(cfg.ReleaseMs),
+		envDecay:    math.Exp(-1 / (envelopeDecayMs * float64(sampleRate) / 1000)),
+	}
+}
+
+// Process gates pcm in place.
+func (g *noiseGate) Process(pcm []byte) {
+	frames := len(pcm) / (g.channels * g.bps)
+	for f := 0; f < frames; f++ {
+		first := f * g.channels
+		peak := 0.0
+		for i := first; i < first+g.channels; i++ {
+			peak = max(peak, math.Abs(float64(sampleAt(pcm, i, g.bps))))
+		}
+		g.env = max(peak, g.env*g.envDecay)
+		if g.env >= g.threshold {
+			g.gain = min(1, g.gain+g.attackStep)
+		} else {
+			g.gain = max(0, g.gain-g.releaseStep)
+		}
+		if g.gain < 1 {
+			for i := first; i < first+g.channels; i++ {
+				putSample(pcm, i, g.bps, int32(float64(sampleAt(pcm, i, g.bps))*g.gain))
+			}
+		}
+	}
+}
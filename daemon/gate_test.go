@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestNoiseGateEveryWidth(t *testing.T) {
+	for _, bps := range []int{2, 3, 4} {
+		full := float64(int64(1) << (8*bps - 1))
+		g := newNoiseGate(NoiseGateConfig{ThresholdDB: -40}, 16000, 1, bps)
+		// -60 dBFS stays closed, -20 dBFS opens the gate
+		quiet := make([]byte, 160*bps)
+		loud := make([]byte, 160*bps)
+		for i := 0; i < 160; i++ {
+			putSample(quiet, i, bps, int32(full/1000))
+			putSample(loud, i, bps, int32(full/10))
+		}
+		g.Process(quiet)
+		g.Process(loud)
+		if v := sampleAt(quiet, 159, bps); v != 0 {
+			t.Errorf("%d bytes: quiet sample %d passed the gate", bps, v)
+		}
+		if v := sampleAt(loud, 159, bps); v != int32(full/10) {
+			t.Errorf("%d bytes: loud sample %d, want %d", bps, v, int32(full/10))
+		}
+	}
+}
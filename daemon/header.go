@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// frameHeaderSize is the length of the header prefixed to audio frames on
+// connections that listen with frameHeader.
+const frameHeaderSize = 16
+
+// frameHeaderVersion is the first byte of every frame header.
+const frameHeaderVersion = 1
+
+// Frame header flags.
+const (
+	// FlagSilence marks a frame the daemon synthesized while nothing was
+	// captured, so recorders can skip it.
+	FlagSilence byte = 1 << 0
+	// FlagChecksum marks a header followed by the CRC32 (IEEE) of the
+	// payload, making it frameHeaderSize+4 bytes long.
+	FlagChecksum byte = 1 << 1
+)
+
+// FrameClock selects what a frame header's timestamp counts.
+type FrameClock string
+
+const (
+	// ClockUnix is wall-clock time in Unix milliseconds when the frame was
+	// encoded.
+	ClockUnix FrameClock = "unix"
+	// ClockMonotonic is milliseconds since the daemon started, unaffected
+	// by wall-clock adjustments.
+	ClockMonotonic FrameClock = "monotonic"
+	// ClockSamples is the frame's position in sample frames at the
+	// connection's delivery rate: the audio delivered before it plus any
+	// gaps, so it never goes backwards. See timeline.
+	ClockSamples FrameClock = "samples"
+)
+
+// processStart anchors ClockMonotonic.
+var processStart = time.Now()
+
+func parseFrameClock(s string) (FrameClock, error) {
+	switch clock := FrameClock(s); clock {
+	case "":
+		return ClockUnix, nil
+	case ClockUnix, ClockMonotonic, ClockSamples:
+		return clock, nil
+	}
+	return "", fmt.Errorf("unknown frame clock %q; expected unix, monotonic or samples", s)
+}
+
+// frameHeader lays out version, flags, two reserved bytes, a per-connection
+// sequence number and a timestamp, little-endian.
+func frameHeader(flags byte, seq uint32, timestamp uint64) []byte {
+	h := make([]byte, frameHeaderSize)
+	h[0] = frameHeaderVersion
+	h[1] = flags
+	binary.LittleEndian.PutUint32(h[4:], seq)
+	binary.LittleEndian.PutUint64(h[8:], timestamp)
+	return h
+}
+
+// setFramed prefixes every audio frame sent to c with a frameHeader stamped
+// from clock.
+func (c *client) setFramed(on bool, clock FrameClock) {
+	c.encMu.Lock()
+	c.framed, c.clock = on, clock
+	c.encMu.Unlock()
+}
+
+// audioFrame is one binary audio message and the metadata describing it.
+type audioFrame struct {
+	data []byte
+	meta AudioMeta
+	// gap, when set, is announced before the frame.
+	gap *GapEvent
+}
+
+// AudioMeta is the payload of an audio-meta message, sent just before the
+// binary frame it describes. Seq and Timestamp match the frame header.
+type AudioMeta struct {
+	Seq       uint32 `json:"seq"`
+	Timestamp uint64 `json:"timestamp"`
+	// Samples is how many sample frames the audio was encoded from; Bytes
+	// is the size of the binary message.
+	Samples int  `json:"samples"`
+	Bytes   int  `json:"bytes"`
+	Silence bool `json:"silence,omitempty"`
+	// CRC32 is the IEEE CRC32 of the audio payload, without any frame
+	// header, on connections that listen with checksum.
+	CRC32 *uint32 `json:"crc32,omitempty"`
+	// Speech is set on connections using the vad trigger.
+	Speech *bool  `json:"speech,omitempty"`
+	Level  *Level `json:"level,omitempty"`
+}
+
+// wrap turns an encoded frame into an audioFrame, behind a header when the
+// connection asked for one, and places the pcm it was encoded from on the
+// timeline. A nil frame, such as an encoder still buffering, stays nil.
+// Callers hold encMu.
+func (c *client) wrap(frame []byte, flags byte, pcm []byte) audioFrame {
+	samples := c.sampleFrames(pcm)
+	pos, gap := c.timeline.advance(samples, c.outCfg.SampleRate, time.Now())
+	if gap == nil {
+		gap, c.timeline.pending = c.timeline.pending, nil
+	}
+	if frame == nil {
+		// announced with the next frame the encoder releases
+		c.timeline.pending = gap
+		return audioFrame{}
+	}
+	var ts uint64
+	switch c.clock {
+	case ClockMonotonic:
+		ts = uint64(time.Since(processStart).Milliseconds())
+	case ClockSamples:
+		ts = pos
+	default:
+		ts = uint64(time.Now().UnixMilli())
+	}
+	meta := AudioMeta{Seq: c.seq, Timestamp: ts, Samples: samples, Silence: flags&FlagSilence != 0}
+	c.seq++
+	if c.checksum {
+		sum := crc32.ChecksumIEEE(frame)
+		meta.CRC32 = &sum
+		flags |= FlagChecksum
+	}
+	if c.framed {
+		header := frameHeader(flags, meta.Seq, ts)
+		if meta.CRC32 != nil {
+			header = binary.LittleEndian.AppendUint32(header, *meta.CRC32)
+		}
+		frame = append(header, frame...)
+	}
+	meta.Bytes = len(frame)
+	if c.clock != ClockSamples || !(c.framed || c.audioMeta) {
+		// only sample-clock timestamps need the gap explained
+		gap = nil
+	}
+	if c.audioMeta {
+		if c.vad != nil {
+			speech := c.vad.active
+			meta.Speech = &speech
+		}
+		if _, ok := sampleFormats[c.outCfg.BytesPerSample]; ok && len(pcm) > 0 {
+			level := measureLevel(pcm, c.outCfg.Channels, c.outCfg.BytesPerSample)
+			meta.Level = &level
+		}
+	}
+	return audioFrame{data: frame, meta: meta, gap: gap}
+}
+
+// setChecksum adds the payload's CRC32 to every audio frame's header and
+// audio-meta message.
+func (c *client) setChecksum(on bool) {
+	c.encMu.Lock()
+	c.checksum = on
+	c.encMu.Unlock()
+}
+
+// setAudioMeta precedes every audio frame with an audio-meta message.
+func (c *client) setAudioMeta(on bool) {
+	c.encMu.Lock()
+	c.audioMeta = on
+	c.encMu.Unlock()
+}
+
+// sendAudio queues an audio frame, announced by its audio-meta message when
+// the connection asked for them.
+func (c *client) sendAudio(f audioFrame) {
+	c.encMu.Lock()
+	meta := c.audioMeta
+	c.encMu.Unlock()
+	if f.gap != nil {
+		c.sendEvent("gap", f.gap)
+	}
+	if meta {
+		c.sendEvent("audio-meta", f.meta)
+	}
+	c.send(websocket.BinaryMessage, f.data)
+}
+
+// sampleFrames is how many sample frames pcm in the delivery config holds.
+// Callers hold encMu.
+func (c *client) sampleFrames(pcm []byte) int {
+	size := c.outCfg.Channels * c.outCfg.BytesPerSample
+	if size <= 0 {
+		return 0
+	}
+	return len(pcm) / size
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"slices"
+	"testing"
+)
+
+// framedClient is a connection that stamps frame headers from clock, fed
+// 16 kHz mono 16-bit audio.
+func framedClient(clock FrameClock) *client {
+	return &client{
+		framed: true,
+		clock:  clock,
+		outCfg: AudioConfig{SampleRate: 16000, Channels: 1, BytesPerSample: 2},
+	}
+}
+
+func TestSampleClockAdvancesByChunk(t *testing.T) {
+	c := framedClient(ClockSamples)
+	var want uint64
+	for i, frames := range []int{1600, 1600, 320, 4000, 1} {
+		pcm := make([]byte, 2*frames)
+		f := c.wrap(pcm, 0, pcm)
+		if f.gap != nil {
+			t.Fatalf("chunk %d: unexpected gap %+v", i, *f.gap)
+		}
+		if ts := binary.LittleEndian.Uint64(f.data[8:]); ts != want || f.meta.Timestamp != want {
+			t.Fatalf("chunk %d: header timestamp %d, meta %d; want %d", i, ts, f.meta.Timestamp, want)
+		}
+		if seq := binary.LittleEndian.Uint32(f.data[4:]); seq != uint32(i) {
+			t.Fatalf("chunk %d: seq %d", i, seq)
+		}
+		if f.meta.Samples != frames {
+			t.Fatalf("chunk %d: meta samples %d, want %d", i, f.meta.Samples, frames)
+		}
+		want += uint64(frames)
+	}
+}
+
+func TestChecksumMatchesPayload(t *testing.T) {
+	payload := []byte("not quite audio, but any bytes will do")
+	want := crc32.ChecksumIEEE(payload)
+
+	c := framedClient(ClockUnix)
+	c.checksum = true
+	f := c.wrap(slices.Clone(payload), 0, nil)
+	if len(f.data) != frameHeaderSize+4+len(payload) {
+		t.Fatalf("frame is %d bytes, want header, CRC and payload", len(f.data))
+	}
+	if f.data[1]&FlagChecksum == 0 {
+		t.Error("checksum flag not set")
+	}
+	if got := binary.LittleEndian.Uint32(f.data[frameHeaderSize:]); got != want {
+		t.Errorf("header CRC %#x, want %#x", got, want)
+	}
+	if !bytes.Equal(f.data[frameHeaderSize+4:], payload) {
+		t.Error("payload changed behind the header")
+	}
+	if f.meta.CRC32 == nil || *f.meta.CRC32 != want {
+		t.Errorf("audio-meta CRC %v, want %#x", f.meta.CRC32, want)
+	}
+
+	// without a frame header the CRC is only in audio-meta
+	c = &client{checksum: true, audioMeta: true}
+	f = c.wrap(slices.Clone(payload), 0, nil)
+	if !bytes.Equal(f.data, payload) || f.meta.CRC32 == nil || *f.meta.CRC32 != want {
+		t.Errorf("unframed: data %q, CRC %v; want the payload and %#x", f.data, f.meta.CRC32, want)
+	}
+}
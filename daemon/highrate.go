@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Limits that keep the size arithmetic of high-rate configs in range. At
+// the maximums a second of audio is under 100 MB, so byte rates fit the
+// WAV header's uint32 fields and chunk sizes fit an int even on 32-bit
+// hosts.
+const (
+	maxSampleRate = 768000
+	maxChannels   = 32
+	// maxALSAFrames bounds periodFrames and bufferFrames.
+	maxALSAFrames = 1 << 22
+	// maxRingBytes bounds the memory ringSeconds may claim at high rates.
+	maxRingBytes = 512 << 20
+)
+
+// byteRate is the bytes per second of audio captured with cfg, computed in
+// 64 bits so it cannot wrap.
+func (cfg AudioConfig) byteRate() int64 {
+	return int64(cfg.SampleRate) * int64(cfg.Channels) * int64(cfg.BytesPerSample)
+}
+
+// validateSizes rejects configs whose rate, layout or buffer sizes would
+// overflow the byte arithmetic downstream.
+func (cfg AudioConfig) validateSizes() error {
+	if cfg.SampleRate > maxSampleRate {
+		return fmt.Errorf("sampleRate must be at most %d", maxSampleRate)
+	}
+	if cfg.Channels < 0 || cfg.Channels > maxChannels {
+		return fmt.Errorf("channels must be between 1 and %d", maxChannels)
+	}
+	if cfg.PeriodFrames > maxALSAFrames || cfg.BufferFrames > maxALSAFrames {
+		return fmt.Errorf("periodFrames and bufferFrames must be at most %d", maxALSAFrames)
+	}
+	rate := cfg.byteRate()
+	if rate > math.MaxUint32 {
+		return fmt.Errorf("%d bytes per second does not fit a WAV header", rate)
+	}
+	if rate > 0 && float64(rate)*cfg.RingSeconds > maxRingBytes {
+		return fmt.Errorf("ringSeconds must be at most %.1f at %d Hz, %d channel(s), %d-bit",
+			float64(maxRingBytes)/float64(rate), cfg.SampleRate, cfg.Channels, cfg.BytesPerSample*8)
+	}
+	return nil
+}
+
+// checkDeviceRate refuses a rate above the device's maximum before arecord
+// is started, so high-rate requests fail with what the device supports
+// rather than a bare open error. Rates up to 48 kHz skip the probe, as
+// every capture device handles them through plughw:. A device that cannot
+// be queried is given the benefit of the doubt.
+func checkDeviceRate(cfg AudioConfig) error {
+	if !needsHWParams(cfg) {
+		return nil
+	}
+	params, err := dumpHWParams(cfg)
+	if err != nil {
+		return nil
+	}
+	_, hi, err := parseRange(params["RATE"])
+	if err != nil || cfg.SampleRate <= hi {
+		return nil
+	}
+	return &CaptureError{
+		Code: ErrFormatUnsupported,
+		Err:  fmt.Errorf("%d Hz is above the device maximum of %d Hz", cfg.SampleRate, hi),
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHighRateSizes(t *testing.T) {
+	cfg := AudioConfig{SampleRate: 192000, Channels: 2, BytesPerSample: 3, SecondsPerChunk: 0.1}
+	if got := cfg.byteRate(); got != 1152000 {
+		t.Fatalf("byteRate = %d, want 1152000", got)
+	}
+	if err := cfg.validateSizes(); err != nil {
+		t.Fatal(err)
+	}
+	if got := chunkBytes(cfg); got != 115200 {
+		t.Errorf("chunkBytes = %d, want 115200", got)
+	}
+	// the fmt fields sit at the same offsets in both header layouts
+	h := wavChunk(nil, cfg.SampleRate, cfg.Channels, cfg.BytesPerSample)
+	if rate := binary.LittleEndian.Uint32(h[24:]); rate != 192000 {
+		t.Errorf("header SampleRate %d", rate)
+	}
+	if br := binary.LittleEndian.Uint32(h[28:]); br != 1152000 {
+		t.Errorf("header ByteRate %d, want 1152000", br)
+	}
+	if align := binary.LittleEndian.Uint16(h[32:]); align != 6 {
+		t.Errorf("header BlockAlign %d, want 6", align)
+	}
+
+	// 512 MiB of ring at 1152000 bytes a second
+	cfg.RingSeconds = 466
+	if err := cfg.validateSizes(); err != nil {
+		t.Errorf("ringSeconds 466: %v", err)
+	}
+	cfg.RingSeconds = 467
+	if err := cfg.validateSizes(); err == nil {
+		t.Error("ringSeconds 467 accepted beyond maxRingBytes")
+	}
+	cfg.RingSeconds = 0
+	cfg.SampleRate, cfg.Channels, cfg.BytesPerSample = maxSampleRate, maxChannels, 4
+	if err := cfg.validateSizes(); err != nil {
+		t.Errorf("largest layout: %v", err)
+	}
+}
+
+func TestCheckDeviceRateCachesProbe(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "probes")
+	fakeArecord(t, `echo probe >> `+count+`
+cat >&2 <<X
+HW Params of device "hw:7,0":
+--------------------
+ACCESS:  MMAP_INTERLEAVED RW_INTERLEAVED
+RATE: [44100 192000]
+--------------------
+X
+exec sleep 5`)
+	cfg := AudioConfig{Device: "hw:7,0", SampleRate: 192000, Channels: 2, BytesPerSample: 3}
+	forget := func() {
+		hwParamsMu.Lock()
+		delete(hwParamsCache, cfg.ResolvedDevice())
+		hwParamsMu.Unlock()
+	}
+	forget()
+	t.Cleanup(forget)
+
+	if err := checkDeviceRate(cfg); err != nil {
+		t.Fatalf("192 kHz refused: %v", err)
+	}
+	if err := checkDeviceRate(cfg); err != nil {
+		t.Fatalf("192 kHz refused on the cached probe: %v", err)
+	}
+	cfg.SampleRate = 384000
+	if err := checkDeviceRate(cfg); errorCodeOf(err) != ErrFormatUnsupported {
+		t.Fatalf("384 kHz: %v, want %s", err, ErrFormatUnsupported)
+	}
+	out, err := os.ReadFile(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(out), "probe"); n != 1 {
+		t.Fatalf("device probed %d times, want once", n)
+	}
+}
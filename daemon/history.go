@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// maxStateHistory bounds how many transitions are kept for replay.
+const maxStateHistory = 32
+
+// StateTransition is one recorded change of mic state.
+type StateTransition struct {
+	At        time.Time `json:"at"`
+	State     MicState  `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	ErrorCode ErrorCode `json:"errorCode,omitempty"`
+	// StateVersion is the state version the transition produced.
+	StateVersion uint64 `json:"stateVersion"`
+}
+
+// HistoryPayload is the payload of the history message sent on connect to
+// clients that ask to replay transitions.
+type HistoryPayload struct {
+	Transitions []StateTransition `json:"transitions"`
+}
+
+// stateHistory holds the most recent transitions, oldest first. Guarded by
+// stateMu.
+var stateHistory []StateTransition
+
+// recordTransition appends the current state if it differs from the last
+// recorded one. Callers hold stateMu.
+func recordTransition() {
+	t := StateTransition{At: time.Now(), State: micState, Error: micError, ErrorCode: micErrorCode, StateVersion: stateVersion}
+	if n := len(stateHistory); n > 0 {
+		last := stateHistory[n-1]
+		if last.State == t.State && last.Error == t.Error && last.ErrorCode == t.ErrorCode {
+			return
+		}
+	}
+	stateHistory = append(stateHistory, t)
+	if len(stateHistory) > maxStateHistory {
+		stateHistory = append(stateHistory[:0], stateHistory[len(stateHistory)-maxStateHistory:]...)
+	}
+}
+
+// recentTransitions returns up to n of the latest transitions. Callers hold
+// stateMu.
+func recentTransitions(n int) []StateTransition {
+	n = min(n, len(stateHistory))
+	out := make([]StateTransition, n)
+	copy(out, stateHistory[len(stateHistory)-n:])
+	return out
+}
+
+// stateVersion counts changes to the state shared by every connection. It
+// only grows, so a client holding an older version knows it missed updates.
+// Guarded by stateMu.
+var (
+	stateVersion     uint64
+	stateFingerprint string
+)
+
+// bumpStateVersion advances stateVersion when the shared state differs from
+// what was last broadcast. Callers hold stateMu.
+func bumpStateVersion() {
+	shared := struct {
+		State     MicState
+		Config    MicConfig
+		Error     string
+		ErrorCode ErrorCode
+		Effective *MicConfig
+		Recording string
+		Owner     uint64
+		Volume    *int
+		RTP       *RTPInfo
+		Mix       *MixInfo
+		Playback  string
+		Listeners []Listener
+	}{
+		State: micState, Config: currentConfig, Error: micError, ErrorCode: micErrorCode,
+		Recording: recordingPath(), Owner: sessionOwner, Volume: captureVolume,
+		RTP: rtpState(), Mix: mixState(), Listeners: listeners,
+	}
+	if shared.RTP != nil {
+		// a copy; its counter changes with every packet, not with the
+		// state
+		shared.RTP.Packets = 0
+	}
+	if audioSession != nil {
+		effective := MicConfig(audioSession.Config())
+		shared.Effective = &effective
+		shared.Playback = audioSession.Playback()
+	}
+	fingerprint, _ := json.Marshal(shared)
+	if string(fingerprint) != stateFingerprint {
+		stateFingerprint = string(fingerprint)
+		stateVersion++
+	}
+}
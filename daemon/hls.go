@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// hlsSegmentSeconds, when positive, enables the HLS endpoint with segments
+// of that length.
+var hlsSegmentSeconds float64
+
+const (
+	// hlsSegments is how many segments the playlist lists; older ones are
+	// deleted.
+	hlsSegments = 6
+	// maxHLSSegmentSeconds bounds the segment length.
+	maxHLSSegmentSeconds = 10
+)
+
+var hlsSegmentName = regexp.MustCompile(`^segment-\d+\.wav$`)
+
+type hlsSegment struct {
+	seq           int
+	seconds       float64
+	discontinuity bool
+}
+
+// hlsWriter cuts capture into WAV segments in dir and keeps a sliding
+// window of them for the live playlist.
+type hlsWriter struct {
+	mu       sync.Mutex
+	dir      string
+	cfg      AudioConfig
+	pending  []byte
+	nextSeq  int
+	segments []hlsSegment
+	// discontinuity marks the next segment as following a gap or a format
+	// change.
+	discontinuity bool
+}
+
+var hls *hlsWriter
+
+// startHLS prepares the segment directory, clearing what a previous run
+// left behind.
+func startHLS() error {
+	if hlsSegmentSeconds <= 0 {
+		return nil
+	}
+	if hlsSegmentSeconds > maxHLSSegmentSeconds {
+		return fmt.Errorf("-hls-segment-seconds must be at most %d", maxHLSSegmentSeconds)
+	}
+	dir := filepath.Join(os.TempDir(), "deskthing-mic-hls")
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	hls = &hlsWriter{dir: dir}
+	log.Println("HLS segments are written to", dir)
+	return nil
+}
+
+// writeHLS adds captured audio to the segment being built. It runs on the
+// capture goroutine.
+func writeHLS(cfg AudioConfig, pcm []byte) {
+	if hls == nil {
+		return
+	}
+	hls.mu.Lock()
+	defer hls.mu.Unlock()
+	if !hls.cfg.sameFormat(cfg) {
+		hls.cut()
+		hls.cfg = cfg
+		hls.discontinuity = hls.nextSeq > 0
+	}
+	hls.pending = append(hls.pending, pcm...)
+	frame := cfg.Channels * cfg.BytesPerSample
+	size := int(hlsSegmentSeconds*float64(cfg.SampleRate)) * frame
+	for size > 0 && len(hls.pending) >= size {
+		segment := hls.pending[:size]
+		hls.pending = hls.pending[size:]
+		hls.emit(segment)
+	}
+}
+
+// endHLS finishes the partial segment when capture stops, so the next
+// session starts after a discontinuity.
+func endHLS() {
+	if hls == nil {
+		return
+	}
+	hls.mu.Lock()
+	defer hls.mu.Unlock()
+	hls.cut()
+	hls.discontinuity = hls.nextSeq > 0
+}
+
+// cut emits whatever is pending as a short segment. Callers hold mu.
+func (h *hlsWriter) cut() {
+	if len(h.pending) > 0 {
+		h.emit(h.pending)
+	}
+	h.pending = nil
+}
+
+// emit writes one segment and drops the oldest beyond hlsSegments. Callers
+// hold mu.
+func (h *hlsWriter) emit(pcm []byte) {
+	cfg := h.cfg
+	name := fmt.Sprintf("segment-%d.wav", h.nextSeq)
+	tmp := filepath.Join(h.dir, "."+name)
+	if err := os.WriteFile(tmp, wavChunk(pcm, cfg.SampleRate, cfg.Channels, cfg.BytesPerSample), 0o644); err != nil {
+		log.Println("HLS segment write error:", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(h.dir, name)); err != nil {
+		log.Println("HLS segment write error:", err)
+		return
+	}
+	seconds := float64(len(pcm)) / float64(cfg.SampleRate*cfg.Channels*cfg.BytesPerSample)
+	h.segments = append(h.segments, hlsSegment{seq: h.nextSeq, seconds: seconds, discontinuity: h.discontinuity})
+	h.nextSeq++
+	h.discontinuity = false
+	for len(h.segments) > hlsSegments {
+		old := filepath.Join(h.dir, fmt.Sprintf("segment-%d.wav", h.segments[0].seq))
+		if err := os.Remove(old); err != nil {
+			log.Println("HLS segment cleanup error:", err)
+		}
+		h.segments = h.segments[1:]
+	}
+}
+
+// playlist renders the live media playlist for the retained segments.
+func (h *hlsWriter) playlist() (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.segments) == 0 {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(hlsSegmentSeconds)))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", h.segments[0].seq)
+	for _, s := range h.segments {
+		if s.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\nsegment-%d.wav\n", s.seconds, s.seq)
+	}
+	return b.String(), true
+}
+
+// handleHLS serves /hls/stream.m3u8 and the segments it lists.
+func handleHLS(w http.ResponseWriter, r *http.Request) {
+	if hls == nil {
+		http.Error(w, "HLS disabled; start the daemon with -hls-segment-seconds", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/hls/")
+	switch {
+	case name == "stream.m3u8":
+		playlist, ok := hls.playlist()
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "no segments yet; start capture", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(playlist))
+	case hlsSegmentName.MatchString(name):
+		w.Header().Set("Content-Type", "audio/wav")
+		http.ServeFile(w, r, filepath.Join(hls.dir, name))
+	default:
+		http.NotFound(w, r)
+	}
+}
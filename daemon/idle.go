@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// idleTimeout closes connections that send nothing, not even a ping, for
+// this long. Zero keeps idle connections open. A connection can ask for its
+// own with the idleTimeout query parameter.
+var idleTimeout time.Duration
+
+// maxIdleTimeout bounds idle timeouts.
+const maxIdleTimeout = 24 * time.Hour
+
+// parseIdleTimeout reads an idleTimeout query parameter: a duration such as
+// "30s", or whole seconds. Empty selects the global timeout and "0" none.
+func parseIdleTimeout(v string) (time.Duration, error) {
+	if v == "" {
+		return idleTimeout, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		n, nerr := strconv.Atoi(v)
+		if nerr != nil {
+			return 0, fmt.Errorf("invalid idleTimeout %q", v)
+		}
+		d = time.Duration(n) * time.Second
+	}
+	if d < 0 || d > maxIdleTimeout {
+		return 0, fmt.Errorf("idleTimeout must be between 0 and %v", maxIdleTimeout)
+	}
+	return d, nil
+}
+
+// armIdle starts the connection's idle timer, which every message, ping or
+// pong pushes back. Pings are still answered with pongs.
+func (c *client) armIdle(timeout time.Duration) {
+	c.idle = timeout
+	if timeout <= 0 {
+		return
+	}
+	c.touch()
+	c.conn.SetPingHandler(func(data string) error {
+		c.touch()
+		err := c.conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(closeWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		}
+		return err
+	})
+	c.conn.SetPongHandler(func(string) error {
+		c.touch()
+		return nil
+	})
+}
+
+// touch records activity, pushing the idle deadline back.
+func (c *client) touch() {
+	if c.idle > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.idle))
+	}
+}
+
+// isIdleTimeout reports whether a read failed because the idle deadline
+// passed.
+func isIdleTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxLabelRunes bounds a connection label.
+const maxLabelRunes = 64
+
+// sanitizeLabel makes a client-supplied label safe to log: non-printing
+// characters such as newlines and terminal escapes are dropped, surrounding
+// space trimmed and the result cut to maxLabelRunes.
+func sanitizeLabel(s string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			continue
+		}
+		if n == maxLabelRunes {
+			break
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// setLabel names the connection for logs, stats and state.
+func (c *client) setLabel(label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Label = label
+}
+
+func (c *client) label() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats.Label
+}
+
+// String identifies the connection in logs: its id, followed by its label
+// when it has one.
+func (c *client) String() string {
+	return describeClient(c.id, c.label())
+}
+
+// describeClient formats a connection id and label for logs, for callers
+// that already hold the client's mu.
+func describeClient(id uint64, label string) string {
+	if label != "" {
+		return strconv.FormatUint(id, 10) + " (" + strconv.Quote(label) + ")"
+	}
+	return strconv.FormatUint(id, 10)
+}
+
+// Listener is one connection that has sent mic-listen, as listed in state.
+type Listener struct {
+	ID    uint64 `json:"id"`
+	Label string `json:"label,omitempty"`
+}
+
+// listeners is the listener list state reports, rebuilt by refreshListeners.
+// Guarded by stateMu.
+var listeners []Listener
+
+// refreshListeners rebuilds listeners from the open connections. Callers
+// hold stateMu but not clientsMu.
+func refreshListeners() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	listeners = listeners[:0:0]
+	for c := range clients {
+		if c.listener {
+			listeners = append(listeners, Listener{ID: c.id, Label: c.label()})
+		}
+	}
+	sort.Slice(listeners, func(i, j int) bool { return listeners[i].ID < listeners[j].ID })
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// measureLatency enables per-chunk timestamping in new sessions.
+var measureLatency bool
+
+// latencyWindow is how many recent chunks the rolling average covers.
+const latencyWindow = 50
+
+// LatencyStats summarizes recent capture latency in milliseconds.
+type LatencyStats struct {
+	// ChunkMs is the audio duration of one chunk; the first sample of a chunk
+	// is at least this old by the time the chunk has been read.
+	ChunkMs float64 `json:"chunkMs"`
+	// PipelineMs is the rolling average from the end of a read to the hand
+	// off to the transport.
+	PipelineMs float64 `json:"pipelineMs"`
+	// TotalMs is ChunkMs plus PipelineMs.
+	TotalMs float64 `json:"totalMs"`
+	Samples int     `json:"samples"`
+}
+
+type latencyTracker struct {
+	mu      sync.Mutex
+	chunk   time.Duration
+	samples [latencyWindow]time.Duration
+	next    int
+	count   int
+}
+
+func newLatencyTracker(chunk time.Duration) *latencyTracker {
+	return &latencyTracker{chunk: chunk}
+}
+
+// observe records one chunk read at readAt and handed off at sentAt.
+func (t *latencyTracker) observe(readAt, sentAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples[t.next] = sentAt.Sub(readAt)
+	t.next = (t.next + 1) % latencyWindow
+	if t.count < latencyWindow {
+		t.count++
+	}
+}
+
+func (t *latencyTracker) stats() *LatencyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sum time.Duration
+	for i := 0; i < t.count; i++ {
+		sum += t.samples[i]
+	}
+	s := &LatencyStats{
+		ChunkMs: float64(t.chunk) / float64(time.Millisecond),
+		Samples: t.count,
+	}
+	if t.count > 0 {
+		s.PipelineMs = float64(sum) / float64(t.count) / float64(time.Millisecond)
+	}
+	s.TotalMs = s.ChunkMs + s.PipelineMs
+	return s
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// levelMeter enables level messages, computed once per captured chunk.
+var levelMeter bool
+
+// Level is the payload of a level message: the RMS and peak of one chunk
+// for each channel, as fractions of full scale, indexed by channel.
+type Level struct {
+	RMS  []float64 `json:"rms"`
+	Peak []float64 `json:"peak"`
+	// Clipping is set when any sample reached full scale; Clips counts
+	// those samples per channel.
+	Clipping bool  `json:"clipping"`
+	Clips    []int `json:"clips"`
+}
+
+// measureLevel de-interleaves PCM of bps bytes per sample and measures each
+// channel. Clipping is judged at the captured depth, so a 24-bit sample
+// only counts when it reaches the 24-bit limits.
+func measureLevel(pcm []byte, channels, bps int) Level {
+	fullScale := float64(int64(1) << (8*bps - 1))
+	sums := make([]float64, channels)
+	level := Level{
+		RMS:   make([]float64, channels),
+		Peak:  make([]float64, channels),
+		Clips: make([]int, channels),
+	}
+	frames := len(pcm) / (channels * bps)
+	for f := 0; f < frames; f++ {
+		for ch := 0; ch < channels; ch++ {
+			s := float64(sampleAt(pcm, f*channels+ch, bps))
+			if s >= fullScale-1 || s <= -fullScale {
+				level.Clips[ch]++
+				level.Clipping = true
+			}
+			v := math.Abs(s) / fullScale
+			sums[ch] += v * v
+			level.Peak[ch] = max(level.Peak[ch], v)
+		}
+	}
+	if frames > 0 {
+		for ch := range sums {
+			level.RMS[ch] = math.Sqrt(sums[ch] / float64(frames))
+		}
+	}
+	return level
+}
+
+// sampleAt decodes the i-th little-endian signed sample of bps bytes.
+func sampleAt(pcm []byte, i, bps int) int32 {
+	switch bps {
+	case 3:
+		b := pcm[3*i:]
+		return int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+	case 4:
+		return int32(binary.LittleEndian.Uint32(pcm[4*i:]))
+	}
+	return int32(int16(binary.LittleEndian.Uint16(pcm[2*i:])))
+}
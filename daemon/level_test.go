@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMeasureLevelPerChannel(t *testing.T) {
+	// left is a constant half-scale signal, right is silent apart from one peak
+	const frames = 100
+	pcm := make([]byte, frames*2*2)
+	for f := 0; f < frames; f++ {
+		putSample(pcm, 2*f, 2, 16384)
+	}
+	putSample(pcm, 2*50+1, 2, -8192)
+	l := measureLevel(pcm, 2, 2)
+	if len(l.RMS) != 2 || len(l.Peak) != 2 {
+		t.Fatalf("got %d RMS and %d peak values for stereo", len(l.RMS), len(l.Peak))
+	}
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+	if !near(l.RMS[0], 0.5) || !near(l.Peak[0], 0.5) {
+		t.Errorf("left rms %v peak %v, want 0.5 and 0.5", l.RMS[0], l.Peak[0])
+	}
+	if !near(l.Peak[1], 0.25) || !near(l.RMS[1], 0.25/math.Sqrt(frames)) {
+		t.Errorf("right rms %v peak %v, want %v and 0.25", l.RMS[1], l.Peak[1], 0.25/math.Sqrt(frames))
+	}
+	if l.Clipping {
+		t.Error("reported clipping below full scale")
+	}
+}
+
+func TestMeasureLevelClipping(t *testing.T) {
+	for _, tc := range []struct {
+		bps      int
+		min, max int32
+	}{
+		{2, -1 << 15, 1<<15 - 1},
+		{3, -1 << 23, 1<<23 - 1},
+	} {
+		// stereo: left saturates twice, right comes one step short
+		pcm := make([]byte, 4*2*tc.bps)
+		putSample(pcm, 0, tc.bps, tc.max)
+		putSample(pcm, 2, tc.bps, tc.min)
+		putSample(pcm, 1, tc.bps, tc.max-1)
+		putSample(pcm, 3, tc.bps, tc.min+1)
+		l := measureLevel(pcm, 2, tc.bps)
+		if !l.Clipping || l.Clips[0] != 2 || l.Clips[1] != 0 {
+			t.Errorf("%d-bit: clipping %v, clips %v; want true, [2 0]", 8*tc.bps, l.Clipping, l.Clips)
+		}
+	}
+	// a 16-bit full-scale value is far below 24-bit full scale
+	pcm := make([]byte, 3)
+	putSample(pcm, 0, 3, 1<<15-1)
+	if l := measureLevel(pcm, 1, 3); l.Clipping {
+		t.Error("24-bit sample at the 16-bit limit reported as clipping")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+)
+
+// listenNetworks are the values -network accepts. "tcp" binds a wildcard
+// address such as ":8890" or "[::]:8890" on both IPv4 and IPv6; "tcp4" and
+// "tcp6" restrict it to one stack, so "[::]:8890" with "tcp6" is IPv6 only.
+var listenNetworks = []string{"tcp", "tcp4", "tcp6"}
+
+// loopbackHosts are what "localhost" is bound as on each network. Binding
+// the name itself would pick whichever address the resolver lists first and
+// leave clients of the other stack unable to connect.
+var loopbackHosts = map[string][]string{
+	"tcp":  {"127.0.0.1", "::1"},
+	"tcp4": {"127.0.0.1"},
+	"tcp6": {"::1"},
+}
+
+// listenTCP opens the listeners for addr on network. A "localhost" host is
+// bound on each loopback address the network allows; one that cannot be
+// bound, such as ::1 on a host with IPv6 disabled, is skipped with a
+// warning as long as another succeeds.
+func listenTCP(network, addr string) ([]net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if host != "localhost" {
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+	var lns []net.Listener
+	var errs []error
+	for _, ip := range loopbackHosts[network] {
+		ln, err := net.Listen(network, net.JoinHostPort(ip, port))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lns = append(lns, ln)
+	}
+	if len(lns) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		slog.Warn("Could not bind a localhost address; continuing without it", "err", err)
+	}
+	return lns, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// skipWithoutIPv6 skips tests on hosts where ::1 cannot be bound.
+func skipWithoutIPv6(t *testing.T) {
+	t.Helper()
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenIPv6Loopback(t *testing.T) {
+	skipWithoutIPv6(t)
+	for _, tc := range []struct{ network, addr string }{
+		{"tcp", "[::1]:0"},
+		{"tcp6", "[::1]:0"},
+		{"tcp6", "localhost:0"},
+	} {
+		lns, err := listenTCP(tc.network, tc.addr)
+		if err != nil {
+			t.Fatalf("listenTCP(%s, %s): %v", tc.network, tc.addr, err)
+		}
+		if len(lns) != 1 {
+			t.Fatalf("listenTCP(%s, %s) opened %d listeners, want 1", tc.network, tc.addr, len(lns))
+		}
+		ln := lns[0]
+		if ip := ln.Addr().(*net.TCPAddr).IP; !ip.Equal(net.IPv6loopback) {
+			t.Errorf("listenTCP(%s, %s) bound %v, want ::1", tc.network, tc.addr, ip)
+		}
+		go func() {
+			if c, err := ln.Accept(); err == nil {
+				c.Close()
+			}
+		}()
+		conn, err := net.Dial("tcp6", ln.Addr().String())
+		if err != nil {
+			t.Errorf("dial %s: %v", ln.Addr(), err)
+		} else {
+			conn.Close()
+		}
+		ln.Close()
+	}
+}
+
+func TestListenLocalhostBothFamilies(t *testing.T) {
+	skipWithoutIPv6(t)
+	lns, err := listenTCP("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v4, v6 bool
+	for _, ln := range lns {
+		ip := ln.Addr().(*net.TCPAddr).IP
+		v4 = v4 || ip.Equal(net.IPv4(127, 0, 0, 1))
+		v6 = v6 || ip.Equal(net.IPv6loopback)
+		ln.Close()
+	}
+	if !v4 || !v6 {
+		t.Fatalf("localhost bound IPv4 %v, IPv6 %v; want both", v4, v6)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logLevel is the active log level. It can be changed at runtime through the
+// admin loglevel request.
+var logLevel slog.LevelVar
+
+// setupLogging routes slog and the standard log package through one handler
+// filtered by logLevel. Plain log calls are logged at info.
+func setupLogging() {
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &logLevel})
+	slog.SetDefault(slog.New(handler))
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
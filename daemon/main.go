@@ -1,8 +1,122 @@
 package main
 
-import "log"
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"slices"
+	"strings"
+)
 
 func main() {
+	var opts ServerOptions
+	flag.StringVar(&opts.Addr, "addr", ":8890", "address to listen on")
+	flag.StringVar(&opts.Network, "network", "tcp", "tcp to bind wildcard addresses on IPv4 and IPv6, tcp4 for IPv4 only or tcp6 for IPv6 only")
+	flag.StringVar(&opts.TLSCert, "tls-cert", "", "TLS certificate file; enables wss:// with -tls-key")
+	flag.StringVar(&opts.TLSKey, "tls-key", "", "TLS private key file")
+	flag.BoolVar(&opts.SelfSigned, "tls-self-signed", false, "serve wss:// with a generated self-signed certificate")
+	flag.StringVar(&opts.UnixSocket, "unix-socket", "", "also serve on a Unix domain socket at this path, accessible to the daemon's user and group")
+	flag.StringVar(&opts.AdminToken, "admin-token", "", "token required for admin requests; admin requests are disabled when empty")
+	flag.Float64Var(&maxSecondsPerChunk, "max-chunk-seconds", maxSecondsPerChunk, "largest secondsPerChunk a config may ask for")
+	flag.BoolVar(&measureLatency, "measure-latency", false, "timestamp chunks and report rolling capture latency in state")
+	defaultConfig := flag.String("default-config", "", "JSON mic config used until a client configures the mic")
+	autostart := flag.Bool("autostart", false, "start capturing at launch, before any client asks; every client that connects receives audio")
+	level := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	flag.DurationVar(&reconnectGrace, "reconnect-grace", 0, "keep a session alive this long after its last listener disconnects so it can be resumed with its token; 0 disables")
+	flag.StringVar(&recordDir, "record-dir", "", "directory mic-record-start writes WAV files to; recording is disabled when empty")
+	flag.BoolVar(&autoPlug, "auto-plug", false, "retry hw: devices through plughw: when they reject the requested format")
+	flag.StringVar(&wavFormat, "wav-format", wavFormat, "WAV fmt chunk: pcm, auto (extensible above 2 channels or 16 bits) or extensible")
+	flag.BoolVar(&levelMeter, "level-meter", false, "send per-channel RMS and peak level messages for every chunk")
+	flag.StringVar(&playbackDir, "playback-dir", "", "directory of WAV files mic-listen may stream in place of the microphone; playback is disabled when empty")
+	flag.StringVar(&mixDir, "mix-dir", "", "directory of WAV files mic-mix may mix into capture; mixing is disabled when empty")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 0, "close connections that send nothing, not even a ping, for this long; 0 keeps them open")
+	flag.Float64Var(&hlsSegmentSeconds, "hls-segment-seconds", 0, "serve capture as an HLS playlist at /hls/stream.m3u8 with WAV segments of this length; 0 disables")
+	flag.IntVar(&highWater, "high-water", highWater, "queued frames above which a dropPolicy=disconnect connection is falling behind")
+	flag.DurationVar(&highWaterFor, "high-water-for", 0, "close dropPolicy=disconnect connections whose queue stays above -high-water this long; 0 waits for the queue to fill")
+	flag.DurationVar(&writeTimeout, "write-timeout", writeTimeout, "close connections whose peer accepts no data for this long during a write; 0 waits forever")
+	flag.DurationVar(&stopGrace, "stop-grace", 0, "on stop, send arecord SIGINT and wait this long for it to flush before killing it; 0 kills at once")
+	flag.StringVar(&debugDumpDir, "debug-dump-dir", "", "debug: save the raw PCM and config of every session to this directory, keeping the last 8")
+	auditPath := flag.String("audit-log", "", "append connection and capture audit events to this file")
+	flag.StringVar(&configPath, "config", "", "JSON settings file applied after the flags and re-read on SIGHUP")
+	flag.Parse()
+
+	if err := parseWAVFormat(wavFormat); err != nil {
+		log.Fatal("-wav-format: ", err)
+	}
+	if maxSecondsPerChunk <= 0 || maxSecondsPerChunk > 3600 {
+		log.Fatal("-max-chunk-seconds must be between 0 and 3600")
+	}
+	if idleTimeout < 0 || idleTimeout > maxIdleTimeout {
+		log.Fatalf("-idle-timeout must be between 0 and %v", maxIdleTimeout)
+	}
+	if highWater < 1 || highWater >= clientQueueSize {
+		log.Fatalf("-high-water must be between 1 and %d", clientQueueSize-1)
+	}
+	if highWaterFor < 0 {
+		log.Fatal("-high-water-for must not be negative")
+	}
+	if writeTimeout < 0 {
+		log.Fatal("-write-timeout must not be negative")
+	}
+	if stopGrace < 0 || stopGrace > maxStopGrace {
+		log.Fatalf("-stop-grace must be between 0 and %v", maxStopGrace)
+	}
+	if !slices.Contains(listenNetworks, opts.Network) {
+		log.Fatalf("-network must be one of %s", strings.Join(listenNetworks, ", "))
+	}
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be set together")
+	}
+
+	setupLogging()
+	if l, err := parseLogLevel(*level); err != nil {
+		log.Fatal(err)
+	} else {
+		logLevel.Set(l)
+	}
 	log.Println("Starting DeskThing audio daemon...")
-	StartWebSocketServer()
+	checkRecorder()
+	if err := startHLS(); err != nil {
+		log.Fatal("HLS error: ", err)
+	}
+	if err := checkDebugDumpDir(); err != nil {
+		log.Fatal(err)
+	}
+	if *auditPath != "" {
+		if err := openAuditLog(*auditPath); err != nil {
+			log.Fatal("Audit log error:", err)
+		}
+	}
+	if *defaultConfig != "" {
+		var cfg MicConfig
+		if err := json.Unmarshal([]byte(*defaultConfig), &cfg); err != nil {
+			log.Fatal("Invalid -default-config:", err)
+		}
+		if err := AudioConfig(cfg).Validate(); err != nil {
+			log.Fatal("Invalid -default-config:", err)
+		}
+		defaultMicConfig = cfg
+		currentConfig = cfg
+	}
+	if configPath != "" {
+		fc, err := loadConfigFile(configPath)
+		if err != nil {
+			log.Fatal("Config file error: ", err)
+		}
+		stateMu.Lock()
+		applyConfigFile(fc, configPath)
+		stateMu.Unlock()
+		reloadOnSIGHUP()
+	}
+	logDiagnostics(opts)
+	if *autostart {
+		log.Println("Autostart enabled: capturing audio before any client connects")
+		audit("autostart", 0)
+		stateMu.Lock()
+		startSession(currentConfig)
+		stateMu.Unlock()
+	}
+	if err := StartWebSocketServer(opts); err != nil {
+		log.Fatal("ListenAndServe error:", err)
+	}
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Daemon-wide counters. Capture counters are updated from the capture
+// goroutine; the rest under stateMu or clientsMu.
+var (
+	connectionsTotal atomic.Uint64
+	sessionsStarted  atomic.Uint64
+	sessionErrors    atomic.Uint64
+	chunksCaptured   atomic.Uint64
+	bytesCaptured    atomic.Uint64
+	// departed holds the delivery totals of closed connections, so the
+	// daemon-wide totals do not shrink when clients leave.
+	departedSent    atomic.Uint64
+	departedBytes   atomic.Uint64
+	departedDropped atomic.Uint64
+)
+
+// Metrics is the reply to mic-metrics and the source of GET /metrics.
+type Metrics struct {
+	UptimeSeconds    float64         `json:"uptimeSeconds"`
+	Connections      int             `json:"connections"`
+	ConnectionsTotal uint64          `json:"connectionsTotal"`
+	SessionsStarted  uint64          `json:"sessionsStarted"`
+	SessionErrors    uint64          `json:"sessionErrors"`
+	ChunksCaptured   uint64          `json:"chunksCaptured"`
+	BytesCaptured    uint64          `json:"bytesCaptured"`
+	ChunksSent       uint64          `json:"chunksSent"`
+	BytesSent        uint64          `json:"bytesSent"`
+	ChunksDropped    uint64          `json:"chunksDropped"`
+	Session          *SessionMetrics `json:"session,omitempty"`
+	Clients          []ClientMetrics `json:"clients"`
+}
+
+// SessionMetrics counts what the running session has captured.
+type SessionMetrics struct {
+	StartedAt time.Time `json:"startedAt"`
+	Seconds   float64   `json:"seconds"`
+	Chunks    uint64    `json:"chunks"`
+	Bytes     uint64    `json:"bytes"`
+}
+
+// ClientMetrics is one connection's share of the delivery totals.
+type ClientMetrics struct {
+	ID            uint64 `json:"id"`
+	Remote        string `json:"remote"`
+	Label         string `json:"label,omitempty"`
+	ChunksSent    uint64 `json:"chunksSent"`
+	BytesSent     uint64 `json:"bytesSent"`
+	ChunksDropped uint64 `json:"chunksDropped"`
+}
+
+// countDeparted folds a closing connection's totals into departed.
+func countDeparted(c *client) {
+	stats := c.statsSnapshot()
+	departedSent.Add(stats.ChunksSent)
+	departedBytes.Add(stats.BytesSent)
+	departedDropped.Add(stats.ChunksDropped)
+}
+
+// collectMetrics snapshots every counter. Callers hold stateMu.
+func collectMetrics() Metrics {
+	m := Metrics{
+		UptimeSeconds:    time.Since(processStart).Seconds(),
+		ConnectionsTotal: connectionsTotal.Load(),
+		SessionsStarted:  sessionsStarted.Load(),
+		SessionErrors:    sessionErrors.Load(),
+		ChunksCaptured:   chunksCaptured.Load(),
+		BytesCaptured:    bytesCaptured.Load(),
+		ChunksSent:       departedSent.Load(),
+		BytesSent:        departedBytes.Load(),
+		ChunksDropped:    departedDropped.Load(),
+		Clients:          []ClientMetrics{},
+	}
+	if audioSession != nil {
+		m.Session = audioSession.metrics()
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	m.Connections = len(clients)
+	for c := range clients {
+		stats := c.statsSnapshot()
+		m.ChunksSent += stats.ChunksSent
+		m.BytesSent += stats.BytesSent
+		m.ChunksDropped += stats.ChunksDropped
+		m.Clients = append(m.Clients, ClientMetrics{
+			ID:            c.id,
+			Remote:        c.remote,
+			Label:         stats.Label,
+			ChunksSent:    stats.ChunksSent,
+			BytesSent:     stats.BytesSent,
+			ChunksDropped: stats.ChunksDropped,
+		})
+	}
+	return m
+}
+
+// handleMetrics serves the same counters as mic-metrics in the Prometheus
+// text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stateMu.Lock()
+	m := collectMetrics()
+	stateMu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, v := range []struct {
+		name, kind string
+		value      float64
+	}{
+		{"deskthing_mic_uptime_seconds", "gauge", m.UptimeSeconds},
+		{"deskthing_mic_connections", "gauge", float64(m.Connections)},
+		{"deskthing_mic_connections_total", "counter", float64(m.ConnectionsTotal)},
+		{"deskthing_mic_sessions_started_total", "counter", float64(m.SessionsStarted)},
+		{"deskthing_mic_session_errors_total", "counter", float64(m.SessionErrors)},
+		{"deskthing_mic_chunks_captured_total", "counter", float64(m.ChunksCaptured)},
+		{"deskthing_mic_bytes_captured_total", "counter", float64(m.BytesCaptured)},
+		{"deskthing_mic_chunks_sent_total", "counter", float64(m.ChunksSent)},
+		{"deskthing_mic_bytes_sent_total", "counter", float64(m.BytesSent)},
+		{"deskthing_mic_chunks_dropped_total", "counter", float64(m.ChunksDropped)},
+	} {
+		fmt.Fprintf(w, "# TYPE %s %s\n%s %g\n", v.name, v.kind, v.name, v.value)
+	}
+}
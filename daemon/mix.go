@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// mixDir holds the files mic-mix may play. Mixing is refused when it is
+// empty.
+var mixDir string
+
+// maxMixGain bounds the per-source gains of mic-mix.
+const maxMixGain = 4
+
+// mixBlockFrames is how many frames of the secondary source are decoded at
+// a time.
+const mixBlockFrames = 4096
+
+// MixInfo describes the active mix in state.
+type MixInfo struct {
+	File    string  `json:"file"`
+	MicGain float64 `json:"micGain"`
+	MixGain float64 `json:"mixGain"`
+	Loop    bool    `json:"loop,omitempty"`
+}
+
+// mixer sums a WAV file into the captured audio, converted to the capture
+// channel count and rate, until the file ends.
+type mixer struct {
+	info   MixInfo
+	path   string
+	source *wavReader
+	// cfg is the capture config whose rate and channels the converted
+	// samples match.
+	cfg       AudioConfig
+	resampler rateConverter
+	pending   []int16
+}
+
+var (
+	// mixMu guards activeMix, which is applied from the capture goroutine.
+	mixMu     sync.Mutex
+	activeMix *mixer
+)
+
+// startMix begins mixing info.File from mixDir into the capture stream.
+func startMix(info MixInfo) error {
+	if mixDir == "" {
+		return errors.New("mixing disabled; start the daemon with -mix-dir")
+	}
+	if info.File == "" || filepath.Base(info.File) != info.File || strings.HasPrefix(info.File, ".") {
+		return errors.New("file must name a WAV file in the mix directory")
+	}
+	if info.MicGain < 0 || info.MicGain > maxMixGain || info.MixGain < 0 || info.MixGain > maxMixGain {
+		return fmt.Errorf("gains must be between 0 and %d", maxMixGain)
+	}
+	path := filepath.Join(mixDir, info.File)
+	source, err := openWAV(path)
+	if err != nil {
+		return err
+	}
+	mixMu.Lock()
+	defer mixMu.Unlock()
+	if activeMix != nil {
+		activeMix.source.Close()
+	}
+	activeMix = &mixer{info: info, path: path, source: source}
+	log.Println("Mixing", path)
+	return nil
+}
+
+// stopMix ends any active mix.
+func stopMix() {
+	mixMu.Lock()
+	defer mixMu.Unlock()
+	if activeMix != nil {
+		activeMix.source.Close()
+		activeMix = nil
+	}
+}
+
+// mixState returns the active mix for state, or nil.
+func mixState() *MixInfo {
+	mixMu.Lock()
+	defer mixMu.Unlock()
+	if activeMix == nil {
+		return nil
+	}
+	info := activeMix.info
+	return &info
+}
+
+// applyMix mixes the active source into pcm captured with cfg, at its
+// sample width, whatever the width when the mix started. When the source
+// runs out, capture carries on alone and ended is true.
+func applyMix(cfg AudioConfig, pcm []byte) (out []byte, ended bool) {
+	mixMu.Lock()
+	defer mixMu.Unlock()
+	m := activeMix
+	if m == nil {
+		return pcm, false
+	}
+	bps := cfg.BytesPerSample
+	if _, ok := sampleFormats[bps]; !ok {
+		return pcm, false
+	}
+	count := len(pcm) / bps
+	done := m.fill(cfg, count)
+	n := min(count, len(m.pending))
+	// the source is decoded at 16 bits and scaled up to the capture width
+	scale := float64(int64(1) << (8 * (bps - 2)))
+	limit := float64(int64(1) << (8*bps - 1))
+	out = make([]byte, count*bps)
+	for i := 0; i < count; i++ {
+		v := float64(sampleAt(pcm, i, bps)) * m.info.MicGain
+		if i < n {
+			v += float64(m.pending[i]) * scale * m.info.MixGain
+		}
+		putSample(out, i, bps, int32(min(max(v, -limit), limit-1)))
+	}
+	m.pending = m.pending[n:]
+	if done && len(m.pending) == 0 {
+		log.Println("Mix source ended:", m.path)
+		m.source.Close()
+		activeMix = nil
+		ended = true
+	}
+	return out, ended
+}
+
+// fill converts source audio until want samples in cfg are pending, and
+// reports whether the source is exhausted.
+func (m *mixer) fill(cfg AudioConfig, want int) bool {
+	// pending is 16-bit whatever the capture width, so only a layout
+	// change discards it
+	if m.cfg.SampleRate != cfg.SampleRate || m.cfg.Channels != cfg.Channels {
+		m.cfg = cfg
+		m.pending = nil
+		m.resampler = nil
+		if m.source.sampleRate != cfg.SampleRate {
+			m.resampler = newResampler(m.source.sampleRate, cfg.SampleRate, cfg.Channels)
+		}
+	}
+	for len(m.pending) < want {
+		block, err := m.source.Read(mixBlockFrames)
+		if err == io.EOF && m.info.Loop {
+			if err := m.rewind(); err != nil {
+				log.Println("Mix rewind error:", err)
+				return true
+			}
+			continue
+		}
+		if err != nil {
+			return true
+		}
+		block = convertChannels(block, m.source.channels, cfg.Channels, "")
+		if m.resampler != nil {
+			block = m.resampler.Process(block)
+		}
+		m.pending = append(m.pending, block...)
+	}
+	return false
+}
+
+// rewind reopens the source from the start for looping.
+func (m *mixer) rewind() error {
+	m.source.Close()
+	source, err := openWAV(m.path)
+	if err != nil {
+		return err
+	}
+	m.source = source
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMixEveryWidth(t *testing.T) {
+	defer func(dir string) { mixDir = dir }(mixDir)
+	mixDir = t.TempDir()
+	// a constant quarter-scale mono source at 16 kHz
+	src := make([]byte, 2*16000)
+	for i := 0; i < 16000; i++ {
+		putSample(src, i, 2, 8192)
+	}
+	if err := os.WriteFile(filepath.Join(mixDir, "tone.wav"), wavChunk(src, 16000, 1, 2), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := startMix(MixInfo{File: "tone.wav", MicGain: 1, MixGain: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer stopMix()
+	// the width changes between chunks, as after a live mic-sample-format
+	for _, bps := range []int{2, 3, 4, 2} {
+		cfg := AudioConfig{SampleRate: 16000, Channels: 1, BytesPerSample: bps}
+		full := int64(1) << (8*bps - 1)
+		pcm := make([]byte, 160*bps)
+		for i := 0; i < 160; i++ {
+			putSample(pcm, i, bps, int32(full/2))
+		}
+		out, ended := applyMix(cfg, pcm)
+		if ended || len(out) != len(pcm) {
+			t.Fatalf("%d bytes: got %d bytes, ended %v", bps, len(out), ended)
+		}
+		if got, want := int64(sampleAt(out, 159, bps)), full/2+full/4; got != want {
+			t.Errorf("%d bytes: mixed sample %d, want %d", bps, got, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// playbackDir holds the WAV files mic-listen may stream in place of a
+// microphone. Playback is refused when it is empty.
+var playbackDir string
+
+// playbackPath resolves a playbackFile name inside playbackDir.
+func playbackPath(file string) (string, error) {
+	if playbackDir == "" {
+		return "", errors.New("playback disabled; start the daemon with -playback-dir")
+	}
+	if file == "" || filepath.Base(file) != file || strings.HasPrefix(file, ".") {
+		return "", errors.New("playbackFile must name a WAV file in the playback directory")
+	}
+	return filepath.Join(playbackDir, file), nil
+}
+
+// StartPlayback streams the WAV file at path through sendChunk as if it
+// were being captured: in chunks of cfg.SecondsPerChunk, at real-time pace,
+// at the file's own rate and channel count as 16-bit PCM, or at the depth a
+// live mic-sample-format change picked. Connections that asked for another
+// config get it converted as usual. With loop the file
+// restarts at its end; otherwise the session ends there.
+func StartPlayback(path string, loop bool, cfg AudioConfig, sendChunk func(cfg AudioConfig, pcm []byte)) (*AudioSession, error) {
+	source, err := openWAV(path)
+	if err != nil {
+		return nil, &CaptureError{Code: ErrFormatUnsupported, Err: err}
+	}
+	cfg.SampleRate = source.sampleRate
+	cfg.Channels = source.channels
+	cfg.BytesPerSample = 2
+	cfg.SourceChannel, cfg.NoiseGate = nil, nil
+	if cfg.SecondsPerChunk <= 0 {
+		cfg.SecondsPerChunk = defaultMicConfig.SecondsPerChunk
+	}
+	frames := int(float64(cfg.SampleRate) * cfg.SecondsPerChunk)
+	if frames <= 0 {
+		source.Close()
+		return nil, &CaptureError{Code: ErrInvalidConfig, Err: errors.New("secondsPerChunk too small for the file's rate")}
+	}
+	session := &AudioSession{
+		stopChan: make(chan struct{}),
+		draining: make(chan struct{}),
+		cfg:      cfg,
+		capture:  cfg,
+		stderr:   &tailBuffer{max: 4096},
+		done:     make(chan struct{}),
+		playback: filepath.Base(path),
+		started:  time.Now(),
+	}
+	if cfg.RingSeconds > 0 {
+		session.ring = newPCMRing(cfg, cfg.RingSeconds)
+	}
+	log.Printf("Playing %s (%d Hz, %d channel(s)) in place of capture", path, cfg.SampleRate, cfg.Channels)
+	go func() {
+		defer close(session.done)
+		defer func() {
+			if source != nil {
+				source.Close()
+			}
+		}()
+		ticker := time.NewTicker(time.Duration(cfg.SecondsPerChunk * float64(time.Second)))
+		defer ticker.Stop()
+		for {
+			samples, err := source.Read(frames)
+			if errors.Is(err, io.EOF) && loop {
+				source.Close()
+				source = nil
+				var next *wavReader
+				if next, err = openWAV(path); err == nil {
+					source = next
+					samples, err = source.Read(frames)
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				log.Println("Playback finished:", path)
+				return
+			}
+			if err != nil {
+				session.err = &CaptureError{Code: ErrRecorderExited, Err: fmt.Errorf("playback: %w", err)}
+				return
+			}
+			pcm := encodePCM16(samples)
+			if session.ring != nil {
+				session.ring.Write(pcm)
+			}
+			session.count(pcm)
+			sendChunk(session.outgoing(cfg, pcm))
+			select {
+			case <-session.stopChan:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return session, nil
+}
+
+// startPlaybackSession starts playback of path as the session and
+// broadcasts the result. Callers hold stateMu.
+func startPlaybackSession(cfg MicConfig, path string, loop bool) {
+	endSessionToken()
+	session, err := StartPlayback(path, loop, AudioConfig(cfg), broadcastAudio)
+	if err != nil {
+		log.Println("Playback start error:", err)
+		audioSession = nil
+		setMicError(errorCodeOf(err), "Playback error: "+err.Error())
+	} else {
+		audioSession = session
+		sessionConfig = cfg
+		sessionsStarted.Add(1)
+		setMicState(StateListening)
+		go watchSession(session)
+	}
+	broadcastState()
+}
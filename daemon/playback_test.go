@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlaybackLiveSampleFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tone.wav")
+	if err := os.WriteFile(path, wavChunk(wavCheckTone(16000, 1, 2, 1600), 16000, 1, 2), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	type chunk struct {
+		cfg AudioConfig
+		pcm []byte
+	}
+	chunks := make(chan chunk)
+	session, err := StartPlayback(path, true, AudioConfig{SecondsPerChunk: 0.02}, func(cfg AudioConfig, pcm []byte) {
+		chunks <- chunk{cfg, bytes.Clone(pcm)}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// drain the final send so playback can notice the stop
+		go session.Stop()
+		for {
+			select {
+			case <-chunks:
+			case <-session.done:
+				return
+			}
+		}
+	}()
+	receive := func() chunk {
+		select {
+		case c := <-chunks:
+			return c
+		case <-time.After(2 * time.Second):
+			t.Fatal("no chunk from playback")
+			return chunk{}
+		}
+	}
+	first := receive()
+	session.outDepth.Store(3)
+	// the chunk after next is the first certain to follow the change
+	receive()
+	got := receive()
+	if cfg := session.Config(); cfg.BytesPerSample != 3 || cfg.SampleFormat != SampleS24LE {
+		t.Fatalf("Config reports %d bytes, %s after the change", cfg.BytesPerSample, cfg.SampleFormat)
+	}
+	if got.cfg.BytesPerSample != 3 || got.cfg.SampleFormat != SampleS24LE {
+		t.Fatalf("playback delivered %d bytes, %s after the change", got.cfg.BytesPerSample, got.cfg.SampleFormat)
+	}
+	if len(got.pcm) != len(first.pcm)/2*3 {
+		t.Fatalf("chunk of %d bytes after the change, want %d", len(got.pcm), len(first.pcm)/2*3)
+	}
+}
+
+func TestPlaybackLoopFileRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tone.wav")
+	// two chunks, so the loop reopens the file on every third read
+	if err := os.WriteFile(path, wavChunk(wavCheckTone(16000, 1, 2, 640), 16000, 1, 2), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	removed := false
+	session, err := StartPlayback(path, true, AudioConfig{SecondsPerChunk: 0.02}, func(AudioConfig, []byte) {
+		if !removed {
+			removed = true
+			os.Remove(path)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-session.done:
+	case <-time.After(2 * time.Second):
+		session.Stop()
+		t.Fatal("looping playback kept running after its file was removed")
+	}
+	if code := errorCodeOf(session.err); session.err == nil || code != ErrRecorderExited {
+		t.Fatalf("playback ended with %v (%s), want a playback error", session.err, code)
+	}
+}
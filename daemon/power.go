@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// Power modes reported for connections listening with powerSave.
+const (
+	// PowerFull delivers audio as usual.
+	PowerFull = "full"
+	// PowerLow withholds audio and sends waveform updates instead, until
+	// speech returns.
+	PowerLow = "low"
+)
+
+// PowerSaveOptions tunes the powerSave listen option.
+type PowerSaveOptions struct {
+	// ThresholdDB is the RMS level in dBFS treated as speech.
+	ThresholdDB float64 `json:"thresholdDb"`
+	// QuietMs of continuous silence drops the connection to low power.
+	QuietMs int `json:"quietMs"`
+	// UpdateMs is how much audio each waveform update covers while in low
+	// power.
+	UpdateMs int `json:"updateMs"`
+	// WaveformPoints is the number of peaks in each waveform update.
+	WaveformPoints int `json:"waveformPoints"`
+}
+
+var defaultPowerSaveOptions = PowerSaveOptions{ThresholdDB: -45, QuietMs: 3000, UpdateMs: 250, WaveformPoints: 32}
+
+func (o PowerSaveOptions) validate() error {
+	if o.ThresholdDB >= 0 || o.ThresholdDB < -120 {
+		return errors.New("powerSave.thresholdDb must be between -120 and 0")
+	}
+	if o.QuietMs < 100 || o.QuietMs > 600000 {
+		return errors.New("powerSave.quietMs must be between 100 and 600000")
+	}
+	if o.UpdateMs < 50 || o.UpdateMs > 10000 {
+		return errors.New("powerSave.updateMs must be between 50 and 10000")
+	}
+	if o.WaveformPoints < 1 || o.WaveformPoints > 512 {
+		return errors.New("powerSave.waveformPoints must be between 1 and 512")
+	}
+	return nil
+}
+
+// parsePowerSave reads the powerSave listen option: false or null turns it
+// off, true enables the defaults and an object overrides some of them.
+func parsePowerSave(raw json.RawMessage) (*PowerSaveOptions, error) {
+	var on bool
+	if err := json.Unmarshal(raw, &on); err == nil {
+		if !on {
+			return nil, nil
+		}
+		opts := defaultPowerSaveOptions
+		return &opts, nil
+	}
+	opts := defaultPowerSaveOptions
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return nil, errors.New("powerSave must be true, false or an object")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+// PowerEvent is the payload of a power message, sent when a connection
+// changes power mode.
+type PowerEvent struct {
+	Mode string `json:"mode"`
+}
+
+// Waveform is the payload of a waveform message, sent in place of audio
+// while a connection is in low power. Levels are fractions of full scale
+// across all channels.
+type Waveform struct {
+	Ms    float64   `json:"ms"`
+	RMS   float64   `json:"rms"`
+	Peak  float64   `json:"peak"`
+	Peaks []float64 `json:"peaks"`
+}
+
+// powerSaver decides, chunk by chunk, whether a connection gets full audio
+// or only waveform updates. It wakes on the first speech frame and sleeps
+// after QuietMs without one.
+type powerSaver struct {
+	opts      PowerSaveOptions
+	threshold float64
+	mode      string
+	quietMs   float64
+	// pending is low-power audio not yet summarized, in pendingCfg.
+	pending    []byte
+	pendingCfg AudioConfig
+}
+
+func newPowerSaver(opts PowerSaveOptions) *powerSaver {
+	return &powerSaver{opts: opts, threshold: math.Pow(10, opts.ThresholdDB/20), mode: PowerFull}
+}
+
+// Process classifies one captured chunk. deliver reports whether it should
+// be sent as audio, changed whether the mode changed with it and updates
+// are the waveforms to send instead.
+func (p *powerSaver) Process(cfg AudioConfig, pcm []byte) (deliver, changed bool, updates []Waveform) {
+	frame := cfg.Channels * cfg.BytesPerSample
+	if frame <= 0 || cfg.SampleRate <= 0 {
+		return true, false, nil
+	}
+	step := max(cfg.SampleRate*vadFrameMs/1000, 1) * frame
+	speech := false
+	for off := 0; off < len(pcm); off += step {
+		win := pcm[off:min(off+step, len(pcm))]
+		if frameRMS(win, cfg.BytesPerSample) >= p.threshold {
+			speech = true
+			p.quietMs = 0
+		} else {
+			p.quietMs += float64(len(win)/frame) * 1000 / float64(cfg.SampleRate)
+		}
+	}
+	if p.mode == PowerLow {
+		if !speech {
+			return false, false, p.summarize(cfg, pcm)
+		}
+		p.mode, p.pending = PowerFull, nil
+		return true, true, nil
+	}
+	if p.quietMs >= float64(p.opts.QuietMs) {
+		p.mode = PowerLow
+		return true, true, nil
+	}
+	return true, false, nil
+}
+
+// summarize buffers low-power audio and returns a waveform for every
+// UpdateMs of it.
+func (p *powerSaver) summarize(cfg AudioConfig, pcm []byte) []Waveform {
+	if !p.pendingCfg.sameFormat(cfg) {
+		p.pending, p.pendingCfg = nil, cfg
+	}
+	p.pending = append(p.pending, pcm...)
+	frame := cfg.Channels * cfg.BytesPerSample
+	span := max(cfg.SampleRate*p.opts.UpdateMs/1000, 1) * frame
+	var out []Waveform
+	for len(p.pending) >= span {
+		out = append(out, measureWaveform(p.pending[:span], cfg, p.opts.WaveformPoints))
+		p.pending = p.pending[span:]
+	}
+	p.pending = append(p.pending[:0:0], p.pending...)
+	return out
+}
+
+// measureWaveform reduces PCM to points peaks plus its overall RMS and
+// peak.
+func measureWaveform(pcm []byte, cfg AudioConfig, points int) Waveform {
+	bps := cfg.BytesPerSample
+	fullScale := float64(int64(1) << (8*bps - 1))
+	samples := len(pcm) / bps
+	frames := samples / cfg.Channels
+	w := Waveform{
+		Ms:    float64(frames) * 1000 / float64(cfg.SampleRate),
+		Peaks: make([]float64, points),
+	}
+	var sum float64
+	for f := 0; f < frames; f++ {
+		bucket := f * points / frames
+		for ch := 0; ch < cfg.Channels; ch++ {
+			v := math.Abs(float64(sampleAt(pcm, f*cfg.Channels+ch, bps))) / fullScale
+			sum += v * v
+			w.Peaks[bucket] = max(w.Peaks[bucket], v)
+			w.Peak = max(w.Peak, v)
+		}
+	}
+	if samples > 0 {
+		w.RMS = math.Sqrt(sum / float64(frames*cfg.Channels))
+	}
+	return w
+}
+
+// setPowerSave enables power saving with opts, or turns it off when opts
+// is nil. The connection starts in full power.
+func (c *client) setPowerSave(opts *PowerSaveOptions) {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	c.power = nil
+	if opts != nil {
+		c.power = newPowerSaver(*opts)
+	}
+}
+
+// powerMode is the connection's current power mode, or empty without
+// powerSave.
+func (c *client) powerMode() string {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	if c.power == nil {
+		return ""
+	}
+	return c.power.mode
+}
+
+// lowPower reports whether audio is being withheld. Callers hold encMu.
+func (c *client) lowPower() bool {
+	return c.power != nil && c.power.mode == PowerLow
+}
+
+// deliverPowered is deliver for connections that may be saving power. While
+// low it sends waveform updates in place of audio; on a mode change it
+// flushes or resumes audio around a power message and pushes fresh state.
+func (c *client) deliverPowered(cfg AudioConfig, pcm []byte) ([]audioFrame, error) {
+	c.encMu.Lock()
+	deliver, changed, updates, mode := true, false, []Waveform(nil), ""
+	if c.power != nil {
+		deliver, changed, updates = c.power.Process(cfg, pcm)
+		mode = c.power.mode
+	}
+	c.encMu.Unlock()
+	if !deliver {
+		if c.subscribed(SubLevel) {
+			for _, w := range updates {
+				c.sendEvent("waveform", w)
+			}
+		}
+		return nil, nil
+	}
+	if changed && mode == PowerFull {
+		c.announcePower(mode)
+	}
+	frames, err := c.deliver(cfg, pcm)
+	if changed && mode == PowerLow {
+		rest, drainErr := c.drain()
+		for _, f := range append(frames, rest...) {
+			c.sendAudio(f)
+		}
+		c.announcePower(mode)
+		return nil, errors.Join(err, drainErr)
+	}
+	return frames, err
+}
+
+// announcePower tells the connection its power mode changed. State is
+// sent from another goroutine since capture never waits on stateMu.
+func (c *client) announcePower(mode string) {
+	if !c.subscribed(SubState) {
+		return
+	}
+	c.sendEvent("power", PowerEvent{Mode: mode})
+	go func() {
+		stateMu.Lock()
+		sendState(c)
+		stateMu.Unlock()
+	}()
+}
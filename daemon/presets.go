@@ -0,0 +1,42 @@
+package main
+
+import "sort"
+
+// presets are named starting points for MicConfig. A config naming a preset
+// starts from its values; any field the config also sets wins.
+var presets = map[string]MicConfig{
+	// low-latency keeps chunks and ALSA buffers small for live monitoring
+	// and push-to-talk, at the cost of more frames per second.
+	"low-latency": {
+		SampleRate:      16000,
+		Channels:        1,
+		BytesPerSample:  2,
+		SecondsPerChunk: 0.02,
+		PeriodFrames:    160,
+		BufferFrames:    640,
+	},
+	// balanced suits speech recognition: 16 kHz mono in 100 ms chunks.
+	"balanced": {
+		SampleRate:      16000,
+		Channels:        1,
+		BytesPerSample:  2,
+		SecondsPerChunk: 0.1,
+	},
+	// high-quality captures 48 kHz stereo at 24-bit for recording.
+	"high-quality": {
+		SampleRate:      48000,
+		Channels:        2,
+		BytesPerSample:  3,
+		SecondsPerChunk: 0.5,
+	},
+}
+
+// presetNames lists the presets in a stable order.
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
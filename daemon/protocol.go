@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Protocol selects the JSON layout of outbound messages for a connection.
+type Protocol string
+
+const (
+	// ProtocolLegacy is the original {type, request, payload} layout that
+	// the DeskThing client expects. State messages always carry
+	// request: "mic".
+	ProtocolLegacy Protocol = "legacy"
+	// ProtocolV2 is {v: 2, type, data}, with request only present when the
+	// message answers a specific client request.
+	ProtocolV2 Protocol = "v2"
+)
+
+// Websocket subprotocol names that select a Protocol.
+const (
+	subprotocolLegacy = "deskthing-mic"
+	subprotocolV2     = "deskthing-mic.v2"
+)
+
+// selectProtocol picks the protocol from the negotiated subprotocol, falling
+// back to the protocol query parameter and then to legacy.
+func selectProtocol(r *http.Request, subprotocol string) (Protocol, error) {
+	switch subprotocol {
+	case subprotocolV2:
+		return ProtocolV2, nil
+	case subprotocolLegacy:
+		return ProtocolLegacy, nil
+	}
+	switch p := Protocol(r.URL.Query().Get("protocol")); p {
+	case "":
+		return ProtocolLegacy, nil
+	case ProtocolLegacy, ProtocolV2:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown protocol %q", r.URL.Query().Get("protocol"))
+}
+
+// Message is the legacy layout of an outbound message.
+type Message struct {
+	Type    string          `json:"type"`
+	Request string          `json:"request"`
+	Payload interface{}     `json:"payload"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// messageV2 is the v2 layout: versioned, with the payload under data and
+// request only when it names a client request.
+type messageV2 struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	Request string          `json:"request,omitempty"`
+	Data    interface{}     `json:"data"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// negotiatedExtensions reports the extensions the upgrader accepted from
+// the client's offer. Compression is the only one gorilla/websocket
+// implements, and only when the upgrader enables it.
+func negotiatedExtensions(u *websocket.Upgrader, offered string) []string {
+	extensions := []string{}
+	if u.EnableCompression && strings.Contains(offered, "permessage-deflate") {
+		extensions = append(extensions, "permessage-deflate")
+	}
+	return extensions
+}
+
+// OutMessage is an outbound message independent of protocol layout. Request
+// is the legacy request field; "mic" is the legacy placeholder for
+// broadcasts and is dropped in v2. ID, when set, is the correlation id of
+// the command being answered.
+type OutMessage struct {
+	Type    string
+	Request string
+	ID      json.RawMessage
+	Payload interface{}
+}
+
+// encode marshals m in the layout of protocol p.
+func (m OutMessage) encode(p Protocol) ([]byte, error) {
+	if p == ProtocolV2 {
+		request := m.Request
+		if request == "mic" {
+			request = ""
+		}
+		return json.Marshal(messageV2{V: 2, Type: m.Type, Request: request, Data: m.Payload, ID: m.ID})
+	}
+	return json.Marshal(Message{Type: m.Type, Request: m.Request, Payload: m.Payload, ID: m.ID})
+}
+
+// sendOut queues m for c, logging rather than sending a message that cannot
+// be marshalled.
+func (c *client) sendOut(m OutMessage) {
+	msg, err := m.encode(c.protocol)
+	if err != nil {
+		log.Printf("Client %s: cannot marshal %s message: %v", c, m.Type, err)
+		return
+	}
+	c.send(websocket.TextMessage, msg)
+}
+
+// sendMessage queues a JSON message for c in its negotiated layout, tagged
+// with the id of the command c is being answered for. Callers hold stateMu;
+// the capture goroutine uses sendEvent.
+func (c *client) sendMessage(msgType, request string, payload interface{}) {
+	c.sendOut(OutMessage{Type: msgType, Request: request, ID: c.replyID, Payload: payload})
+}
+
+// sendEvent queues a message that answers no command, such as a level
+// update, without touching state guarded by stateMu.
+func (c *client) sendEvent(msgType string, payload interface{}) {
+	c.sendOut(OutMessage{Type: msgType, Request: "mic", Payload: payload})
+}
+
+// Payloads of replies that carry a single field.
+type (
+	ErrorPayload struct {
+		Error string `json:"error"`
+		// Code and AvailableFormats are set when a format is unavailable.
+		Code             ErrorCode `json:"code,omitempty"`
+		AvailableFormats []string  `json:"availableFormats,omitempty"`
+	}
+	DumpPayload struct {
+		Bytes int `json:"bytes"`
+	}
+	LogLevelPayload struct {
+		Level string `json:"level"`
+	}
+	SubscriptionsPayload struct {
+		Types []string `json:"types"`
+	}
+	VADEvent struct {
+		Event string `json:"event"`
+	}
+)
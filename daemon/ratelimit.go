@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// logThrottle is the window in which repeats of a throttled log line are
+// counted instead of written.
+const logThrottle = 10 * time.Second
+
+// throttledLog writes the first occurrence of a repeated error and then at
+// most one summary per logThrottle, so a failure loop cannot flood the log.
+// Each format string is throttled on its own, so one error cannot hide
+// another.
+type throttledLog struct {
+	mu    sync.Mutex
+	lines map[string]*throttledLine
+}
+
+// throttledLine is the throttle state of one format string.
+type throttledLine struct {
+	last       time.Time
+	suppressed int
+}
+
+// Printf logs unless a line of the same format went out within logThrottle,
+// in which case the occurrence is counted and reported with the next line
+// of that format written.
+func (t *throttledLog) Printf(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	line := t.lines[format]
+	if line == nil {
+		if t.lines == nil {
+			t.lines = make(map[string]*throttledLine)
+		}
+		line = &throttledLine{}
+		t.lines[format] = line
+	}
+	now := time.Now()
+	if !line.last.IsZero() && now.Sub(line.last) < logThrottle {
+		line.suppressed++
+		return
+	}
+	if line.suppressed > 0 {
+		log.Printf(format+" (%d similar messages suppressed)", append(args, line.suppressed)...)
+	} else {
+		log.Printf(format, args...)
+	}
+	line.last = now
+	line.suppressed = 0
+}
+
+var (
+	readErrorLog   throttledLog
+	encodeErrorLog throttledLog
+	rtpErrorLog    throttledLog
+)
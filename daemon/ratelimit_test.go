@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestThrottledLogPerFormat(t *testing.T) {
+	var out bytes.Buffer
+	defer func(w io.Writer, flags int) { log.SetOutput(w); log.SetFlags(flags) }(log.Writer(), log.Flags())
+	log.SetOutput(&out)
+	log.SetFlags(0)
+
+	var l throttledLog
+	l.Printf("arecord read interrupted, retrying: %v", "EINTR")
+	l.Printf("arecord read interrupted, retrying: %v", "EINTR")
+	l.Printf("arecord read error: %v", "EIO")
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{"arecord read interrupted, retrying: EINTR", "arecord read error: EIO"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("logged %q, want %q", got, want)
+	}
+
+	// once the window passes, each format reports only its own repeats
+	out.Reset()
+	for _, line := range l.lines {
+		line.last = line.last.Add(-logThrottle)
+	}
+	l.Printf("arecord read interrupted, retrying: %v", "EINTR")
+	l.Printf("arecord read error: %v", "EIO")
+	got = strings.Split(strings.TrimSpace(out.String()), "\n")
+	want = []string{"arecord read interrupted, retrying: EINTR (1 similar messages suppressed)", "arecord read error: EIO"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("logged %q after the window, want %q", got, want)
+	}
+}
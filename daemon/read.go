@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+)
+
+// maxReadRetries bounds how many retryable errors in a row readChunk
+// tolerates before giving up on the pipe.
+const maxReadRetries = 100
+
+// retryBackoff is the pause after EAGAIN, when the pipe has no data yet.
+const retryBackoff = 5 * time.Millisecond
+
+// isRetryableRead reports whether a pipe read error is transient.
+func isRetryableRead(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+// readChunk fills buf like io.ReadFull, but retries reads interrupted by
+// EINTR or EAGAIN instead of ending the stream. It returns how many bytes it
+// read, with io.EOF only when nothing was read and io.ErrUnexpectedEOF for a
+// partial chunk.
+func readChunk(r io.Reader, buf []byte) (int, error) {
+	filled, retries := 0, 0
+	for filled < len(buf) {
+		n, err := r.Read(buf[filled:])
+		filled += n
+		switch {
+		case err == nil:
+			retries = 0
+		case isRetryableRead(err):
+			if n > 0 {
+				retries = 0
+			}
+			retries++
+			readErrorLog.Printf("arecord read interrupted, retrying: %v", err)
+			if retries > maxReadRetries {
+				return filled, err
+			}
+			if errors.Is(err, syscall.EAGAIN) {
+				time.Sleep(retryBackoff)
+			}
+		case errors.Is(err, io.EOF):
+			if filled == len(buf) {
+				return filled, nil
+			}
+			if filled > 0 {
+				return filled, io.ErrUnexpectedEOF
+			}
+			return 0, io.EOF
+		default:
+			return filled, err
+		}
+	}
+	return filled, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// flakyReader returns its scripted results in order, then reads from data.
+type flakyReader struct {
+	data  *bytes.Reader
+	steps []error
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if len(r.steps) > 0 {
+		err := r.steps[0]
+		r.steps = r.steps[1:]
+		if err != nil {
+			return 0, err
+		}
+		// a nil step delivers a single byte, a short read
+		return r.data.Read(p[:1])
+	}
+	return r.data.Read(p)
+}
+
+func TestReadChunkRetries(t *testing.T) {
+	want := []byte("0123456789")
+	r := &flakyReader{
+		data: bytes.NewReader(want),
+		steps: []error{
+			syscall.EINTR,
+			nil,
+			&os.PathError{Op: "read", Path: "|0", Err: syscall.EAGAIN},
+			nil,
+			syscall.EINTR,
+		},
+	}
+	buf := make([]byte, len(want))
+	n, err := readChunk(r, buf)
+	if err != nil || n != len(want) || !bytes.Equal(buf, want) {
+		t.Fatalf("readChunk = %d, %v, %q; want %d, nil, %q", n, err, buf[:n], len(want), want)
+	}
+}
+
+func TestReadChunkGivesUp(t *testing.T) {
+	steps := make([]error, maxReadRetries+1)
+	for i := range steps {
+		steps[i] = syscall.EINTR
+	}
+	r := &flakyReader{data: bytes.NewReader(nil), steps: steps}
+	if _, err := readChunk(r, make([]byte, 4)); !errors.Is(err, syscall.EINTR) {
+		t.Fatalf("readChunk after %d interruptions returned %v, want EINTR", len(steps), err)
+	}
+}
+
+func TestReadChunkEOF(t *testing.T) {
+	buf := make([]byte, 4)
+	if n, err := readChunk(bytes.NewReader(nil), buf); n != 0 || err != io.EOF {
+		t.Errorf("empty pipe: %d, %v; want 0, EOF", n, err)
+	}
+	if n, err := readChunk(bytes.NewReader([]byte{1, 2}), buf); n != 2 || err != io.ErrUnexpectedEOF {
+		t.Errorf("partial chunk: %d, %v; want 2, ErrUnexpectedEOF", n, err)
+	}
+	if _, err := readChunk(&flakyReader{data: bytes.NewReader(nil), steps: []error{syscall.EIO}}, buf); !errors.Is(err, syscall.EIO) {
+		t.Errorf("EIO: %v, want it returned", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// reconnectGrace is how long a session outlives its last listener so a
+// client that drops off can present its session token and resume. Zero
+// disables tokens and keeps sessions running until mic-stop.
+var reconnectGrace time.Duration
+
+var (
+	// sessionToken identifies the running logical session. It survives
+	// device switches and is cleared when the session ends.
+	sessionToken string
+	expiryTimer  *time.Timer
+)
+
+// sessionInfo is sent in reply to mic-listen when reconnect tokens are
+// enabled.
+type sessionInfo struct {
+	Token string `json:"token"`
+	// Resumed reports whether the presented token matched the running
+	// session, so its ring buffer is intact.
+	Resumed      bool    `json:"resumed"`
+	GraceSeconds float64 `json:"graceSeconds"`
+}
+
+func newSessionToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// issueSessionToken gives the running session a token if it has none.
+// Callers hold stateMu.
+func issueSessionToken() {
+	if reconnectGrace > 0 && audioSession != nil && sessionToken == "" {
+		sessionToken = newSessionToken()
+	}
+}
+
+// endSessionToken forgets the token of a session that has stopped. Callers
+// hold stateMu.
+func endSessionToken() {
+	sessionToken = ""
+	cancelExpiry()
+}
+
+func cancelExpiry() {
+	if expiryTimer != nil {
+		expiryTimer.Stop()
+		expiryTimer = nil
+	}
+}
+
+// sendSessionInfo tells c the session token after mic-listen. Callers hold
+// stateMu.
+func sendSessionInfo(c *client, presented string) {
+	if sessionToken == "" {
+		return
+	}
+	c.sendMessage("session", "mic-listen", sessionInfo{
+		Token:        sessionToken,
+		Resumed:      presented != "" && presented == sessionToken,
+		GraceSeconds: reconnectGrace.Seconds(),
+	})
+}
+
+// hasListeners reports whether any connection other than except has sent
+// mic-listen. Callers hold stateMu.
+func hasListeners(except *client) bool {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for c := range clients {
+		if c != except && c.listener {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleExpiry stops the session once the grace period passes without a
+// listener returning. Callers hold stateMu.
+func scheduleExpiry(leaving *client) {
+	if sessionToken == "" || audioSession == nil || hasListeners(leaving) {
+		return
+	}
+	cancelExpiry()
+	session := audioSession
+	expiryTimer = time.AfterFunc(reconnectGrace, func() {
+		stateMu.Lock()
+		defer stateMu.Unlock()
+		if audioSession != session || hasListeners(nil) {
+			return
+		}
+		log.Println("Session abandoned; stopping capture")
+		audit("session-expired", 0)
+		stopSession()
+		setMicState(StateIdle)
+		broadcastState()
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordDir is where mic-record-start writes WAV files. Recording is
+// refused when it is empty.
+var recordDir string
+
+// recordOptions is the mic-record-start payload.
+type recordOptions struct {
+	// BEXT adds a Broadcast Wave bext chunk stamped with the start time.
+	BEXT        bool   `json:"bext"`
+	Description string `json:"description"`
+	// Format is "wav" (default) or "flac".
+	Format string `json:"format"`
+	// Metadata is written as a LIST/INFO chunk, or as Vorbis comments for
+	// FLAC. Keys are names such as "title" or raw INFO ids such as "IENG".
+	Metadata map[string]string `json:"metadata"`
+}
+
+// maxMetadataValue bounds each metadata value written to a recording.
+const maxMetadataValue = 1024
+
+func (o recordOptions) validate() error {
+	for key, value := range o.Metadata {
+		if _, ok := infoID(key); !ok {
+			return fmt.Errorf("unknown metadata key %q", key)
+		}
+		if len(value) > maxMetadataValue || strings.ContainsRune(value, 0) {
+			return fmt.Errorf("invalid metadata value for %q", key)
+		}
+	}
+	return nil
+}
+
+// recording is a file being written from the capture stream. WAV headers
+// go out with placeholder sizes that finish patches; FLAC files are written
+// by the flac process, which completes STREAMINFO when its input ends.
+type recording struct {
+	file      *os.File
+	flac      *flacEncoder
+	path      string
+	cfg       AudioConfig
+	headerLen int64
+	dataLen   int64
+	err       error
+}
+
+// RecordingInfo describes a recording in replies to record requests.
+type RecordingInfo struct {
+	Path    string  `json:"path"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+}
+
+var (
+	// recordMu guards activeRecording, which is written from the capture
+	// goroutine.
+	recordMu        sync.Mutex
+	activeRecording *recording
+)
+
+// startRecording opens a new WAV file in recordDir for audio in cfg.
+func startRecording(cfg AudioConfig, opts recordOptions) (*RecordingInfo, error) {
+	if recordDir == "" {
+		return nil, errors.New("recording disabled; start the daemon with -record-dir")
+	}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	if activeRecording != nil {
+		return nil, errors.New("already recording to " + activeRecording.path)
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	switch opts.Format {
+	case "", "wav":
+	case FormatFLAC:
+		if opts.BEXT {
+			return nil, errors.New("bext is only supported for wav recordings")
+		}
+		name := fmt.Sprintf("mic-%s.flac", start.Format("20060102-150405.000"))
+		path := filepath.Join(recordDir, name)
+		enc, err := newFLACEncoder(cfg, path, opts.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		activeRecording = &recording{flac: enc, path: path, cfg: cfg}
+		log.Println("Recording to", path)
+		return activeRecording.info(), nil
+	default:
+		return nil, fmt.Errorf("unknown recording format %q", opts.Format)
+	}
+	name := fmt.Sprintf("mic-%s.wav", start.Format("20060102-150405.000"))
+	path := filepath.Join(recordDir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &bytes.Buffer{}
+	header.WriteString("RIFF")
+	binary.Write(header, binary.LittleEndian, uint32(0))
+	header.WriteString("WAVE")
+	if opts.BEXT {
+		writeRIFFChunk(header, "bext", bextBody(bextInfo{
+			Description: opts.Description,
+			Originator:  "deskthing-mic",
+			Start:       start,
+			SampleRate:  cfg.SampleRate,
+		}))
+	}
+	writeFmtChunk(header, cfg.SampleRate, cfg.Channels, cfg.BytesPerSample)
+	if len(opts.Metadata) > 0 {
+		// LIST sits between fmt and data, where every reader expects
+		// optional chunks; readers skip INFO ids they do not know
+		writeRIFFChunk(header, "LIST", listInfoBody(opts.Metadata))
+	}
+	header.WriteString("data")
+	binary.Write(header, binary.LittleEndian, uint32(0))
+	if _, err := file.Write(header.Bytes()); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	activeRecording = &recording{file: file, path: path, cfg: cfg, headerLen: int64(header.Len())}
+	log.Println("Recording to", path)
+	return activeRecording.info(), nil
+}
+
+// writeRecording appends a captured chunk to the active recording. A chunk
+// in a different config, e.g. after a device switch, ends the recording
+// since one WAV file cannot change format.
+func writeRecording(cfg AudioConfig, pcm []byte) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	r := activeRecording
+	if r == nil || r.err != nil {
+		return
+	}
+	if cfg.SampleRate != r.cfg.SampleRate || cfg.Channels != r.cfg.Channels || cfg.BytesPerSample != r.cfg.BytesPerSample {
+		log.Println("Capture format changed; ending recording", r.path)
+		r.finish()
+		activeRecording = nil
+		return
+	}
+	var err error
+	if r.flac != nil {
+		_, err = r.flac.Encode(pcm)
+		if err == nil {
+			r.dataLen += int64(len(pcm))
+		}
+	} else {
+		var n int
+		n, err = r.file.Write(pcm)
+		r.dataLen += int64(n)
+	}
+	if err != nil {
+		log.Println("Recording write error:", err)
+		r.err = err
+	}
+}
+
+// stopRecording finalizes the active recording, if any.
+func stopRecording() (*RecordingInfo, error) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	r := activeRecording
+	if r == nil {
+		return nil, errors.New("not recording")
+	}
+	activeRecording = nil
+	err := r.finish()
+	if err == nil {
+		err = r.err
+	}
+	return r.info(), err
+}
+
+// finish pads the data chunk, patches the RIFF and data sizes and closes
+// the file. For FLAC it waits for the encoder to finalize the file.
+func (r *recording) finish() error {
+	if r.flac != nil {
+		if _, err := r.flac.Close(); err != nil {
+			return err
+		}
+		log.Printf("Recording saved to %s (%d PCM bytes)", r.path, r.dataLen)
+		return nil
+	}
+	defer r.file.Close()
+	if r.dataLen%2 == 1 {
+		r.file.Write([]byte{0})
+	}
+	riffSize := uint32(r.headerLen - 8 + r.dataLen + r.dataLen%2)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], riffSize)
+	if _, err := r.file.WriteAt(size[:], 4); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(size[:], uint32(r.dataLen))
+	if _, err := r.file.WriteAt(size[:], r.headerLen-4); err != nil {
+		return err
+	}
+	log.Printf("Recording saved to %s (%d bytes)", r.path, r.dataLen)
+	return nil
+}
+
+func (r *recording) info() *RecordingInfo {
+	bytesPerSecond := r.cfg.SampleRate * r.cfg.Channels * r.cfg.BytesPerSample
+	return &RecordingInfo{
+		Path:    r.path,
+		Bytes:   r.dataLen,
+		Seconds: float64(r.dataLen) / float64(bytesPerSecond),
+	}
+}
+
+// recordingPath returns the file being recorded to, or "" when idle.
+func recordingPath() string {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	if activeRecording == nil {
+		return ""
+	}
+	return activeRecording.path
+}
+
+// endRecording finalizes any recording when its session ends.
+func endRecording() {
+	stopRecording()
+}
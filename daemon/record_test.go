@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestListInfoBodyLayout(t *testing.T) {
+	got := listInfoBody(map[string]string{"title": "Take 1", "software": "dt", "IENG": "sam"})
+	want := []byte("INFO" +
+		"IENG\x04\x00\x00\x00sam\x00" +
+		"INAM\x07\x00\x00\x00Take 1\x00\x00" + // odd body, padded
+		"ISFT\x03\x00\x00\x00dt\x00\x00")
+	if !bytes.Equal(got, want) {
+		t.Fatalf("listInfoBody\n got % x\nwant % x", got, want)
+	}
+}
+
+// riffChunks returns the ids of the top-level chunks of a WAV file in order.
+func riffChunks(t *testing.T, b []byte) []string {
+	t.Helper()
+	if string(b[:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		t.Fatalf("not a WAVE file: % x", b[:12])
+	}
+	if size := binary.LittleEndian.Uint32(b[4:]); int(size) != len(b)-8 {
+		t.Fatalf("RIFF size %d, file is %d bytes", size, len(b))
+	}
+	var ids []string
+	for off := 12; off+8 <= len(b); {
+		ids = append(ids, string(b[off:off+4]))
+		size := int(binary.LittleEndian.Uint32(b[off+4:]))
+		off += 8 + size + size%2
+	}
+	return ids
+}
+
+func TestRecordingMetadataPosition(t *testing.T) {
+	defer func(dir string) { recordDir = dir }(recordDir)
+	recordDir = t.TempDir()
+	cfg := AudioConfig{SampleRate: 16000, Channels: 1, BytesPerSample: 2}
+	info, err := startRecording(cfg, recordOptions{BEXT: true, Metadata: map[string]string{"title": "Take 1", "IXYZ": "unknown to players"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecording(cfg, make([]byte, 3200))
+	if _, err := stopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(info.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := riffChunks(t, b)
+	if want := []string{"bext", "fmt ", "LIST", "data"}; !slices.Equal(ids, want) {
+		t.Fatalf("chunks %q, want %q", ids, want)
+	}
+	// readers that stop at fmt and data still find the audio
+	r, err := openWAV(info.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	samples, err := r.Read(2000)
+	if err != nil || len(samples) != 1600 {
+		t.Fatalf("read %d frames, %v; want 1600", len(samples), err)
+	}
+}
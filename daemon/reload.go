@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// FileConfig is the settings file given with -config. Every field is
+// optional; one left out keeps its current value, which at startup is the
+// flag's. All of them are safe to change while clients are connected.
+type FileConfig struct {
+	// DefaultConfig replaces -default-config.
+	DefaultConfig *MicConfig `json:"defaultConfig"`
+	// LogLevel replaces -log-level.
+	LogLevel *string `json:"logLevel"`
+	// DeviceDefaults fill the fields a config naming one of these devices
+	// leaves unset.
+	DeviceDefaults map[string]MicConfig `json:"deviceDefaults"`
+}
+
+// configPath is the -config file, re-read on SIGHUP.
+var configPath string
+
+// loadConfigFile reads and checks the settings file at path, rejecting
+// unknown fields so a typo is reported rather than silently ignored.
+func loadConfigFile(path string) (FileConfig, error) {
+	var fc FileConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fc, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return fc, fmt.Errorf("%s: %w", path, err)
+	}
+	if fc.DefaultConfig != nil {
+		if err := AudioConfig(*fc.DefaultConfig).Validate(); err != nil {
+			return fc, fmt.Errorf("%s: defaultConfig: %w", path, err)
+		}
+	}
+	if fc.LogLevel != nil {
+		if _, err := parseLogLevel(*fc.LogLevel); err != nil {
+			return fc, fmt.Errorf("%s: logLevel: %w", path, err)
+		}
+	}
+	if err := validateDeviceDefaults(fc.DeviceDefaults); err != nil {
+		return fc, fmt.Errorf("%s: %w", path, err)
+	}
+	return fc, nil
+}
+
+// applyConfigFile applies the settings in fc and logs each one that
+// changed, prefixed with source. A running session keeps the config it
+// started with. The config the next session starts from follows the new
+// default only while no client has set one with mic-config. Callers hold
+// stateMu.
+func applyConfigFile(fc FileConfig, source string) {
+	if fc.LogLevel != nil {
+		l, _ := parseLogLevel(*fc.LogLevel)
+		if old := logLevel.Level(); l != old {
+			// log the change at whichever of the two levels shows more
+			if l > old {
+				log.Printf("%s: logLevel %v -> %v", source, old, l)
+			}
+			logLevel.Set(l)
+			if l < old {
+				log.Printf("%s: logLevel %v -> %v", source, old, l)
+			}
+		}
+	}
+	if fc.DeviceDefaults != nil && !reflect.DeepEqual(fc.DeviceDefaults, deviceDefaults) {
+		logDeviceDefaultsChange(source, deviceDefaults, fc.DeviceDefaults)
+		deviceDefaults = fc.DeviceDefaults
+	}
+	if fc.DefaultConfig == nil || reflect.DeepEqual(*fc.DefaultConfig, defaultMicConfig) {
+		return
+	}
+	old, _ := json.Marshal(defaultMicConfig)
+	next, _ := json.Marshal(*fc.DefaultConfig)
+	log.Printf("%s: defaultConfig %s -> %s", source, old, next)
+	if reflect.DeepEqual(currentConfig, defaultMicConfig) {
+		currentConfig = *fc.DefaultConfig
+		broadcastState()
+	} else {
+		log.Printf("%s: the next session keeps the config set with mic-config", source)
+	}
+	defaultMicConfig = *fc.DefaultConfig
+}
+
+// reloadOnSIGHUP re-reads configPath every time the daemon gets SIGHUP. A
+// file that fails to load is reported and changes nothing.
+func reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			fc, err := loadConfigFile(configPath)
+			if err != nil {
+				log.Println("Config reload failed, keeping current settings:", err)
+				continue
+			}
+			log.Println("Reloading", configPath)
+			audit("config-reload", 0, "path", configPath)
+			stateMu.Lock()
+			applyConfigFile(fc, "Config reload")
+			stateMu.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the CPU times in /proc/self/stat. It is
+// 100 on every Linux architecture the daemon runs on.
+const clockTicks = 100
+
+// ResourcesPayload is the reply to mic-resources. The process fields come
+// from /proc/self/stat and are left out where it cannot be read.
+type ResourcesPayload struct {
+	RSSBytes      *uint64  `json:"rssBytes,omitempty"`
+	UserSeconds   *float64 `json:"userSeconds,omitempty"`
+	SystemSeconds *float64 `json:"systemSeconds,omitempty"`
+	Goroutines    int      `json:"goroutines"`
+	HeapBytes     uint64   `json:"heapBytes"`
+	GoSysBytes    uint64   `json:"goSysBytes"`
+	UptimeSeconds float64  `json:"uptimeSeconds"`
+	CPUs          int      `json:"cpus"`
+	GCCycles      uint32   `json:"gcCycles"`
+}
+
+// collectResources samples the daemon's current footprint.
+func collectResources() ResourcesPayload {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	r := ResourcesPayload{
+		Goroutines:    runtime.NumGoroutine(),
+		HeapBytes:     mem.HeapAlloc,
+		GoSysBytes:    mem.Sys,
+		UptimeSeconds: time.Since(processStart).Seconds(),
+		CPUs:          runtime.NumCPU(),
+		GCCycles:      mem.NumGC,
+	}
+	if fields := procStat(); fields != nil {
+		// Fields are numbered from 1 in proc(5); fields starts at field 3,
+		// the first after the parenthesized command name.
+		field := func(n int) (uint64, bool) {
+			v, err := strconv.ParseUint(fields[n-3], 10, 64)
+			return v, err == nil
+		}
+		if utime, ok := field(14); ok {
+			v := float64(utime) / clockTicks
+			r.UserSeconds = &v
+		}
+		if stime, ok := field(15); ok {
+			v := float64(stime) / clockTicks
+			r.SystemSeconds = &v
+		}
+		if pages, ok := field(24); ok {
+			v := pages * uint64(os.Getpagesize())
+			r.RSSBytes = &v
+		}
+	}
+	return r
+}
+
+// procStat returns the fields of /proc/self/stat after the command name, or
+// nil when there are too few or the file cannot be read. The command name
+// is skipped by its closing parenthesis since it may contain spaces.
+func procStat() []string {
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return nil
+	}
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return nil
+	}
+	fields := bytes.Fields(data[i+1:])
+	if len(fields) < 24-2 {
+		return nil
+	}
+	out := make([]string, len(fields))
+	for j, f := range fields {
+		out[j] = string(f)
+	}
+	return out
+}
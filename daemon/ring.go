@@ -0,0 +1,53 @@
+package main
+
+import "sync"
+
+// maxRingSeconds bounds the memory a session's ring buffer may hold.
+const maxRingSeconds = 300
+
+// pcmRing keeps the most recent bytes written to it, up to its capacity.
+type pcmRing struct {
+	mu    sync.Mutex
+	buf   []byte
+	start int
+	size  int
+}
+
+// newPCMRing returns a ring holding seconds of audio for cfg, rounded down to
+// whole frames so a snapshot never starts mid-frame.
+func newPCMRing(cfg AudioConfig, seconds float64) *pcmRing {
+	frameSize := cfg.Channels * cfg.BytesPerSample
+	frames := int(float64(cfg.SampleRate) * seconds)
+	if frameSize <= 0 || frames <= 0 {
+		return nil
+	}
+	return &pcmRing{buf: make([]byte, frames*frameSize)}
+}
+
+func (r *pcmRing) Write(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(p) >= len(r.buf) {
+		copy(r.buf, p[len(p)-len(r.buf):])
+		r.start, r.size = 0, len(r.buf)
+		return
+	}
+	end := (r.start + r.size) % len(r.buf)
+	n := copy(r.buf[end:], p)
+	copy(r.buf, p[n:])
+	r.size += len(p)
+	if r.size > len(r.buf) {
+		r.start = (r.start + r.size - len(r.buf)) % len(r.buf)
+		r.size = len(r.buf)
+	}
+}
+
+// Snapshot returns a copy of the buffered bytes, oldest first.
+func (r *pcmRing) Snapshot() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]byte, r.size)
+	n := copy(out, r.buf[r.start:min(r.start+r.size, len(r.buf))])
+	copy(out[n:], r.buf[:r.size-n])
+	return out
+}
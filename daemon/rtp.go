@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// RTP payload encodings mic-rtp can send.
+const (
+	// RTPEncodingL16 is uncompressed 16-bit network order PCM (RFC 3551).
+	RTPEncodingL16 = "L16"
+)
+
+const (
+	// defaultPacketMs is the audio duration of one RTP packet.
+	defaultPacketMs = 20
+	// maxRTPPayload keeps packets under a typical 1500 byte MTU after
+	// IP, UDP and RTP headers.
+	maxRTPPayload = 1400
+	// dynamicPayloadType is the first RTP payload type free for
+	// session-defined encodings.
+	dynamicPayloadType = 96
+)
+
+// RTPOptions is the mic-rtp payload.
+type RTPOptions struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// Encoding is "L16", the only one supported without an external codec.
+	Encoding string `json:"encoding"`
+	// PayloadType defaults to the static type for 44.1 kHz L16, or 96.
+	PayloadType int  `json:"payloadType"`
+	PacketMs    int  `json:"packetMs"`
+	Stop        bool `json:"stop"`
+}
+
+// RTPInfo describes the active RTP output in state.
+type RTPInfo struct {
+	Destination string `json:"destination"`
+	Encoding    string `json:"encoding"`
+	PayloadType int    `json:"payloadType"`
+	PacketMs    int    `json:"packetMs"`
+	SSRC        uint32 `json:"ssrc"`
+	Packets     uint64 `json:"packets"`
+}
+
+// rtpSink packetizes captured audio and sends it over UDP. Sequence numbers
+// and timestamps start at random values as RFC 3550 recommends; the
+// timestamp advances by the frames in each packet.
+type rtpSink struct {
+	conn *net.UDPConn
+	info RTPInfo
+	cfg  AudioConfig
+	// packetBytes is the L16 payload size of one packet.
+	packetBytes int
+	pending     []byte
+	seq         uint16
+	timestamp   uint32
+	marker      bool
+}
+
+var (
+	// rtpMu guards activeRTP, which is fed from the capture goroutine.
+	rtpMu     sync.Mutex
+	activeRTP *rtpSink
+)
+
+// staticPayloadType returns the RFC 3551 static type for L16 in cfg, or 0
+// when there is none.
+func staticPayloadType(cfg AudioConfig) int {
+	if cfg.SampleRate != 44100 {
+		return 0
+	}
+	switch cfg.Channels {
+	case 1:
+		return 11
+	case 2:
+		return 10
+	}
+	return 0
+}
+
+// startRTP begins sending captured audio in cfg to the destination in opts.
+func startRTP(cfg AudioConfig, opts RTPOptions) (*RTPInfo, error) {
+	if opts.Encoding == "" {
+		opts.Encoding = RTPEncodingL16
+	}
+	if opts.Encoding != RTPEncodingL16 {
+		return nil, fmt.Errorf("unsupported RTP encoding %q; only L16 is available", opts.Encoding)
+	}
+	if opts.Host == "" || opts.Port <= 0 || opts.Port > 65535 {
+		return nil, errors.New("rtp host and port are required")
+	}
+	if opts.PayloadType == 0 {
+		opts.PayloadType = staticPayloadType(cfg)
+		if opts.PayloadType == 0 {
+			opts.PayloadType = dynamicPayloadType
+		}
+	}
+	if opts.PayloadType < 0 || opts.PayloadType > 127 {
+		return nil, errors.New("payloadType must be between 0 and 127")
+	}
+	if opts.PacketMs == 0 {
+		opts.PacketMs = defaultPacketMs
+	}
+	frameBytes := cfg.Channels * 2
+	packetFrames := min(cfg.SampleRate*opts.PacketMs/1000, maxRTPPayload/frameBytes)
+	if opts.PacketMs < 0 || packetFrames <= 0 {
+		return nil, errors.New("packetMs must be positive")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port)))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	sink := &rtpSink{
+		conn: conn,
+		info: RTPInfo{
+			Destination: addr.String(),
+			Encoding:    opts.Encoding,
+			PayloadType: opts.PayloadType,
+			PacketMs:    packetFrames * 1000 / cfg.SampleRate,
+			SSRC:        rand.Uint32(),
+		},
+		cfg:         cfg,
+		packetBytes: packetFrames * frameBytes,
+		seq:         uint16(rand.Uint32()),
+		timestamp:   rand.Uint32(),
+		marker:      true,
+	}
+	rtpMu.Lock()
+	defer rtpMu.Unlock()
+	if activeRTP != nil {
+		activeRTP.conn.Close()
+	}
+	activeRTP = sink
+	log.Printf("Streaming RTP %s/%d to %s", opts.Encoding, opts.PayloadType, addr)
+	info := sink.info
+	return &info, nil
+}
+
+// stopRTP ends any RTP output.
+func stopRTP() {
+	rtpMu.Lock()
+	defer rtpMu.Unlock()
+	if activeRTP != nil {
+		activeRTP.conn.Close()
+		activeRTP = nil
+	}
+}
+
+// rtpState returns the active RTP output for state, or nil.
+func rtpState() *RTPInfo {
+	rtpMu.Lock()
+	defer rtpMu.Unlock()
+	if activeRTP == nil {
+		return nil
+	}
+	info := activeRTP.info
+	return &info
+}
+
+// writeRTP packetizes a captured chunk. Output that no longer matches the
+// capture format, e.g. after a device switch, is stopped since the
+// receiver was set up for the old one.
+func writeRTP(cfg AudioConfig, pcm []byte) {
+	rtpMu.Lock()
+	defer rtpMu.Unlock()
+	s := activeRTP
+	if s == nil {
+		return
+	}
+	if cfg.SampleRate != s.cfg.SampleRate || cfg.Channels != s.cfg.Channels {
+		log.Println("Capture format changed; stopping RTP output")
+		s.conn.Close()
+		activeRTP = nil
+		return
+	}
+	for _, v := range decodePCM(pcm, cfg.BytesPerSample) {
+		s.pending = binary.BigEndian.AppendUint16(s.pending, uint16(v))
+	}
+	frameBytes := cfg.Channels * 2
+	for len(s.pending) >= s.packetBytes {
+		packet := make([]byte, 12, 12+s.packetBytes)
+		packet[0] = 2 << 6 // version 2, no padding, extension or CSRCs
+		packet[1] = byte(s.info.PayloadType)
+		if s.marker {
+			// the marker flags the first packet of a talkspurt
+			packet[1] |= 0x80
+			s.marker = false
+		}
+		binary.BigEndian.PutUint16(packet[2:], s.seq)
+		binary.BigEndian.PutUint32(packet[4:], s.timestamp)
+		binary.BigEndian.PutUint32(packet[8:], s.info.SSRC)
+		packet = append(packet, s.pending[:s.packetBytes]...)
+		s.pending = s.pending[s.packetBytes:]
+		s.seq++
+		s.timestamp += uint32(s.packetBytes / frameBytes)
+		if _, err := s.conn.Write(packet); err != nil {
+			rtpErrorLog.Printf("RTP send error: %v", err)
+			continue
+		}
+		s.info.Packets++
+	}
+}
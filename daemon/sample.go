@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// maxSampleSeconds caps GET /sample.wav so a request cannot hold the device
+// indefinitely.
+const maxSampleSeconds = 10
+
+// sampleInProgress is set while /sample.wav owns the capture device. Guarded
+// by stateMu.
+var sampleInProgress bool
+
+// handleSample captures a short clip and returns it as a finalized WAV file.
+// Query parameters seconds, rate, channels and device override the current
+// config for this clip only. The clip is checked and laid out as a session
+// would be, so source channel and output channel options apply too.
+func handleSample(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	seconds := 3.0
+	if v := q.Get("seconds"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			http.Error(w, "invalid seconds", http.StatusBadRequest)
+			return
+		}
+		seconds = min(f, maxSampleSeconds)
+	}
+
+	stateMu.Lock()
+	cfg := currentConfig
+	busy := audioSession != nil || sampleInProgress
+	if !busy {
+		sampleInProgress = true
+	}
+	stateMu.Unlock()
+	if busy {
+		http.Error(w, "capture device busy", http.StatusConflict)
+		return
+	}
+	defer func() {
+		stateMu.Lock()
+		sampleInProgress = false
+		stateMu.Unlock()
+	}()
+
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"rate", &cfg.SampleRate}, {"channels", &cfg.Channels}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid "+p.name, http.StatusBadRequest)
+				return
+			}
+			*p.dst = n
+		}
+	}
+	if v := q.Get("device"); v != "" {
+		cfg.Device = v
+	}
+	acfg := AudioConfig(cfg)
+	if err := acfg.validateStart(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if acfg.SampleRate == NativeSampleRate {
+		rate, err := probeNativeRate(acfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		acfg.SampleRate = rate
+	}
+	capture := acfg.captureLayout()
+	acfg = capture.delivered()
+
+	frames := int(float64(capture.SampleRate) * seconds)
+	args, env := arecordArgs(capture)
+	args = append(args, "-s", strconv.Itoa(frames))
+	cmd := exec.CommandContext(r.Context(), "arecord", args...)
+	cmd.Env = env
+	cmd.WaitDelay = time.Second
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		err = captureError(err, "")
+		log.Println("Sample capture error:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pcm, readErr := io.ReadAll(stdout)
+	if err := cmd.Wait(); err != nil || readErr != nil {
+		log.Println("Sample capture error:", err, readErr)
+		http.Error(w, "capture failed", http.StatusInternalServerError)
+		return
+	}
+
+	frame := capture.Channels * capture.BytesPerSample
+	pcm = toDelivered(capture, acfg, pcm[:len(pcm)/frame*frame])
+	wav := wavChunk(pcm, acfg.SampleRate, acfg.Channels, acfg.BytesPerSample)
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Content-Length", strconv.Itoa(len(wav)))
+	w.Header().Set("Content-Disposition", `inline; filename="sample.wav"`)
+	w.Write(wav)
+}
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 )
@@ -12,6 +22,11 @@ type Command struct {
 	Type    string          `json:"type"`
 	Request string          `json:"request"`
 	Payload json.RawMessage `json:"payload,omitempty"`
+	// Token authorizes admin requests.
+	Token string `json:"token,omitempty"`
+	// ID, when set, is echoed on every direct response to the command so
+	// clients can match replies to requests.
+	ID json.RawMessage `json:"id,omitempty"`
 }
 
 type MicConfig struct {
@@ -19,174 +34,1350 @@ type MicConfig struct {
 	Channels        int     `json:"channels"`
 	BytesPerSample  int     `json:"bytesPerSample"`
 	SecondsPerChunk float64 `json:"secondsPerChunk"`
+	PeriodFrames    int     `json:"periodFrames,omitempty"`
+	BufferFrames    int     `json:"bufferFrames,omitempty"`
+	Device          string  `json:"device,omitempty"`
+	UsePlug         bool    `json:"usePlug,omitempty"`
+	RingSeconds     float64 `json:"ringSeconds,omitempty"`
+
+	NoiseGate *NoiseGateConfig `json:"noiseGate,omitempty"`
+	// Preset names the preset the config was expanded from.
+	Preset string `json:"preset,omitempty"`
+	// Devices are fallbacks tried in order after Device.
+	Devices []string `json:"devices,omitempty"`
+	// SourceChannel picks one channel of the capture to deliver as mono.
+	SourceChannel *int `json:"sourceChannel,omitempty"`
+	// FadeMs fades the session in and out over this long, shaped by
+	// FadeShape.
+	FadeMs    int    `json:"fadeMs,omitempty"`
+	FadeShape string `json:"fadeShape,omitempty"`
+	// CaptureChannels and OutputChannels override Channels for arecord
+	// and for delivery respectively.
+	CaptureChannels int `json:"captureChannels,omitempty"`
+	OutputChannels  int `json:"outputChannels,omitempty"`
+	// Downmix is the stereo to mono strategy: average, left, right or max.
+	Downmix string `json:"downmix,omitempty"`
+	// SampleFormat (s16le, s24le or s32le) sets BytesPerSample.
+	SampleFormat SampleFormat `json:"sampleFormat,omitempty"`
+}
+
+// isEmpty reports whether no field of c is set.
+func (c MicConfig) isEmpty() bool {
+	return reflect.ValueOf(c).IsZero()
 }
 
 type StatePayload struct {
-	State  string    `json:"state"` // "listening", "idle", "error"
-	Config MicConfig `json:"config"`
-	Error  string    `json:"error,omitempty"`
+	// StateVersion grows by one with every change to the shared state.
+	StateVersion uint64    `json:"stateVersion"`
+	State        MicState  `json:"state"`
+	Config       MicConfig `json:"config"`
+	Error        string    `json:"error,omitempty"`
+	// ErrorCode identifies Error; see the ErrorCode constants.
+	ErrorCode ErrorCode `json:"errorCode,omitempty"`
+	// Dropped counts frames discarded for the receiving connection.
+	Dropped uint64 `json:"dropped,omitempty"`
+	// Listeners are the connections that have sent mic-listen.
+	Listeners []Listener `json:"listeners,omitempty"`
+	// ConnectionConfig is the receiving connection's own delivery config,
+	// if it sent one with mic-listen. Config stays the global default.
+	ConnectionConfig *MicConfig `json:"connectionConfig,omitempty"`
+	// EffectiveConfig is the config capture is running with, e.g. with a
+	// "native" sample rate resolved. Present while listening.
+	EffectiveConfig *MicConfig `json:"effectiveConfig,omitempty"`
+	// Latency is present while listening with -measure-latency.
+	Latency *LatencyStats `json:"latency,omitempty"`
+	// Recording is the file being recorded to, if any.
+	Recording string `json:"recording,omitempty"`
+	// Owner is the connection that started the session; only it can stop
+	// the session without force. IsOwner is set for that connection.
+	Owner   uint64 `json:"owner,omitempty"`
+	IsOwner bool   `json:"isOwner,omitempty"`
+	// Volume is the hardware capture level in percent, once known.
+	Volume *int `json:"volume,omitempty"`
+	// RTP is the active RTP output, if any.
+	RTP *RTPInfo `json:"rtp,omitempty"`
+	// Mix is the file being mixed into capture, if any.
+	Mix *MixInfo `json:"mix,omitempty"`
+	// ChunkBytes is the PCM size of each audio chunk delivered to the
+	// receiving connection. Present while listening.
+	ChunkBytes int `json:"chunkBytes,omitempty"`
+	// Playback names the WAV file streamed in place of the microphone.
+	Playback string `json:"playback,omitempty"`
+	// FrameMs is how often the receiving connection gets an audio frame,
+	// after coalescing. Present while listening.
+	FrameMs float64 `json:"frameMs,omitempty"`
+	// PowerMode is the receiving connection's power mode, full or low,
+	// when it listens with powerSave.
+	PowerMode string `json:"powerMode,omitempty"`
+}
+
+// defaultMicConfig is used until a client sends its own config.
+var defaultMicConfig = MicConfig{
+	SampleRate:      16000,
+	Channels:        1,
+	BytesPerSample:  2,
+	SecondsPerChunk: 1,
 }
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: []string{subprotocolV2, subprotocolLegacy},
 }
 
-func StartWebSocketServer() {
-	http.HandleFunc("/", handleWebSocket)
-	log.Println("WebSocket server listening on :8890")
-	if err := http.ListenAndServe(":8890", nil); err != nil {
-		log.Fatal("ListenAndServe error:", err)
+// ServerOptions controls how StartWebSocketServer listens.
+type ServerOptions struct {
+	Addr string
+	// Network is "tcp", "tcp4" or "tcp6"; see listenNetworks.
+	Network string
+	// TLSCert and TLSKey enable wss:// when both are set.
+	TLSCert string
+	TLSKey  string
+	// SelfSigned enables wss:// with a generated certificate.
+	SelfSigned bool
+	// AdminToken enables admin requests guarded by this token.
+	AdminToken string
+	// UnixSocket, when set, also serves the same endpoints on a Unix
+	// domain socket at this path.
+	UnixSocket string
+}
+
+// StartWebSocketServer serves the websocket endpoint and blocks until the
+// listener fails.
+func StartWebSocketServer(opts ServerOptions) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleWebSocket)
+	mux.HandleFunc("/sample.wav", handleSample)
+	mux.HandleFunc("/hls/", handleHLS)
+	mux.HandleFunc("/events", handleEvents)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/webrtc/offer", handleWebRTCOffer)
+	mux.HandleFunc("/admin/reset", requireAdmin(http.MethodPost, handleAdminReset))
+	mux.HandleFunc("/admin/disconnect-all", requireAdmin(http.MethodPost, handleAdminDisconnectAll))
+	mux.HandleFunc("/admin/loglevel", requireAdmin(http.MethodPost, handleAdminLogLevel))
+	mux.HandleFunc("/debug/config", requireAdmin(http.MethodGet, handleDebugConfig))
+	adminToken = opts.AdminToken
+	serverAddr = opts.Addr
+	srv := &http.Server{Addr: opts.Addr, Handler: mux}
+	if opts.UnixSocket != "" {
+		if err := listenUnix(opts.UnixSocket, mux); err != nil {
+			return fmt.Errorf("unix socket: %w", err)
+		}
+	}
+
+	network := opts.Network
+	if network == "" {
+		network = "tcp"
+	}
+	serve, transport := srv.Serve, ""
+	switch {
+	case opts.TLSCert != "" && opts.TLSKey != "":
+		serve = func(ln net.Listener) error { return srv.ServeTLS(ln, opts.TLSCert, opts.TLSKey) }
+		transport = " (wss)"
+	case opts.SelfSigned:
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		serve = func(ln net.Listener) error { return srv.ServeTLS(ln, "", "") }
+		transport = " (wss, self-signed)"
+	default:
+		if !isLoopbackAddr(opts.Addr) {
+			slog.Warn("Serving plaintext ws on a non-loopback address; audio is unencrypted on the network")
+		}
 	}
+	lns, err := listenTCP(network, opts.Addr)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		log.Printf("WebSocket server listening on %s%s", ln.Addr(), transport)
+		go func() { errs <- serve(ln) }()
+	}
+	return <-errs
 }
 
 var (
 	audioSession  *AudioSession
-	currentConfig MicConfig
-	micState      = "idle" // "listening", "idle", "error"
+	currentConfig = defaultMicConfig
+	// sessionConfig is the config the running session was started with.
+	sessionConfig MicConfig
+	micState      = StateIdle
 	micError      = ""
-	wsConnections = make(map[*websocket.Conn]struct{})
+	micErrorCode  ErrorCode
+	nextConnID    uint64
+
+	// sessionOwner is the connection that started capture, or 0 when
+	// nobody owns it, e.g. with -autostart or after the owner left.
+	sessionOwner uint64
+
+	// stateMu guards the mic state above. Handlers hold it for the duration
+	// of a command so state changes and their broadcasts stay ordered.
+	stateMu sync.Mutex
+
+	clientsMu sync.Mutex
+	clients   = make(map[*client]struct{})
 )
 
+// statePayload builds the state as seen by c.
+func statePayload(c *client) StatePayload {
+	p := sharedStatePayload()
+	p.Dropped = c.droppedCount()
+	p.ConnectionConfig = c.connectionConfig()
+	p.PowerMode = c.powerMode()
+	if audioSession != nil {
+		p.ChunkBytes = c.chunkSize(audioSession.Config())
+		p.FrameMs = c.frameInterval(audioSession.Config())
+		p.IsOwner = sessionOwner != 0 && sessionOwner == c.id
+	}
+	return p
+}
+
+// sharedStatePayload is the part of state that is the same for every
+// receiver. Callers hold stateMu.
+func sharedStatePayload() StatePayload {
+	p := StatePayload{
+		StateVersion: stateVersion,
+		State:        micState,
+		Config:       currentConfig,
+		Error:        micError,
+		ErrorCode:    micErrorCode,
+		Listeners:    listeners,
+		Recording:    recordingPath(),
+		Volume:       captureVolume,
+		Mix:          mixState(),
+		RTP:          rtpState(),
+	}
+	if audioSession != nil {
+		effective := MicConfig(audioSession.Config())
+		p.EffectiveConfig = &effective
+		p.Latency = audioSession.Latency()
+		p.Owner = sessionOwner
+		p.Playback = audioSession.Playback()
+	}
+	return p
+}
+
+func sendState(c *client) {
+	c.sendMessage("state", "mic", statePayload(c))
+}
+
 func broadcastState() {
-	stateMsg := map[string]interface{}{
-		"type":    "state",
-		"request": "mic",
-		"payload": StatePayload{
-			State:  micState,
-			Config: currentConfig,
-			Error:  micError,
-		},
+	refreshListeners()
+	bumpStateVersion()
+	recordTransition()
+	sendSSEState()
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for c := range clients {
+		if c.subscribed(SubState) {
+			sendState(c)
+		}
 	}
-	msg, _ := json.Marshal(stateMsg)
-	for conn := range wsConnections {
-		conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// startSession starts capture with cfg and broadcasts the result. Callers
+// hold stateMu.
+func startSession(cfg MicConfig) {
+	if sampleInProgress {
+		setMicError(ErrDeviceBusy, "Capture busy")
+		broadcastState()
+		return
+	}
+	// a fresh capture is a new logical session
+	endSessionToken()
+	var err error
+	audioSession, cfg, err = openCapture(cfg)
+	if err != nil {
+		log.Println("Audio start error:", err)
+		audioSession = nil
+		setMicError(errorCodeOf(err), "Audio start error: "+err.Error())
+	} else {
+		sessionConfig = cfg
+		sessionsStarted.Add(1)
+		setMicState(StateListening)
+		go watchSession(audioSession)
 	}
+	broadcastState()
+}
+
+// stopSession ends capture along with everything tied to the session.
+// Callers hold stateMu.
+func stopSession() {
+	audioSession.Stop()
+	audioSession = nil
+	endSession()
+}
+
+// endSession clears the token, recording and owner of a session that has
+// stopped. Callers hold stateMu.
+func endSession() {
+	endSessionToken()
+	endRecording()
+	sessionOwner = 0
+	stopMix()
+	stopRTP()
+	endHLS()
+	finishStreams()
+}
+
+// finishStreams sends the final frames of encoders that must be finalized
+// when capture stops.
+func finishStreams() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for c := range clients {
+		if tail := c.finishStream(); tail.data != nil {
+			c.sendAudio(tail)
+		}
+	}
+}
+
+// canStop reports whether c may stop the running session: its owner, any
+// connection when it is unowned, or one that forces the stop.
+func canStop(c *client, force bool) bool {
+	return sessionOwner == 0 || sessionOwner == c.id || force
+}
+
+// autoPlug retries a hw: device through plughw: when it rejects the
+// requested format, letting ALSA convert instead of failing.
+var autoPlug bool
+
+// openCapture starts capture with cfg. When the device rejects the format it
+// retries through plughw: if autoPlug is set, and otherwise reports what
+// the device does support. It returns the config capture started with.
+func openCapture(cfg MicConfig) (*AudioSession, MicConfig, error) {
+	session, err := StartAudioStream(AudioConfig(cfg), broadcastAudio)
+	if err == nil || errorCodeOf(err) != ErrFormatUnsupported {
+		return session, cfg, err
+	}
+	device := AudioConfig(cfg).ResolvedDevice()
+	if autoPlug && strings.HasPrefix(device, "hw:") {
+		log.Printf("%s rejected the format; retrying through plughw", device)
+		plug := cfg
+		plug.UsePlug = true
+		if session, plugErr := StartAudioStream(AudioConfig(plug), broadcastAudio); plugErr == nil {
+			return session, plug, nil
+		}
+	}
+	if supported := describeSupported(AudioConfig(cfg)); supported != "" {
+		err = &CaptureError{
+			Code: ErrFormatUnsupported,
+			Err:  fmt.Errorf("%s does not support %d Hz, %d channel(s), %d-bit; it supports %s", device, cfg.SampleRate, cfg.Channels, cfg.BytesPerSample*8, supported),
+		}
+	}
+	return nil, cfg, err
+}
+
+// watchSession reports capture that ends without a mic-stop.
+func watchSession(session *AudioSession) {
+	<-session.Done()
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if audioSession != session {
+		return
+	}
+	audioSession = nil
+	endSession()
+	if err := session.Err(); err != nil {
+		sessionErrors.Add(1)
+		log.Println("Audio session ended:", err)
+		setMicError(errorCodeOf(err), "Recorder exited: "+err.Error())
+	} else {
+		setMicState(StateIdle)
+	}
+	broadcastState()
+}
+
+// switchDevice moves capture to device without disconnecting anyone. If the
+// new device fails to open, capture resumes on the previous one and c is told
+// why. Callers hold stateMu.
+func switchDevice(c *client, device string) {
+	if audioSession == nil {
+		next := currentConfig
+		next.Device = device
+		if err := AudioConfig(next).Validate(); err != nil {
+			sendError(c, "mic-switch-device", "Invalid device: "+err.Error())
+			return
+		}
+		currentConfig = next
+		broadcastState()
+		return
+	}
+
+	next := sessionConfig
+	next.Device = device
+	if err := AudioConfig(next).Validate(); err != nil {
+		sendError(c, "mic-switch-device", "Invalid device: "+err.Error())
+		return
+	}
+	if err := restartCapture(next); err != nil {
+		log.Println("Device switch error:", err)
+		sendError(c, "mic-switch-device", "Could not open "+device+": "+err.Error())
+	}
+}
+
+// restartCapture stops the running session and starts next in its place. If
+// next fails to open, capture resumes with the previous config and the error
+// is returned. Callers hold stateMu.
+func restartCapture(next MicConfig) error {
+	previous := sessionConfig
+	setMicState(StateSwitching)
+	broadcastState()
+	audioSession.Stop()
+	audioSession = nil
+
+	session, next, err := openCapture(next)
+	if err == nil {
+		sessionConfig = next
+		audioSession = session
+		setMicState(StateListening)
+		go watchSession(session)
+		broadcastState()
+		return nil
+	}
+	startSession(previous)
+	if audioSession != nil {
+		log.Println("Resumed capture on", AudioConfig(previous).ResolvedDevice())
+	}
+	return err
+}
+
+// broadcastAudio fans a PCM chunk out to every connection receiving audio,
+// encoding it separately for each in the format it asked for.
+func broadcastAudio(cfg AudioConfig, pcm []byte) {
+	pcm, mixEnded := applyMix(cfg, pcm)
+	if mixEnded {
+		// capture must not wait on stateMu, which handlers hold while
+		// stopping it
+		go func() {
+			stateMu.Lock()
+			broadcastState()
+			stateMu.Unlock()
+		}()
+	}
+	writeRecording(cfg, pcm)
+	writeRTP(cfg, pcm)
+	writeHLS(cfg, pcm)
+	writeSSE(cfg, pcm)
+	var level *Level
+	if _, ok := sampleFormats[cfg.BytesPerSample]; levelMeter && ok {
+		l := measureLevel(pcm, cfg.Channels, cfg.BytesPerSample)
+		level = &l
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for c := range clients {
+		if level != nil && c.subscribed(SubLevel) {
+			c.sendEvent("level", level)
+		}
+		if !c.isStreaming() {
+			continue
+		}
+		for _, piece := range c.gate(cfg, pcm) {
+			var frames []audioFrame
+			var err error
+			switch piece.event {
+			case "":
+				frames, err = c.deliverPowered(cfg, piece.pcm)
+			case SegmentStart:
+				if c.subscribed(SubVAD) {
+					c.sendEvent("vad", VADEvent{Event: piece.event})
+				}
+			case SegmentEnd:
+				frames, err = c.drain()
+			}
+			if err != nil {
+				encodeErrorLog.Printf("Client %s encode error: %v", c, err)
+			}
+			for _, frame := range frames {
+				c.sendAudio(frame)
+			}
+			if piece.event == SegmentEnd && c.subscribed(SubVAD) {
+				c.sendEvent("vad", VADEvent{Event: piece.event})
+			}
+		}
+	}
+}
+
+// listenOptions are per-connection settings carried alongside the mic config
+// in a mic-listen payload.
+type listenOptions struct {
+	DropPolicy string `json:"dropPolicy"`
+	// Format selects how audio is encoded for this connection.
+	Format string `json:"format"`
+	// Stream set to false keeps capture running without delivering audio to
+	// this connection, e.g. to only fill the ring buffer for mic-dump.
+	Stream *bool `json:"stream"`
+	// SessionToken resumes the session a previous connection was given.
+	SessionToken string `json:"sessionToken"`
+	// CoalesceMs groups small chunks into frames of at least this many
+	// milliseconds, trading latency for less per-frame overhead.
+	CoalesceMs *int `json:"coalesceMs"`
+	// Trigger set to "vad" delivers audio only around detected speech;
+	// VAD tunes the detector.
+	Trigger *string         `json:"trigger"`
+	VAD     json.RawMessage `json:"vad"`
+	// ClipSeconds delivers complete WAV files of exactly that length
+	// instead of a stream; Repeat keeps delivering them after the first.
+	ClipSeconds *float64 `json:"clipSeconds"`
+	Repeat      bool     `json:"repeat"`
+	// IdleSilence sends silence frames whenever capture delivers nothing,
+	// so the client's playback buffer never drains; FrameHeader prefixes
+	// audio frames with a header whose flags mark them.
+	IdleSilence *bool `json:"idleSilence"`
+	FrameHeader *bool `json:"frameHeader"`
+	// FrameClock picks the header timestamp: unix, monotonic or samples.
+	FrameClock string `json:"frameClock"`
+	// PlaybackFile streams a WAV file from -playback-dir instead of the
+	// microphone, restarting at its end with Loop.
+	PlaybackFile string `json:"playbackFile"`
+	Loop         bool   `json:"loop"`
+	// AudioMeta precedes each audio frame with an audio-meta text message.
+	AudioMeta *bool `json:"audioMeta"`
+	// Checksum adds a CRC32 of each audio payload to the frame header and
+	// audio-meta message.
+	Checksum *bool `json:"checksum"`
+	// WAVSize picks what WAV header size fields hold: per-chunk,
+	// streaming-sentinel or finalized.
+	WAVSize string `json:"wavSize"`
+	// Label names the connection in logs, stats and the listener list.
+	Label *string `json:"label"`
+	// PowerSave withholds audio during silence, sending waveform updates
+	// until speech returns: true, false or PowerSaveOptions.
+	PowerSave json.RawMessage `json:"powerSave"`
+}
+
+// listenRequest is a mic-listen payload decoded and checked as far as it can
+// be without the connection or shared state.
+type listenRequest struct {
+	opts   listenOptions
+	cfg    MicConfig
+	policy DropPolicy
+	clock  FrameClock
+	vad    *VADOptions
+	power  *PowerSaveOptions
+}
+
+// errInvalidConfig marks a parseListen error in the mic config rather than
+// the connection's options; it is reported as the shared INVALID_CONFIG
+// state.
+var errInvalidConfig = errors.New("invalid config")
+
+// parseListen decodes a mic-listen payload and checks every option in it,
+// applying none of them.
+func parseListen(payload json.RawMessage) (listenRequest, error) {
+	var req listenRequest
+	if len(payload) == 0 {
+		return req, nil
+	}
+	if err := json.Unmarshal(payload, &req.cfg); err != nil {
+		return req, errInvalidConfig
+	}
+	opts := &req.opts
+	if err := json.Unmarshal(payload, opts); err != nil {
+		return req, fmt.Errorf("Invalid listen options: %v", err)
+	}
+	var err error
+	if opts.DropPolicy != "" {
+		if req.policy, err = parseDropPolicy(opts.DropPolicy); err != nil {
+			return req, err
+		}
+	}
+	if opts.CoalesceMs != nil && (*opts.CoalesceMs < 0 || *opts.CoalesceMs > maxCoalesceMs) {
+		return req, fmt.Errorf("coalesceMs must be between 0 and %d", maxCoalesceMs)
+	}
+	if opts.ClipSeconds != nil && (*opts.ClipSeconds < 0 || *opts.ClipSeconds > maxClipSeconds) {
+		return req, fmt.Errorf("clipSeconds must be between 0 and %d", maxClipSeconds)
+	}
+	if opts.FrameHeader != nil {
+		if req.clock, err = parseFrameClock(opts.FrameClock); err != nil {
+			return req, err
+		}
+	}
+	if opts.Trigger != nil {
+		switch *opts.Trigger {
+		case "":
+		case TriggerVAD:
+			vad := defaultVADOptions
+			if len(opts.VAD) > 0 {
+				if err := json.Unmarshal(opts.VAD, &vad); err != nil {
+					return req, fmt.Errorf("Invalid vad options: %v", err)
+				}
+			}
+			if err := vad.validate(); err != nil {
+				return req, err
+			}
+			req.vad = &vad
+		default:
+			return req, errors.New("Unknown trigger " + *opts.Trigger)
+		}
+	}
+	if len(opts.PowerSave) > 0 {
+		if req.power, err = parsePowerSave(opts.PowerSave); err != nil {
+			return req, err
+		}
+	}
+	if opts.Format != "" && !validFormat(opts.Format) {
+		return req, errors.New("Unknown format " + opts.Format)
+	}
+	return req, nil
+}
+
+// listen carries out a mic-listen request from parseListen. It first runs
+// the checks that need the connection or shared state, then applies the
+// connection's options and starts or joins capture, so nothing is applied
+// when any part is refused. Callers hold stateMu.
+func listen(c *client, request string, req listenRequest) {
+	opts, cfg := req.opts, req.cfg
+	var err error
+
+	// checks that need the connection, the machine or shared state
+	if opts.Format != "" {
+		if err := formatAvailable(opts.Format); err != nil {
+			sendFormatUnavailable(c, request, err)
+			return
+		}
+	}
+	var wavSize WAVSizePolicy
+	if opts.WAVSize != "" {
+		format, clip := c.outputMode()
+		if opts.Format != "" {
+			format = opts.Format
+		}
+		if opts.ClipSeconds != nil {
+			clip = *opts.ClipSeconds > 0
+		}
+		if wavSize, err = parseWAVSize(opts.WAVSize, format, clip); err != nil {
+			sendError(c, request, err.Error())
+			return
+		}
+	}
+	if !cfg.isEmpty() {
+		cfg = withDeviceDefaults(cfg)
+		if err := AudioConfig(cfg).Validate(); err != nil {
+			log.Println("Invalid config:", err)
+			setMicError(ErrInvalidConfig, "Invalid config: "+err.Error())
+			broadcastState()
+			return
+		}
+	}
+	var playback string
+	if opts.PlaybackFile != "" {
+		if playback, err = playbackPath(opts.PlaybackFile); err != nil {
+			sendError(c, request, err.Error())
+			return
+		}
+		if audioSession != nil {
+			sendError(c, request, "Already capturing; send mic-stop before playing a file")
+			return
+		}
+	}
+	// capture starts in the requester's config when it sent one
+	start := currentConfig
+	if !cfg.isEmpty() {
+		start = cfg
+	} else if c.initialConfig != nil && playback == "" {
+		start = *c.initialConfig
+	}
+	if audioSession == nil && playback == "" {
+		// never launch arecord with a config that cannot work,
+		// e.g. one that was never set
+		if err := AudioConfig(start).validateStart(); err != nil {
+			log.Println("Refusing to start:", err)
+			setMicError(ErrInvalidConfig, "Cannot start capture: "+err.Error())
+			broadcastState()
+			return
+		}
+	}
+
+	relabelled := applyListenOptions(c, req, wavSize)
+	if !cfg.isEmpty() {
+		// the config applies to this connection only; the global
+		// config is changed through mic-config
+		c.setConfig(&cfg)
+	}
+
+	// listed before capture starts so the state it broadcasts includes
+	// this connection
+	joined := !c.listener
+	c.listener = true
+
+	// if the mic is not already listening, start it
+	if audioSession == nil {
+		sessionOwner = c.id
+		if playback != "" {
+			startPlaybackSession(start, playback, opts.Loop)
+		} else {
+			startSession(start)
+		}
+	} else if joined || relabelled {
+		// already listening; the listener list changed
+		broadcastState()
+	} else {
+		// already listening; this connection's audio is converted
+		sendState(c)
+	}
+	if audioSession == nil {
+		c.listener = !joined
+	} else {
+		cancelExpiry()
+		issueSessionToken()
+		sendSessionInfo(c, opts.SessionToken)
+	}
+}
+
+// applyListenOptions sets the connection options of a checked mic-listen
+// request and reports whether the connection's label changed.
+func applyListenOptions(c *client, req listenRequest, wavSize WAVSizePolicy) bool {
+	opts := req.opts
+	if opts.DropPolicy != "" {
+		c.setPolicy(req.policy)
+	}
+	relabelled := false
+	if opts.Label != nil {
+		if label := sanitizeLabel(*opts.Label); label != c.label() {
+			c.setLabel(label)
+			relabelled = true
+			audit("label", c.id, "remote", c.remote, "label", label)
+		}
+	}
+	if opts.Stream != nil {
+		c.setStreaming(*opts.Stream)
+	}
+	if opts.CoalesceMs != nil {
+		c.setCoalesce(*opts.CoalesceMs)
+	}
+	if opts.ClipSeconds != nil {
+		c.setClip(*opts.ClipSeconds, opts.Repeat)
+	}
+	if opts.FrameHeader != nil {
+		c.setFramed(*opts.FrameHeader, req.clock)
+	}
+	if opts.AudioMeta != nil {
+		c.setAudioMeta(*opts.AudioMeta)
+	}
+	if opts.Checksum != nil {
+		c.setChecksum(*opts.Checksum)
+	}
+	if opts.IdleSilence != nil {
+		c.setIdleSilence(*opts.IdleSilence, AudioConfig(currentConfig))
+	}
+	if opts.Trigger != nil {
+		if req.vad != nil {
+			c.setTrigger(newVADTrigger(*req.vad))
+		} else {
+			c.setTrigger(nil)
+		}
+	}
+	if len(opts.PowerSave) > 0 {
+		c.setPowerSave(req.power)
+	}
+	if opts.Format != "" {
+		c.setFormat(opts.Format)
+	}
+	if opts.WAVSize != "" {
+		c.setWAVSize(wavSize)
+	} else if opts.Format != "" || opts.ClipSeconds != nil {
+		// a policy chosen for another output mode may not fit
+		c.setWAVSize("")
+	}
+	return relabelled
+}
+
+// maxCommandBytes bounds a client's text frame.
+const maxCommandBytes = 64 << 10
+
+// sendError reports a failed request to c alone, leaving the shared mic
+// state untouched.
+func sendError(c *client, request, message string) {
+	c.sendMessage("error", request, ErrorPayload{Error: message})
 }
 
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	policy, err := parseDropPolicy(r.URL.Query().Get("dropPolicy"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := selectProtocol(r, ""); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" {
+		if !validFormat(format) {
+			http.Error(w, "unknown format "+format, http.StatusBadRequest)
+			return
+		}
+		if err := formatAvailable(format); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v; available formats: %s", ErrFormatUnavailable, err, strings.Join(availableFormats(), ", ")), http.StatusBadRequest)
+			return
+		}
+	}
+	idle, err := parseIdleTimeout(r.URL.Query().Get("idleTimeout"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stateMu.Lock()
+	initial, err := queryConfig(r.URL.Query(), currentConfig)
+	stateMu.Unlock()
+	if err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Upgrade error:", err)
 		return
 	}
-	wsConnections[conn] = struct{}{}
+	protocol, _ := selectProtocol(r, conn.Subprotocol())
+	replay, _ := strconv.Atoi(r.URL.Query().Get("replay"))
+	connID := atomic.AddUint64(&nextConnID, 1)
+	connectionsTotal.Add(1)
+	audit("connect", connID,
+		"remote", r.RemoteAddr,
+		"origin", r.Header.Get("Origin"),
+		"userAgent", r.UserAgent(),
+	)
+	offered := r.Header.Get("Sec-WebSocket-Extensions")
+	extensions := negotiatedExtensions(&upgrader, offered)
+	log.Printf("Client %d connected from %s: subprotocol %q, extensions offered %q, negotiated %v",
+		connID, r.RemoteAddr, conn.Subprotocol(), offered, extensions)
+	// commands are small JSON objects; anything larger closes the
+	// connection with 1009
+	conn.SetReadLimit(maxCommandBytes)
+	c := newClient(connID, conn, protocol)
+	c.setHandshake(conn.Subprotocol(), offered, extensions)
+	c.setPolicy(policy)
+	c.armIdle(idle)
+	if format != "" {
+		c.setFormat(format)
+	}
+	if initial != nil {
+		c.setConfig(initial)
+		c.initialConfig = initial
+	}
+	clientsMu.Lock()
+	clients[c] = struct{}{}
+	clientsMu.Unlock()
 	defer func() {
-		delete(wsConnections, conn)
-		conn.Close()
+		stateMu.Lock()
+		scheduleExpiry(c)
+		if sessionOwner == connID {
+			// anyone may stop the session once its owner is gone
+			sessionOwner = 0
+		}
+		stateMu.Unlock()
+		clientsMu.Lock()
+		delete(clients, c)
+		clientsMu.Unlock()
+		c.close()
+		countDeparted(c)
+		audit("disconnect", connID, "remote", r.RemoteAddr, "label", c.label())
+		stateMu.Lock()
+		if c.listener {
+			// the listener list changed
+			broadcastState()
+		}
+		stateMu.Unlock()
 	}()
 
-	// Send initial state to new connection
-	stateMsg := map[string]interface{}{
-		"type":    "state",
-		"request": "mic",
-		"payload": StatePayload{
-			State:  micState,
-			Config: currentConfig,
-			Error:  micError,
-		},
+	// Send initial state to new connection, preceded by recent transitions
+	// when a reconnecting client asks for them
+	stateMu.Lock()
+	if replay > 0 {
+		c.sendMessage("history", "mic", HistoryPayload{Transitions: recentTransitions(replay)})
 	}
-	msg, _ := json.Marshal(stateMsg)
-	conn.WriteMessage(websocket.TextMessage, msg)
+	refreshListeners()
+	sendState(c)
+	stateMu.Unlock()
 
 	for {
 		mt, msg, err := conn.ReadMessage()
+		if err != nil && c.closed() {
+			// closed from this side, e.g. by disconnectAll
+			break
+		}
+		if err != nil && isIdleTimeout(err) {
+			log.Printf("Client %s idle for %v, closing", c, c.idle)
+			c.closeWith(websocket.CloseGoingAway, "idle timeout")
+			break
+		}
+		c.touch()
 		if err != nil {
 			log.Println("WebSocket read error:", err)
-			micState = "error"
-			micError = "WebSocket read error"
+			stateMu.Lock()
+			setMicError(ErrConnection, "WebSocket read error")
 			broadcastState()
+			stateMu.Unlock()
 			break
 		}
-		if mt == websocket.TextMessage {
-			var cmd Command
-			if err := json.Unmarshal(msg, &cmd); err != nil {
-				log.Println("Invalid command:", err)
-				micState = "error"
-				micError = "Invalid command"
+		if mt != websocket.TextMessage {
+			log.Printf("Client %s sent a %d byte binary frame; rejected", c, len(msg))
+			sendError(c, "", "Binary frames are not accepted; this daemon only receives JSON text commands")
+			continue
+		}
+		handleMessage(c, msg)
+	}
+}
+
+// handleMessage processes one text command from c.
+func handleMessage(c *client, msg []byte) {
+	cmd, err := parseCommand(msg)
+	if err == nil {
+		warmHWParams(cmd)
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if err != nil {
+		log.Println("Invalid command:", err)
+		setMicError(ErrProtocol, "Invalid command")
+		broadcastState()
+		return
+	}
+	slog.Debug("Command", "conn", c.id, "type", cmd.Type, "request", cmd.Request)
+	runCommand(c, cmd)
+}
+
+// parseCommand decodes one client text frame.
+func parseCommand(msg []byte) (Command, error) {
+	var cmd Command
+	if err := json.Unmarshal(msg, &cmd); err != nil {
+		return Command{}, err
+	}
+	if cmd.Type == "" {
+		return Command{}, errors.New("missing type")
+	}
+	return cmd, nil
+}
+
+// response is what handleCommand decides for a command: either an error
+// for the requester, or the effect that carries the command out.
+type response struct {
+	// Request names the command answered.
+	Request string
+	// Error, when set, is sent to the requester and nothing is applied.
+	Error string
+	// apply carries out a command that decoded cleanly, against the shared
+	// state and on behalf of c, and sends its replies. Callers hold
+	// stateMu.
+	apply func(c *client)
+}
+
+// refuse answers a command with an error.
+func refuse(message string) response { return response{Error: message} }
+
+// perform answers a command with the effect that carries it out.
+func perform(apply func(c *client)) response { return response{apply: apply} }
+
+// runCommand carries out a parsed command from c. Every command gets either
+// its normal reply or an error, and a panic while handling one is reported
+// to the client instead of taking down the connection. Callers hold stateMu.
+func runCommand(c *client, cmd Command) {
+	c.replyID = cmd.ID
+	defer func() { c.replyID = nil }()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Panic handling %s/%s from client %s: %v", cmd.Type, cmd.Request, c, p)
+			sendError(c, cmd.Request, "Internal error")
+		}
+	}()
+	resp := handleCommand(cmd)
+	if resp.Error != "" {
+		sendError(c, resp.Request, resp.Error)
+		return
+	}
+	resp.apply(c)
+}
+
+// handleCommand decodes and checks cmd's payload. It neither reads nor
+// changes the mic, session or connection state and writes nothing, so
+// malformed input is refused before anything is touched; the returned
+// effect does the rest.
+func handleCommand(cmd Command) response {
+	resp := commandResponse(cmd)
+	resp.Request = cmd.Request
+	return resp
+}
+
+func commandResponse(cmd Command) response {
+	switch cmd.Type {
+	case "control":
+	case "ping":
+		return perform(func(c *client) { c.sendMessage("pong", "", nil) })
+	default:
+		return refuse("Unknown message type " + cmd.Type)
+	}
+
+	request, payload := cmd.Request, cmd.Payload
+	switch request {
+	case "mic-listen":
+		req, err := parseListen(payload)
+		if err != nil && !errors.Is(err, errInvalidConfig) {
+			return refuse(err.Error())
+		}
+		return perform(func(c *client) {
+			audit("mic-listen", c.id, "remote", c.remote)
+			if err != nil {
+				setMicError(ErrInvalidConfig, "Invalid config")
 				broadcastState()
-				continue
-			}
-			switch cmd.Type {
-			case "control":
-				switch cmd.Request {
-				case "mic-listen":
-					var cfg MicConfig
-					if len(cmd.Payload) > 0 {
-						if err := json.Unmarshal(cmd.Payload, &cfg); err != nil {
-							micState = "error"
-							micError = "Invalid config"
-							broadcastState()
-							continue
-						}
-						currentConfig = cfg
-					}
-
-					// if the mic is not already listening, start it
-					if audioSession == nil {
-						audioSession, err = StartAudioStream(AudioConfig(currentConfig), func(chunk []byte) {
-							conn.WriteMessage(websocket.BinaryMessage, chunk)
-						})
-						if err != nil {
-							log.Println("Audio start error:", err)
-							audioSession = nil
-							micState = "error"
-							micError = "Audio start error"
-						} else {
-							micState = "listening"
-							micError = ""
-						}
-						broadcastState()
-					} else {
-						// already listening
-					}
-				case "mic-stop":
-					if audioSession != nil {
-						// kill the audio session
-						audioSession.Stop()
-						audioSession = nil
-						micState = "idle"
-						micError = ""
-						broadcastState()
-					}
-				case "mic-config": // sets the current configuration
-
-					// dont update if there is currently a session
-					if audioSession != nil {
-						continue
-					}
-
-					var cfg MicConfig
-					if err := json.Unmarshal(cmd.Payload, &cfg); err != nil {
-						micState = "error"
-						micError = "Invalid config"
-						broadcastState()
-						continue
-					}
-					currentConfig = cfg
-					broadcastState()
-				case "mic-state":
-					// Client requests current state
-					stateMsg := map[string]interface{}{
-						"type":    "state",
-						"request": "mic",
-						"payload": StatePayload{
-							State:  micState,
-							Config: currentConfig,
-							Error:  micError,
-						},
-					}
-					msg, _ := json.Marshal(stateMsg)
-					conn.WriteMessage(websocket.TextMessage, msg)
-				}
-			case "ping":
-				pongMsg := map[string]interface{}{
-					"type":    "pong",
-					"request": "",
-					"payload": nil,
-				}
-				msg, _ := json.Marshal(pongMsg)
-				conn.WriteMessage(websocket.TextMessage, msg)
+				return
+			}
+			listen(c, request, req)
+		})
+	case "mic-stop":
+		var req struct {
+			Force bool `json:"force"`
+		}
+		if len(payload) > 0 {
+			json.Unmarshal(payload, &req)
+		}
+		return perform(func(c *client) {
+			if audioSession != nil && !canStop(c, req.Force || checkAdminToken(cmd.Token)) {
+				sendError(c, request, fmt.Sprintf("Session owned by connection %d; send force to stop it", sessionOwner))
+				return
+			}
+			audit("mic-stop", c.id, "remote", c.remote, "force", strconv.FormatBool(req.Force))
+			if audioSession != nil {
+				// kill the audio session
+				stopSession()
+				setMicState(StateIdle)
+				broadcastState()
+			}
+		})
+	case "mic-dump":
+		// sends the ring buffer as one WAV, announced by a text frame
+		return perform(func(c *client) {
+			if audioSession == nil {
+				sendError(c, request, "Not listening")
+				return
+			}
+			dump := audioSession.Dump()
+			if dump == nil {
+				sendError(c, request, "Ring buffer disabled; set ringSeconds")
+				return
+			}
+			c.sendMessage("dump", request, DumpPayload{Bytes: len(dump)})
+			c.send(websocket.BinaryMessage, dump)
+		})
+	case "mic-record-start":
+		var opts recordOptions
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, &opts); err != nil {
+				return refuse("Invalid record options")
+			}
+		}
+		return perform(func(c *client) {
+			if audioSession == nil {
+				sendError(c, request, "Not listening")
+				return
+			}
+			info, err := startRecording(audioSession.Config(), opts)
+			if err != nil {
+				sendError(c, request, err.Error())
+				return
+			}
+			audit("record-start", c.id, "remote", c.remote, "path", info.Path)
+			c.sendMessage("recording", request, info)
+			broadcastState()
+		})
+	case "mic-record-stop":
+		return perform(func(c *client) {
+			info, err := stopRecording()
+			if err != nil && info == nil {
+				sendError(c, request, err.Error())
+				return
+			}
+			audit("record-stop", c.id, "remote", c.remote, "path", info.Path)
+			if err != nil {
+				sendError(c, request, "Recording incomplete: "+err.Error())
+			} else {
+				c.sendMessage("recording", request, info)
+			}
+			broadcastState()
+		})
+	case "mic-config": // sets the current configuration
+		var cfg MicConfig
+		decodeErr := json.Unmarshal(payload, &cfg)
+		return perform(func(c *client) {
+			// dont update if there is currently a session
+			if audioSession != nil {
+				return
+			}
+			if decodeErr != nil {
+				setMicError(ErrInvalidConfig, "Invalid config")
+				broadcastState()
+				return
+			}
+			cfg := withDeviceDefaults(cfg)
+			if err := AudioConfig(cfg).Validate(); err != nil {
+				log.Println("Invalid config:", err)
+				setMicError(ErrInvalidConfig, "Invalid config: "+err.Error())
+				broadcastState()
+				return
+			}
+			currentConfig = cfg
+			broadcastState()
+		})
+	case "mic-switch-device":
+		var req struct {
+			Device string `json:"device"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil || req.Device == "" {
+			return refuse("Expected {\"device\": \"...\"}")
+		}
+		return perform(func(c *client) {
+			audit("mic-switch-device", c.id, "remote", c.remote, "device", req.Device)
+			switchDevice(c, req.Device)
+		})
+	case "mic-sample-format":
+		var req struct {
+			SampleFormat SampleFormat `json:"sampleFormat"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil || req.SampleFormat == "" {
+			return refuse("Expected {\"sampleFormat\": \"" + strings.Join(sampleFormatNames(), "|") + "\"}")
+		}
+		if err := validateSampleFormat(req.SampleFormat, 0); err != nil {
+			return refuse(err.Error())
+		}
+		return perform(func(c *client) {
+			audit("mic-sample-format", c.id, "remote", c.remote, "sampleFormat", string(req.SampleFormat))
+			changeSampleFormat(c, request, req.SampleFormat)
+		})
+	case "mic-volume":
+		// reads the capture level, or sets it when a level is given
+		var req struct {
+			Level   *int   `json:"level"`
+			Control string `json:"control"`
+		}
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, &req); err != nil {
+				return refuse("Expected {\"level\": 0-100}")
+			}
+		}
+		if req.Control == "" {
+			req.Control = defaultVolumeControl
+		}
+		if !volumeControlPattern.MatchString(req.Control) {
+			return refuse("Invalid mixer control")
+		}
+		return perform(func(c *client) {
+			cfg := currentConfig
+			if audioSession != nil {
+				cfg = sessionConfig
+			}
+			device := AudioConfig(cfg).ResolvedDevice()
+			var level int
+			var err error
+			if req.Level != nil {
+				audit("mic-volume", c.id, "remote", c.remote, "level", strconv.Itoa(*req.Level))
+				level, err = setVolume(device, req.Control, *req.Level)
+			} else {
+				level, err = getVolume(device, req.Control)
+			}
+			if err != nil {
+				sendError(c, request, "Volume error: "+err.Error())
+				return
+			}
+			captureVolume = &level
+			c.sendMessage("volume", request, VolumeInfo{Level: level, Control: req.Control})
+			if req.Level != nil {
+				broadcastState()
+			}
+		})
+	case "mic-mix":
+		var req struct {
+			File    string   `json:"file"`
+			MicGain *float64 `json:"micGain"`
+			MixGain *float64 `json:"mixGain"`
+			Loop    bool     `json:"loop"`
+			Stop    bool     `json:"stop"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return refuse("Expected {\"file\": \"...\"} or {\"stop\": true}")
+		}
+		info := MixInfo{File: req.File, MicGain: 1, MixGain: 1, Loop: req.Loop}
+		if req.MicGain != nil {
+			info.MicGain = *req.MicGain
+		}
+		if req.MixGain != nil {
+			info.MixGain = *req.MixGain
+		}
+		return perform(func(c *client) {
+			if req.Stop {
+				stopMix()
+				broadcastState()
+				return
+			}
+			if audioSession == nil {
+				sendError(c, request, "Not listening")
+				return
+			}
+			if err := startMix(info); err != nil {
+				sendError(c, request, "Mix error: "+err.Error())
+				return
+			}
+			audit("mic-mix", c.id, "remote", c.remote, "file", info.File)
+			broadcastState()
+		})
+	case "mic-rtp":
+		var opts RTPOptions
+		decodeErr := json.Unmarshal(payload, &opts)
+		return perform(func(c *client) {
+			// sends audio to a host of the client's choosing, so it is
+			// an admin request
+			if !checkAdminToken(cmd.Token) {
+				sendError(c, request, "Unauthorized")
+				return
+			}
+			if decodeErr != nil {
+				sendError(c, request, "Expected {\"host\": \"...\", \"port\": N} or {\"stop\": true}")
+				return
+			}
+			if opts.Stop {
+				audit("rtp-stop", c.id, "remote", c.remote)
+				stopRTP()
+				broadcastState()
+				return
+			}
+			if audioSession == nil {
+				sendError(c, request, "Not listening")
+				return
+			}
+			info, err := startRTP(audioSession.Config(), opts)
+			if err != nil {
+				sendError(c, request, "RTP error: "+err.Error())
+				return
+			}
+			audit("rtp-start", c.id, "remote", c.remote, "destination", info.Destination)
+			c.sendMessage("rtp", request, info)
+			broadcastState()
+		})
+	case "mic-debug-cmd":
+		// the running session's command, or a preview for the current
+		// config or one sent in the payload
+		var preview *MicConfig
+		if len(payload) > 0 {
+			var cfg MicConfig
+			if err := json.Unmarshal(payload, &cfg); err != nil {
+				return refuse("Invalid config")
+			}
+			if err := AudioConfig(cfg).Validate(); err != nil {
+				return refuse("Invalid config: " + err.Error())
+			}
+			preview = &cfg
+		}
+		return perform(func(c *client) {
+			var info CaptureCommand
+			switch {
+			case preview != nil:
+				info = captureCommand(AudioConfig(*preview))
+			case audioSession != nil:
+				info = captureCommand(audioSession.CaptureConfig())
+				info.Running = true
+			default:
+				info = captureCommand(AudioConfig(currentConfig))
+			}
+			c.sendMessage("debug-cmd", request, info)
+		})
+	case "mic-loglevel":
+		var req struct {
+			Level string `json:"level"`
+		}
+		json.Unmarshal(payload, &req)
+		return perform(func(c *client) {
+			if !checkAdminToken(cmd.Token) {
+				sendError(c, request, "Unauthorized")
+				return
+			}
+			if err := setLogLevel(req.Level); err != nil {
+				sendError(c, request, err.Error())
+				return
+			}
+			audit("mic-loglevel", c.id, "remote", c.remote, "level", req.Level)
+			c.sendMessage("loglevel", request, LogLevelPayload{Level: logLevel.Level().String()})
+		})
+	case "mic-reset":
+		return perform(func(c *client) {
+			if !checkAdminToken(cmd.Token) {
+				sendError(c, request, "Unauthorized")
+				return
+			}
+			audit("mic-reset", c.id, "remote", c.remote)
+			resetCapture()
+		})
+	case "mic-metrics":
+		return perform(func(c *client) { c.sendMessage("metrics", request, collectMetrics()) })
+	case "mic-errors":
+		var req struct {
+			Limit int `json:"limit"`
+		}
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, &req); err != nil {
+				return refuse("Expected {\"limit\": N}")
+			}
+		}
+		return perform(func(c *client) {
+			c.sendMessage("errors", request, ErrorsPayload{Errors: recentErrors(req.Limit)})
+		})
+	case "mic-info":
+		return perform(func(c *client) { c.sendMessage("info", request, daemonInfo()) })
+	case "mic-bandwidth":
+		return perform(func(c *client) {
+			cfg, req, err := parseBandwidthRequest(payload, currentConfig)
+			if err != nil {
+				sendError(c, request, "Invalid config: "+err.Error())
+				return
+			}
+			estimate, err := estimateBandwidth(AudioConfig(cfg), req)
+			if err != nil {
+				sendError(c, request, err.Error())
+				return
+			}
+			c.sendMessage("bandwidth", request, estimate)
+		})
+	case "mic-resources":
+		return perform(func(c *client) { c.sendMessage("resources", request, collectResources()) })
+	case "mic-available":
+		return perform(func(c *client) { c.sendMessage("available", request, micAvailability()) })
+	case "mic-disconnect-all":
+		return perform(func(c *client) {
+			if !checkAdminToken(cmd.Token) {
+				sendError(c, request, "Unauthorized")
+				return
+			}
+			audit("mic-disconnect-all", c.id, "remote", c.remote)
+			// apply runs under stateMu, which the closes must not hold
+			go closeForMaintenance(disconnectAll())
+		})
+	case "mic-subscribe", "mic-unsubscribe":
+		var req struct {
+			Types []string `json:"types"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return refuse("Expected {\"types\": [...]}")
+		}
+		for _, kind := range req.Types {
+			if !validSubscription(kind) {
+				return refuse("Unknown message type " + kind)
 			}
 		}
+		return perform(func(c *client) {
+			c.setSubscribed(req.Types, request == "mic-subscribe")
+			c.sendMessage("subscriptions", request, SubscriptionsPayload{Types: c.subscriptions()})
+		})
+	case "mic-stats":
+		return perform(func(c *client) { c.sendMessage("stats", request, c.statsSnapshot()) })
+	case "mic-state":
+		// Client requests current state
+		return perform(func(c *client) {
+			refreshListeners()
+			sendState(c)
+		})
 	}
+	return refuse("Unknown request")
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseListen(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		// wantErr is "" for a valid payload, "config" for a malformed
+		// config and "options" for refused options
+		wantErr string
+	}{
+		{``, ""},
+		{`{"sampleRate": 16000, "dropPolicy": "drop-newest", "coalesceMs": 100, "frameHeader": true, "frameClock": "samples"}`, ""},
+		{`{"trigger": "vad", "vad": {"thresholdDb": -30}}`, ""},
+		{`{"powerSave": false}`, ""},
+		{`{"sampleRate": "fast"}`, "config"},
+		{`{`, "config"},
+		{`{"coalesceMs": "soon"}`, "options"},
+		{`{"dropPolicy": "block"}`, "options"},
+		{`{"coalesceMs": -1}`, "options"},
+		{`{"clipSeconds": 1e9}`, "options"},
+		{`{"frameHeader": true, "frameClock": "sundial"}`, "options"},
+		{`{"trigger": "clap"}`, "options"},
+		{`{"trigger": "vad", "vad": {"thresholdDb": "loud"}}`, "options"},
+		{`{"powerSave": 3}`, "options"},
+		{`{"format": "mp9"}`, "options"},
+	} {
+		_, err := parseListen(json.RawMessage(tc.payload))
+		var got string
+		switch {
+		case errors.Is(err, errInvalidConfig):
+			got = "config"
+		case err != nil:
+			got = "options"
+		}
+		if got != tc.wantErr {
+			t.Errorf("parseListen(%s): error %v, want %q", tc.payload, err, tc.wantErr)
+		}
+	}
+}
+
+func TestListenNeverConfigured(t *testing.T) {
+	defer func(cfg MicConfig, h []ErrorRecord) { currentConfig, errorHistory = cfg, h }(currentConfig, errorHistory)
+	defer setMicState(StateIdle)
+	launched := filepath.Join(t.TempDir(), "launched")
+	fakeArecord(t, "touch "+launched+"\n"+zeros)
+	for _, payload := range []string{``, `{"sampleRate": 16000}`} {
+		currentConfig = MicConfig{}
+		c, _ := stalledClient(t, DropOldest)
+		req, err := parseListen(json.RawMessage(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stateMu.Lock()
+		listen(c, "mic-listen", req)
+		running := audioSession != nil
+		if running {
+			stopSession()
+		}
+		stateMu.Unlock()
+		if running {
+			t.Fatalf("listen %q started capture from an unset config", payload)
+		}
+		if micState != StateError || micErrorCode != ErrInvalidConfig {
+			t.Errorf("listen %q left state %s, code %s; want %s, %s", payload, micState, micErrorCode, StateError, ErrInvalidConfig)
+		}
+		if c.listener {
+			t.Errorf("listen %q registered a listener for capture that never started", payload)
+		}
+		if _, err := os.Stat(launched); err == nil {
+			t.Fatalf("listen %q ran arecord", payload)
+		}
+	}
+}
+
+func FuzzParseCommand(f *testing.F) {
+	for _, seed := range []string{
+		`{"type": "ping"}`,
+		`{"type": "control", "request": "mic-state"}`,
+		`{"type": "control", "request": "mic-listen", "payload": {"sampleRate": 16000, "channels": 1, "sampleFormat": "s16le", "secondsPerChunk": 0.1}}`,
+		`{"type": "control", "request": "mic-listen", "payload": {"trigger": "vad", "vad": {"thresholdDb": -30}, "powerSave": true}}`,
+		`{"type": "control", "request": "mic-config", "payload": {"sampleRate": "native", "downmix": "left"}}`,
+		`{"type": "control", "request": "mic-switch-device", "payload": {"device": "plughw:1,0"}}`,
+		`{"type": "control", "request": "mic-sample-format", "payload": {"sampleFormat": "s24le"}}`,
+		`{"type": "control", "request": "mic-volume", "payload": {"level": 50, "control": "Capture"}}`,
+		`{"type": "control", "request": "mic-mix", "payload": {"file": "a.wav", "micGain": 0.5}}`,
+		`{"type": "control", "request": "mic-subscribe", "payload": {"types": ["level", "bogus"]}}`,
+		`{"type": "control", "request": "mic-errors", "payload": {"limit": "ten"}}`,
+		`{"type": "control", "request": "mic-debug-cmd", "payload": {"channels": -4}}`,
+		`{"type": "control", "request": "mic-rtp", "token": "x", "payload": {"host": "h", "port": 5004}}`,
+		`{"type": "control", "request": "mic-nope", "id": 7}`,
+		`{"type": "control", "request": "mic-listen", "payload": [1, 2]}`,
+		`{"type": "mystery"}`,
+		`{"type": ""}`,
+		`not json`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		cmd, err := parseCommand(msg)
+		if err != nil {
+			return
+		}
+		resp := handleCommand(cmd)
+		if resp.Request != cmd.Request {
+			t.Fatalf("response answers %q, command was %q", resp.Request, cmd.Request)
+		}
+		if (resp.Error == "") == (resp.apply == nil) {
+			t.Fatalf("response to %s has error %q and effect %v; want exactly one", msg, resp.Error, resp.apply != nil)
+		}
+	})
+}
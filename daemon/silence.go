@@ -0,0 +1,80 @@
+package main
+
+import "time"
+
+// idleSilenceRetry is how soon the silence pump looks again when it has no
+// usable format to synthesize in, e.g. before the native rate is known.
+const idleSilenceRetry = 100 * time.Millisecond
+
+// setIdleSilence keeps the connection's stream fed with silence whenever no
+// audio has been delivered for two frame intervals, such as between
+// sessions. Until capture has delivered audio, fallback is the format.
+func (c *client) setIdleSilence(on bool, fallback AudioConfig) {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	if c.stopSilence != nil {
+		close(c.stopSilence)
+		c.stopSilence = nil
+	}
+	if !on {
+		return
+	}
+	c.silenceCfg = fallback
+	stop := make(chan struct{})
+	c.stopSilence = stop
+	go c.silencePump(stop)
+}
+
+func (c *client) silencePump(stop chan struct{}) {
+	wait := idleSilenceRetry
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c.done:
+			return
+		case <-time.After(wait):
+		}
+		var frames []audioFrame
+		frames, wait = c.silence()
+		if c.isStreaming() {
+			for _, f := range frames {
+				c.sendAudio(f)
+			}
+		}
+	}
+}
+
+// silence returns one frame of encoded silence when capture has gone quiet,
+// and how long to wait before the next one.
+func (c *client) silence() ([]audioFrame, time.Duration) {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	src := c.silenceCfg
+	if c.encoder != nil {
+		src = c.encoderCfg
+	}
+	out := c.deliveryConfig(src)
+	chunk, n := c.chunkTiming(out)
+	period := time.Duration(chunk * float64(n) * float64(time.Millisecond))
+	if period <= 0 || c.clipSeconds > 0 {
+		return nil, idleSilenceRetry
+	}
+	if time.Since(c.lastAudio) < 2*period || c.lowPower() {
+		return nil, period
+	}
+	frames, err := c.ensureEncoder(src)
+	if err != nil {
+		encodeErrorLog.Printf("Client %s silence encode error: %v", c, err)
+		return nil, period
+	}
+	pcm := make([]byte, chunkBytes(c.outCfg)*n)
+	frame, err := c.encoder.Encode(pcm)
+	if err != nil {
+		encodeErrorLog.Printf("Client %s silence encode error: %v", c, err)
+	}
+	if f := c.wrap(frame, FlagSilence, pcm); f.data != nil {
+		frames = append(frames, f)
+	}
+	return frames, period
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sseQueueSize bounds the events waiting for one SSE client; beyond it the
+// oldest are dropped, as for a drop-oldest websocket.
+const sseQueueSize = 64
+
+// sseHeartbeat is how often an idle SSE stream gets a comment line, so
+// proxies do not time it out.
+const sseHeartbeat = 15 * time.Second
+
+// sseClient is one GET /events stream.
+type sseClient struct {
+	id     uint64
+	events chan []byte
+}
+
+// sseClients are the open event streams. Guarded by sseMu, which is taken
+// from the capture goroutine and never while waiting on stateMu.
+var (
+	sseMu      sync.Mutex
+	sseClients = map[*sseClient]struct{}{}
+)
+
+// AudioEvent is the data of an SSE audio event: one chunk as a base64
+// WAV file in the config it was captured with.
+type AudioEvent struct {
+	Seq  uint64 `json:"seq"`
+	Data string `json:"data"`
+}
+
+var sseSeq atomic.Uint64
+
+// sseSession is the capture session SSE clients started, which the last of
+// them to leave stops. Sessions started by websocket listeners are left to
+// them. Guarded by stateMu.
+var sseSession *AudioSession
+
+// sseEvent formats one text/event-stream event with a JSON payload.
+func sseEvent(name string, payload interface{}) []byte {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("SSE: cannot marshal %s event: %v", name, err)
+		return nil
+	}
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", name, data))
+}
+
+// publishSSE queues ev for every SSE client without blocking.
+func publishSSE(ev []byte) {
+	if ev == nil {
+		return
+	}
+	sseMu.Lock()
+	defer sseMu.Unlock()
+	for s := range sseClients {
+		select {
+		case s.events <- ev:
+			continue
+		default:
+		}
+		select {
+		case <-s.events:
+		default:
+		}
+		select {
+		case s.events <- ev:
+		default:
+		}
+	}
+}
+
+func hasSSEClients() bool {
+	sseMu.Lock()
+	defer sseMu.Unlock()
+	return len(sseClients) > 0
+}
+
+// writeSSE sends a captured chunk to SSE clients. Called from the capture
+// goroutine.
+func writeSSE(cfg AudioConfig, pcm []byte) {
+	if !hasSSEClients() {
+		return
+	}
+	wav := wavChunk(pcm, cfg.SampleRate, cfg.Channels, cfg.BytesPerSample)
+	publishSSE(sseEvent("audio", AudioEvent{
+		Seq:  sseSeq.Add(1),
+		Data: base64.StdEncoding.EncodeToString(wav),
+	}))
+}
+
+// sendSSEState sends the shared state to SSE clients. Callers hold stateMu.
+func sendSSEState() {
+	if hasSSEClients() {
+		publishSSE(sseEvent("state", sharedStatePayload()))
+	}
+}
+
+// handleEvents streams state and audio as Server-Sent Events for clients
+// whose network blocks websockets. Capture starts in the global config if
+// it is not already running. A session SSE started stops when the last SSE
+// client leaves with no websocket listener left.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	s := &sseClient{id: atomic.AddUint64(&nextConnID, 1), events: make(chan []byte, sseQueueSize)}
+	connectionsTotal.Add(1)
+	audit("sse-connect", s.id, "remote", r.RemoteAddr, "userAgent", r.UserAgent())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	stateMu.Lock()
+	sseMu.Lock()
+	sseClients[s] = struct{}{}
+	sseMu.Unlock()
+	started := false
+	if audioSession == nil {
+		if err := AudioConfig(currentConfig).validateStart(); err != nil {
+			setMicError(ErrInvalidConfig, "Cannot start capture: "+err.Error())
+			broadcastState()
+		} else {
+			startSession(currentConfig)
+			started = true
+			if audioSession != nil {
+				sseSession = audioSession
+			}
+		}
+	}
+	if !started {
+		s.events <- sseEvent("state", sharedStatePayload())
+	}
+	stateMu.Unlock()
+	log.Printf("SSE client %d connected from %s", s.id, r.RemoteAddr)
+
+	defer func() {
+		stateMu.Lock()
+		defer stateMu.Unlock()
+		sseMu.Lock()
+		delete(sseClients, s)
+		remaining := len(sseClients)
+		sseMu.Unlock()
+		audit("sse-disconnect", s.id, "remote", r.RemoteAddr)
+		log.Printf("SSE client %d disconnected", s.id)
+		if remaining > 0 {
+			return
+		}
+		owned := audioSession != nil && audioSession == sseSession
+		sseSession = nil
+		if owned && !hasListeners(nil) {
+			log.Println("Last SSE client left; stopping capture")
+			stopSession()
+			setMicState(StateIdle)
+			broadcastState()
+		}
+	}()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-s.events:
+			if _, err := w.Write(ev); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
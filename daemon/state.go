@@ -0,0 +1,16 @@
+package main
+
+// MicState is the state of the shared microphone, as sent in state
+// messages and recorded in history.
+type MicState string
+
+const (
+	StateIdle      MicState = "idle"
+	StateListening MicState = "listening"
+	StateError     MicState = "error"
+	// StateResetting is held while mic-reset tears capture down.
+	StateResetting MicState = "resetting"
+	// StateSwitching is held while mic-switch-device moves capture to
+	// another device.
+	StateSwitching MicState = "switching"
+)
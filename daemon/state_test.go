@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMicStateJSON(t *testing.T) {
+	for state, wire := range map[MicState]string{
+		StateIdle:      `"idle"`,
+		StateListening: `"listening"`,
+		StateError:     `"error"`,
+		StateResetting: `"resetting"`,
+		StateSwitching: `"switching"`,
+	} {
+		b, err := json.Marshal(state)
+		if err != nil || string(b) != wire {
+			t.Errorf("Marshal(%s) = %s, %v; want %s", state, b, err, wire)
+		}
+		var back MicState
+		if err := json.Unmarshal(b, &back); err != nil || back != state {
+			t.Errorf("Unmarshal(%s) = %q, %v", b, back, err)
+		}
+
+		// and as a field of the messages that carry it
+		payload, _ := json.Marshal(StatePayload{State: state})
+		var decoded struct {
+			State string `json:"state"`
+		}
+		if err := json.Unmarshal(payload, &decoded); err != nil || `"`+decoded.State+`"` != wire {
+			t.Errorf("state payload carries %q, want %s", decoded.State, wire)
+		}
+		var tr StateTransition
+		b, _ = json.Marshal(StateTransition{State: state})
+		if err := json.Unmarshal(b, &tr); err != nil || tr.State != state {
+			t.Errorf("transition round trip gave %q, %v", tr.State, err)
+		}
+	}
+}
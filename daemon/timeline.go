@@ -0,0 +1,61 @@
+package main
+
+import "time"
+
+// minGap is the shortest stretch without audio the timeline counts as a
+// gap rather than delivery jitter.
+const minGap = 250 * time.Millisecond
+
+// timeline keeps a connection's sample-clock timestamps monotonic and
+// aligned with real time. A stretch without audio, such as the time
+// between two sessions or outside a vad segment, advances the position
+// by its length instead of being skipped. A change of delivery rate
+// rescales the position rather than letting it jump backwards.
+type timeline struct {
+	rate int
+	pos  uint64
+	// end is when the audio delivered so far ran out, in real time.
+	end time.Time
+	// pending is a gap found while the encoder held its frame back.
+	pending *GapEvent
+}
+
+// GapEvent marks a stretch of the sample clock that carried no audio:
+// Samples sample frames starting at At.
+type GapEvent struct {
+	At      uint64 `json:"at"`
+	Samples uint64 `json:"samples"`
+}
+
+// advance places samples sample frames at rate, delivered at now, on the
+// timeline. It returns their position and any gap skipped before them.
+func (t *timeline) advance(samples, rate int, now time.Time) (uint64, *GapEvent) {
+	if rate <= 0 {
+		return t.pos, nil
+	}
+	if t.rate > 0 && rate != t.rate {
+		// round up so the rescaled position is never behind
+		t.pos = (t.pos*uint64(rate) + uint64(t.rate) - 1) / uint64(t.rate)
+	}
+	t.rate = rate
+	length := time.Duration(samples) * time.Second / time.Duration(rate)
+	var gap *GapEvent
+	if !t.end.IsZero() {
+		idle := now.Sub(t.end) - length
+		if idle > max(minGap, 2*length) {
+			n := uint64(idle.Seconds() * float64(rate))
+			gap = &GapEvent{At: t.pos, Samples: n}
+			t.pos += n
+		}
+	}
+	pos := t.pos
+	t.pos += uint64(samples)
+	if now.Sub(t.end) > length || t.end.IsZero() {
+		t.end = now
+	} else {
+		// audio arriving faster than real time, e.g. a burst after
+		// coalescing, extends the end rather than resetting it
+		t.end = t.end.Add(length)
+	}
+	return pos, gap
+}
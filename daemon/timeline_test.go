@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimelineDroppedChunks(t *testing.T) {
+	var tl timeline
+	start := time.Unix(1000, 0)
+	chunk := 100 * time.Millisecond
+	var last uint64
+	var gaps []GapEvent
+	// chunks 5 to 9 never arrive, as when capture stalls or drops them
+	for i := 0; i < 15; i++ {
+		if i >= 5 && i < 10 {
+			continue
+		}
+		pos, gap := tl.advance(1600, 16000, start.Add(time.Duration(i)*chunk))
+		if gap != nil {
+			gaps = append(gaps, *gap)
+		}
+		if i > 0 && pos <= last {
+			t.Fatalf("chunk %d at %d, not after %d", i, pos, last)
+		}
+		last = pos
+		// the clock stays on real time across the gap
+		if want := uint64(i * 1600); pos != want {
+			t.Errorf("chunk %d at sample %d, want %d", i, pos, want)
+		}
+	}
+	if len(gaps) != 1 {
+		t.Fatalf("gaps %+v, want one", gaps)
+	}
+	if g := gaps[0]; g.At != 5*1600 || g.Samples != 5*1600 {
+		t.Errorf("gap %+v, want 8000 samples at 8000", g)
+	}
+}
+
+func TestTimelineJitterIsNoGap(t *testing.T) {
+	var tl timeline
+	now := time.Unix(1000, 0)
+	for i, late := range []time.Duration{0, 180, 20, 150, 100, 0} {
+		now = now.Add(late * time.Millisecond)
+		pos, gap := tl.advance(1600, 16000, now)
+		if gap != nil {
+			t.Fatalf("chunk %d: jitter reported as gap %+v", i, *gap)
+		}
+		if pos != uint64(i*1600) {
+			t.Fatalf("chunk %d at %d, want %d", i, pos, i*1600)
+		}
+	}
+}
+
+func TestTimelineRateChange(t *testing.T) {
+	var tl timeline
+	now := time.Unix(1000, 0)
+	tl.advance(1600, 16000, now)
+	pos, _ := tl.advance(4800, 48000, now.Add(100*time.Millisecond))
+	if pos != 4800 {
+		t.Fatalf("after 0.1 s at 16 kHz, 48 kHz position %d, want 4800", pos)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+// unixSocketMode restricts the socket to its owner and group.
+const unixSocketMode fs.FileMode = 0o660
+
+// listenUnix serves handler on a Unix domain socket at path, replacing a
+// stale socket left by a previous run. Access is governed by the socket's
+// file permissions, so only the daemon's user and group can connect.
+func listenUnix(path string, handler http.Handler) error {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		ln.Close()
+		return err
+	}
+	log.Printf("WebSocket server listening on unix socket %s (mode %v)", path, unixSocketMode)
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			log.Println("Unix socket server error:", err)
+		}
+	}()
+	return nil
+}
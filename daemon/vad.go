@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// Trigger modes for mic-listen.
+const (
+	// TriggerVAD delivers audio only while speech is detected.
+	TriggerVAD = "vad"
+)
+
+// vadFrameMs is the analysis window of the voice activity detector.
+const vadFrameMs = 20
+
+// VADOptions tunes the vad trigger.
+type VADOptions struct {
+	// ThresholdDB is the RMS level in dBFS treated as speech.
+	ThresholdDB float64 `json:"thresholdDb"`
+	// PreRollMs of audio before onset is delivered with each segment so
+	// the start of the utterance is not clipped.
+	PreRollMs int `json:"preRollMs"`
+	// HangoverMs of trailing silence ends a segment.
+	HangoverMs int `json:"hangoverMs"`
+}
+
+var defaultVADOptions = VADOptions{ThresholdDB: -40, PreRollMs: 300, HangoverMs: 800}
+
+func (o VADOptions) validate() error {
+	if o.ThresholdDB >= 0 || o.ThresholdDB < -120 {
+		return errors.New("vad.thresholdDb must be between -120 and 0")
+	}
+	if o.PreRollMs < 0 || o.PreRollMs > 10000 || o.HangoverMs < 0 || o.HangoverMs > 10000 {
+		return errors.New("vad preRollMs and hangoverMs must be between 0 and 10000")
+	}
+	return nil
+}
+
+// Segment events sent as vad messages.
+const (
+	SegmentStart = "segment-start"
+	SegmentEnd   = "segment-end"
+)
+
+// vadPiece is either audio to deliver or a segment event, in stream order.
+type vadPiece struct {
+	event string
+	pcm   []byte
+}
+
+// vadTrigger gates captured audio on an energy detector, holding a pre-roll
+// of recent audio while idle.
+type vadTrigger struct {
+	opts       VADOptions
+	cfg        AudioConfig
+	frameBytes int
+	preRollMax int
+	threshold  float64
+
+	active  bool
+	silent  int
+	preRoll []byte
+}
+
+func newVADTrigger(opts VADOptions) *vadTrigger {
+	return &vadTrigger{opts: opts, threshold: math.Pow(10, opts.ThresholdDB/20)}
+}
+
+// reset sizes the trigger for capture in cfg, starting idle.
+func (v *vadTrigger) reset(cfg AudioConfig) {
+	frame := cfg.Channels * cfg.BytesPerSample
+	v.cfg = cfg
+	v.frameBytes = max(cfg.SampleRate*vadFrameMs/1000, 1) * frame
+	v.preRollMax = cfg.SampleRate * v.opts.PreRollMs / 1000 * frame
+	v.active, v.silent, v.preRoll = false, 0, nil
+}
+
+// Process splits one captured chunk into the audio to deliver and the
+// segment events between it.
+func (v *vadTrigger) Process(cfg AudioConfig, pcm []byte) []vadPiece {
+	if !v.cfg.sameFormat(cfg) {
+		v.reset(cfg)
+	}
+	hangover := max(v.opts.HangoverMs/vadFrameMs, 1)
+	var pieces []vadPiece
+	var out []byte
+	for off := 0; off < len(pcm); off += v.frameBytes {
+		frame := pcm[off:min(off+v.frameBytes, len(pcm))]
+		speech := frameRMS(frame, cfg.BytesPerSample) >= v.threshold
+		if !v.active {
+			if !speech {
+				v.preRoll = append(v.preRoll, frame...)
+				if extra := len(v.preRoll) - v.preRollMax; extra > 0 {
+					v.preRoll = v.preRoll[extra:]
+				}
+				continue
+			}
+			v.active, v.silent = true, 0
+			pieces = append(pieces, vadPiece{event: SegmentStart})
+			out = append(append(out, v.preRoll...), frame...)
+			v.preRoll = nil
+			continue
+		}
+		out = append(out, frame...)
+		if speech {
+			v.silent = 0
+		} else if v.silent++; v.silent >= hangover {
+			v.active = false
+			pieces = append(pieces, vadPiece{pcm: out}, vadPiece{event: SegmentEnd})
+			out = nil
+		}
+	}
+	if len(out) > 0 {
+		pieces = append(pieces, vadPiece{pcm: out})
+	}
+	return pieces
+}
+
+// frameRMS is the RMS of a run of PCM as a fraction of full scale.
+func frameRMS(pcm []byte, bps int) float64 {
+	samples := decodePCM(pcm, bps)
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range samples {
+		v := float64(s) / 32768
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultVolumeControl is the ALSA simple mixer control most capture
+// devices expose.
+const defaultVolumeControl = "Capture"
+
+var (
+	volumePercentPattern = regexp.MustCompile(`\[([0-9]+)%\]`)
+	volumeControlPattern = regexp.MustCompile(`^[A-Za-z0-9 _\-]+$`)
+	hwCardPattern        = regexp.MustCompile(`^(?:plug)?hw:(?:CARD=)?([A-Za-z0-9_]+)`)
+)
+
+// errNoVolumeControl is returned for devices amixer cannot adjust.
+var errNoVolumeControl = errors.New("device has no controllable capture volume")
+
+// captureVolume is the last level read or set through mic-volume, or nil
+// before the first request. Guarded by stateMu.
+var captureVolume *int
+
+// VolumeInfo is the reply to mic-volume.
+type VolumeInfo struct {
+	Level   int    `json:"level"`
+	Control string `json:"control"`
+}
+
+// mixerCard returns the ALSA card for device, or "" for the default card.
+// Pulse sources have no ALSA mixer.
+func mixerCard(device string) (string, error) {
+	if strings.HasPrefix(device, pulseDevicePrefix) {
+		return "", errNoVolumeControl
+	}
+	if device == "default" {
+		return "", nil
+	}
+	m := hwCardPattern.FindStringSubmatch(device)
+	if m == nil {
+		return "", errNoVolumeControl
+	}
+	return m[1], nil
+}
+
+// amixer runs amixer against device's card and returns its output.
+func amixer(device string, args ...string) (string, error) {
+	card, err := mixerCard(device)
+	if err != nil {
+		return "", err
+	}
+	if card != "" {
+		args = append([]string{"-c", card}, args...)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "amixer", args...).CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errors.New("amixer not found; install alsa-utils")
+	}
+	if err != nil {
+		if strings.Contains(string(out), "Unable to find simple control") ||
+			strings.Contains(string(out), "Invalid card number") {
+			return "", errNoVolumeControl
+		}
+		return "", errors.New(strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// parseVolume reads the first percentage from amixer sget output. Stereo
+// controls report each side; the left is taken as the level.
+func parseVolume(out string) (int, error) {
+	m := volumePercentPattern.FindStringSubmatch(out)
+	if m == nil {
+		return 0, errNoVolumeControl
+	}
+	return strconv.Atoi(m[1])
+}
+
+// getVolume reads the capture level of control on device.
+func getVolume(device, control string) (int, error) {
+	out, err := amixer(device, "sget", control)
+	if err != nil {
+		return 0, err
+	}
+	return parseVolume(out)
+}
+
+// setVolume sets the capture level of control on device, clamped to
+// 0-100%, and returns the level the mixer settled on.
+func setVolume(device, control string, level int) (int, error) {
+	level = min(max(level, 0), 100)
+	out, err := amixer(device, "sset", control, strconv.Itoa(level)+"%")
+	if err != nil {
+		return 0, err
+	}
+	return parseVolume(out)
+}
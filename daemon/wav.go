@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// wavStreamingSize is written to the RIFF and data size fields of a stream
+// header to mark the length as unknown.
+const wavStreamingSize = 0xFFFFFFFF
+
+// wavChunk creates a WAV file in memory for a PCM chunk
+func wavChunk(pcm []byte, sampleRate, channels, bytesPerSample int) []byte {
+	return wavChunkSized(pcm, WAVSizePerChunk, sampleRate, channels, bytesPerSample)
+}
+
+// wavChunkSized is wavChunk with the header's size fields set by policy.
+func wavChunkSized(pcm []byte, policy WAVSizePolicy, sampleRate, channels, bytesPerSample int) []byte {
+	riff, data := wavSizeFields(policy, wavHeaderLen(channels, bytesPerSample), len(pcm))
+	buf := &bytes.Buffer{}
+	writeWAVHeader(buf, riff, data, sampleRate, channels, bytesPerSample)
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+// wavStreamHeader returns a header for a WAV stream of unknown length, the
+// sentinel form players accept as an infinite stream.
+func wavStreamHeader(sampleRate, channels, bytesPerSample int) []byte {
+	buf := &bytes.Buffer{}
+	writeWAVHeader(buf, wavStreamingSize, wavStreamingSize, sampleRate, channels, bytesPerSample)
+	return buf.Bytes()
+}
+
+func writeWAVHeader(buf *bytes.Buffer, riffSize, dataSize uint32, sampleRate, channels, bytesPerSample int) {
+	// RIFF header
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, riffSize)
+	buf.WriteString("WAVE")
+	writeFmtChunk(buf, sampleRate, channels, bytesPerSample)
+	// data chunk
+	buf.WriteString("data")
+	binary.Write(buf, binary.LittleEndian, dataSize)
+}
+
+// writeFmtChunk writes the fmt chunk in the layout -wav-format picks for
+// this channel count and depth.
+func writeFmtChunk(buf *bytes.Buffer, sampleRate, channels, bytesPerSample int) {
+	spec := specFor(bytesPerSample)
+	if useExtensible(channels, bytesPerSample) {
+		writeExtensibleFmt(buf, sampleRate, channels, spec)
+		return
+	}
+	blockAlign := channels * spec.bytes
+	byteRate := sampleRate * blockAlign
+
+	buf.WriteString("fmt ")
+	binary.Write(buf, binary.LittleEndian, uint32(16))         // Subchunk1Size
+	binary.Write(buf, binary.LittleEndian, spec.wavFormat)     // AudioFormat
+	binary.Write(buf, binary.LittleEndian, uint16(channels))   // NumChannels
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate)) // SampleRate
+	binary.Write(buf, binary.LittleEndian, uint32(byteRate))   // ByteRate
+	binary.Write(buf, binary.LittleEndian, uint16(blockAlign)) // BlockAlign
+	binary.Write(buf, binary.LittleEndian, uint16(spec.bits))  // BitsPerSample
+}
+
+// writeRIFFChunk writes a chunk with its id and size, padding odd-sized
+// bodies to a word boundary as RIFF requires.
+func writeRIFFChunk(buf *bytes.Buffer, id string, body []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(len(body)))
+	buf.Write(body)
+	if len(body)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// infoTags maps metadata names accepted from clients to RIFF INFO ids.
+var infoTags = map[string]string{
+	"title":     "INAM",
+	"artist":    "IART",
+	"album":     "IPRD",
+	"comment":   "ICMT",
+	"copyright": "ICOP",
+	"date":      "ICRD",
+	"genre":     "IGNR",
+	"software":  "ISFT",
+}
+
+// infoID resolves a metadata key to its INFO id. Keys may be one of the
+// infoTags names or a four-character INFO id such as "IENG".
+func infoID(key string) (string, bool) {
+	if id, ok := infoTags[strings.ToLower(key)]; ok {
+		return id, true
+	}
+	if len(key) == 4 && key[0] == 'I' && strings.ToUpper(key) == key {
+		return key, true
+	}
+	return "", false
+}
+
+// listInfoBody lays out a LIST chunk body of INFO subchunks, each a
+// NUL-terminated string, in id order so output is stable.
+func listInfoBody(metadata map[string]string) []byte {
+	byID := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		if id, ok := infoID(key); ok {
+			byID[id] = value
+		}
+	}
+	ids := make([]string, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("INFO")
+	for _, id := range ids {
+		writeRIFFChunk(buf, id, append([]byte(byID[id]), 0))
+	}
+	return buf.Bytes()
+}
+
+// wavReader streams the PCM data of a WAV file.
+type wavReader struct {
+	file           *os.File
+	sampleRate     int
+	channels       int
+	bytesPerSample int
+	// remaining is the unread size of the data chunk.
+	remaining int64
+}
+
+// openWAV opens a PCM WAV file and positions it at the start of its data.
+func openWAV(path string) (*wavReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := readWAVHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return r, nil
+}
+
+// maxFmtSize bounds the fmt chunk readWAVHeader reads into memory; real
+// ones are 16 to 40 bytes, so anything larger is a corrupt file.
+const maxFmtSize = 64 << 10
+
+func readWAVHeader(f *os.File) (*wavReader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(f, riff[:]); err != nil || string(riff[:4]) != "RIFF" || string(riff[8:]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+	r := &wavReader{file: f}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(f, hdr[:]); err != nil {
+			return nil, errors.New("no data chunk")
+		}
+		id, size := string(hdr[:4]), int64(binary.LittleEndian.Uint32(hdr[4:]))
+		switch id {
+		case "fmt ":
+			if size < fmtSizePCM || size > maxFmtSize {
+				return nil, fmt.Errorf("bad fmt chunk size %d", size)
+			}
+			body := make([]byte, size)
+			if _, err := io.ReadFull(f, body); err != nil {
+				return nil, errors.New("truncated fmt chunk")
+			}
+			format := binary.LittleEndian.Uint16(body)
+			if format == wavFormatTagExtensible && size >= fmtSizeExtensible &&
+				bytes.Equal(body[24:40], ksdataformatSubtypePCM[:]) {
+				format = wavFormatTagPCM
+			}
+			if format != wavFormatTagPCM {
+				return nil, fmt.Errorf("unsupported WAV format %d; only PCM is supported", format)
+			}
+			r.channels = int(binary.LittleEndian.Uint16(body[2:]))
+			r.sampleRate = int(binary.LittleEndian.Uint32(body[4:]))
+			r.bytesPerSample = int(binary.LittleEndian.Uint16(body[14:])) / 8
+			if size%2 == 1 {
+				f.Seek(1, io.SeekCurrent)
+			}
+		case "data":
+			if r.channels == 0 {
+				return nil, errors.New("data before fmt chunk")
+			}
+			if _, ok := sampleFormats[r.bytesPerSample]; !ok || r.sampleRate <= 0 {
+				return nil, fmt.Errorf("unsupported WAV layout: %d Hz, %d-bit", r.sampleRate, r.bytesPerSample*8)
+			}
+			r.remaining = size
+			return r, nil
+		default:
+			if _, err := f.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Read returns up to frames frames as 16-bit samples, or io.EOF at the end
+// of the data.
+func (r *wavReader) Read(frames int) ([]int16, error) {
+	frameSize := r.channels * r.bytesPerSample
+	n := min(int64(frames*frameSize), r.remaining)
+	n -= n % int64(frameSize)
+	if n <= 0 {
+		return nil, io.EOF
+	}
+	buf := make([]byte, n)
+	read, err := io.ReadFull(r.file, buf)
+	r.remaining -= int64(read)
+	if err != nil {
+		r.remaining = 0
+	}
+	read -= read % frameSize
+	if read == 0 {
+		return nil, io.EOF
+	}
+	return decodePCM(buf[:read], r.bytesPerSample), nil
+}
+
+func (r *wavReader) Close() error { return r.file.Close() }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWAVStreamHeaderSentinel(t *testing.T) {
+	h := wavStreamHeader(16000, 1, 2)
+	if len(h) != 44 {
+		t.Fatalf("header is %d bytes, want 44", len(h))
+	}
+	if string(h[:4]) != "RIFF" || string(h[8:12]) != "WAVE" || string(h[36:40]) != "data" {
+		t.Fatalf("unexpected chunk ids in % x", h)
+	}
+	if riff := binary.LittleEndian.Uint32(h[4:]); riff != wavStreamingSize {
+		t.Errorf("RIFF size %#x, want %#x", riff, wavStreamingSize)
+	}
+	if data := binary.LittleEndian.Uint32(h[40:]); data != wavStreamingSize {
+		t.Errorf("data size %#x, want %#x", data, wavStreamingSize)
+	}
+	// the sentinel is the same whatever follows
+	if got := wavChunkSized(make([]byte, 320), WAVSizeStreaming, 16000, 1, 2); string(got[:44]) != string(h) {
+		t.Error("wavChunkSized with the streaming policy wrote a different header")
+	}
+}
+
+func TestWAVStreamHeaderReadable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.wav")
+	pcm := wavCheckTone(16000, 1, 2, 1600)
+	if err := os.WriteFile(path, append(wavStreamHeader(16000, 1, 2), pcm...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openWAV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var frames int
+	for {
+		samples, err := r.Read(512)
+		if err != nil {
+			break
+		}
+		frames += len(samples)
+	}
+	if frames != 1600 {
+		t.Fatalf("read %d frames through an unknown-length header, want 1600", frames)
+	}
+
+	t.Run("decoders", func(t *testing.T) {
+		var out []byte
+		var rate *regexp.Regexp
+		switch {
+		case lookPath("sox"):
+			out, err = exec.Command("sox", "--info", path).CombinedOutput()
+			rate = regexp.MustCompile(`(?m)^Sample Rate\s*:\s*16000$`)
+		case lookPath("ffprobe"):
+			out, err = exec.Command("ffprobe", "-v", "error", "-show_entries", "stream=sample_rate", "-of", "default=noprint_wrappers=1", path).CombinedOutput()
+			rate = regexp.MustCompile(`(?m)^sample_rate=16000$`)
+		default:
+			t.Skip("neither sox nor ffprobe is installed")
+		}
+		if err != nil || !rate.Match(out) {
+			t.Fatalf("decoder rejected the stream header: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	})
+}
+
+func lookPath(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func TestWAVSizePolicyHeaders(t *testing.T) {
+	cfg := AudioConfig{SampleRate: 16000, Channels: 1, BytesPerSample: 2, SecondsPerChunk: 0.1}
+	pcm := make([]byte, 3200)
+	chunks := func() Encoder {
+		enc, err := newEncoder(FormatWAVChunks, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enc
+	}
+	for _, tc := range []struct {
+		name       string
+		enc        Encoder
+		policy     WAVSizePolicy
+		riff, data uint32
+	}{
+		{"wav-chunks default", chunks(), "", 36 + 3200, 3200},
+		{"wav-chunks per-chunk", chunks(), WAVSizePerChunk, 36 + 3200, 3200},
+		{"wav-chunks streaming-sentinel", chunks(), WAVSizeStreaming, wavStreamingSize, wavStreamingSize},
+		{"clip per-chunk", newClipEncoder(cfg, 0.1, true), WAVSizePerChunk, 36 + 3200, 3200},
+		{"clip finalized", newClipEncoder(cfg, 0.1, true), WAVSizeFinalized, 36 + 3200, 3200},
+	} {
+		if tc.policy != "" {
+			tc.enc.(wavSizer).setWAVSize(tc.policy)
+		}
+		frame, err := tc.enc.Encode(pcm)
+		if err != nil || len(frame) != 44+len(pcm) {
+			t.Fatalf("%s: %d bytes, %v", tc.name, len(frame), err)
+		}
+		if riff, data := binary.LittleEndian.Uint32(frame[4:]), binary.LittleEndian.Uint32(frame[40:]); riff != tc.riff || data != tc.data {
+			t.Errorf("%s: RIFF %#x data %#x, want %#x and %#x", tc.name, riff, data, tc.riff, tc.data)
+		}
+	}
+
+	for _, tc := range []struct {
+		policy, format string
+		clip, ok       bool
+	}{
+		{"per-chunk", FormatWAVChunks, false, true},
+		{"streaming-sentinel", FormatWAVChunks, false, true},
+		{"finalized", FormatWAVChunks, false, false},
+		{"finalized", FormatWAVChunks, true, true},
+		{"streaming-sentinel", FormatWAVChunks, true, false},
+		{"streaming-sentinel", FormatWAVStream, false, true},
+		{"per-chunk", FormatWAVStream, false, false},
+		{"per-chunk", FormatRaw, false, false},
+		{"exact", FormatWAVChunks, false, false},
+	} {
+		if _, err := parseWAVSize(tc.policy, tc.format, tc.clip); (err == nil) != tc.ok {
+			t.Errorf("parseWAVSize(%s, %s, clip %v): %v, want ok %v", tc.policy, tc.format, tc.clip, err, tc.ok)
+		}
+	}
+}
+
+func TestOpenWAVBadFmtSize(t *testing.T) {
+	dir := t.TempDir()
+	for _, size := range []uint32{8, maxFmtSize + 1, 0xFFFFFFF0} {
+		h := wavChunk(make([]byte, 320), 16000, 1, 2)
+		binary.LittleEndian.PutUint32(h[16:], size)
+		path := filepath.Join(dir, "bad.wav")
+		if err := os.WriteFile(path, h, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if r, err := openWAV(path); err == nil {
+			r.Close()
+			t.Errorf("opened a WAV with a %d-byte fmt chunk", size)
+		}
+	}
+}
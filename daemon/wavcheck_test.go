@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// wavCheckCases are the layouts TestWAVChunksDecode encodes: every supported sample
+// width, mono and stereo, and frame counts that leave an odd data size.
+var wavCheckCases = []struct {
+	rate, channels, bytesPerSample, frames int
+}{
+	{16000, 1, 2, 1600},
+	{48000, 2, 2, 4800},
+	{44100, 1, 3, 4411},
+	{48000, 2, 3, 960},
+	{96000, 2, 4, 9600},
+	{8000, 6, 2, 800},
+}
+
+// wavInfo is what an external tool read back from a WAV file.
+type wavInfo struct {
+	rate, channels, bits, frames int
+}
+
+// wavProber reads a WAV file's layout with an external tool.
+type wavProber struct {
+	name  string
+	probe func(path string) (wavInfo, error)
+}
+
+// wavProbers lists the tools TestWAVChunksDecode tries, in order of
+// preference.
+var wavProbers = []wavProber{
+	{"sox", probeSox},
+	{"ffprobe", probeFFprobe},
+}
+
+var (
+	soxRateRe     = regexp.MustCompile(`(?m)^Sample Rate\s*:\s*(\d+)`)
+	soxChannelsRe = regexp.MustCompile(`(?m)^Channels\s*:\s*(\d+)`)
+	soxBitsRe     = regexp.MustCompile(`(?m)^Precision\s*:\s*(\d+)-bit`)
+	soxFramesRe   = regexp.MustCompile(`(?m)^Duration\s*:.*=\s*(\d+) samples`)
+)
+
+func probeSox(path string) (wavInfo, error) {
+	out, err := exec.Command("sox", "--info", path).CombinedOutput()
+	if err != nil {
+		return wavInfo{}, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	var info wavInfo
+	for _, f := range []struct {
+		re  *regexp.Regexp
+		dst *int
+	}{
+		{soxRateRe, &info.rate},
+		{soxChannelsRe, &info.channels},
+		{soxBitsRe, &info.bits},
+		{soxFramesRe, &info.frames},
+	} {
+		m := f.re.FindSubmatch(out)
+		if m == nil {
+			return wavInfo{}, fmt.Errorf("unexpected sox output: %s", strings.TrimSpace(string(out)))
+		}
+		*f.dst, _ = strconv.Atoi(string(m[1]))
+	}
+	return info, nil
+}
+
+func probeFFprobe(path string) (wavInfo, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=sample_rate,channels,bits_per_sample,duration_ts",
+		"-of", "default=noprint_wrappers=1", path).CombinedOutput()
+	if err != nil {
+		return wavInfo{}, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	fields := map[string]int{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				fields[k] = n
+			}
+		}
+	}
+	for _, k := range []string{"sample_rate", "channels", "bits_per_sample", "duration_ts"} {
+		if _, ok := fields[k]; !ok {
+			return wavInfo{}, fmt.Errorf("ffprobe did not report %s: %s", k, strings.TrimSpace(string(out)))
+		}
+	}
+	return wavInfo{fields["sample_rate"], fields["channels"], fields["bits_per_sample"], fields["duration_ts"]}, nil
+}
+
+// wavCheckTone fills frames of interleaved PCM with a 440 Hz tone at half
+// scale, so the chunk carries real audio rather than silence.
+func wavCheckTone(rate, channels, bytesPerSample, frames int) []byte {
+	pcm := make([]byte, 0, frames*channels*bytesPerSample)
+	bits := uint(bytesPerSample * 8)
+	for i := 0; i < frames; i++ {
+		v := int64(math.Sin(2*math.Pi*440*float64(i)/float64(rate)) * float64(int64(1)<<(bits-2)))
+		for c := 0; c < channels; c++ {
+			for b := 0; b < bytesPerSample; b++ {
+				pcm = append(pcm, byte(v>>(8*b)))
+			}
+		}
+	}
+	return pcm
+}
+
+// TestWAVChunksDecode encodes each wavCheckCases layout with wavChunk, in
+// every -wav-format, and has the first installed prober read it back. It
+// catches header mistakes that comparing against bytes written by the same
+// code would repeat.
+func TestWAVChunksDecode(t *testing.T) {
+	var prober *wavProber
+	for i := range wavProbers {
+		if lookPath(wavProbers[i].name) {
+			prober = &wavProbers[i]
+			break
+		}
+	}
+	if prober == nil {
+		t.Skip("neither sox nor ffprobe is installed")
+	}
+	defer func(f string) { wavFormat = f }(wavFormat)
+	dir := t.TempDir()
+	for _, format := range wavFormats {
+		wavFormat = format
+		for i, tc := range wavCheckCases {
+			name := fmt.Sprintf("%s, %d Hz, %d ch, %d-bit, %d frames", format, tc.rate, tc.channels, tc.bytesPerSample*8, tc.frames)
+			path := filepath.Join(dir, fmt.Sprintf("%s%d.wav", format, i))
+			pcm := wavCheckTone(tc.rate, tc.channels, tc.bytesPerSample, tc.frames)
+			if err := os.WriteFile(path, wavChunk(pcm, tc.rate, tc.channels, tc.bytesPerSample), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			want := wavInfo{tc.rate, tc.channels, tc.bytesPerSample * 8, tc.frames}
+			got, err := prober.probe(path)
+			switch {
+			case err != nil:
+				t.Errorf("%s: %s could not read the chunk: %v", name, prober.name, err)
+			case got != want:
+				t.Errorf("%s: %s read %d Hz, %d ch, %d-bit, %d frames", name, prober.name, got.rate, got.channels, got.bits, got.frames)
+			}
+		}
+	}
+}
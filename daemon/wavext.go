@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// WAV fmt chunk layouts -wav-format can pick.
+const (
+	// WAVFormatPCM always writes the basic 16-byte PCM fmt chunk, which
+	// every reader understands.
+	WAVFormatPCM = "pcm"
+	// WAVFormatExtensible always writes WAVE_FORMAT_EXTENSIBLE.
+	WAVFormatExtensible = "extensible"
+	// WAVFormatAuto writes WAVE_FORMAT_EXTENSIBLE only where the format
+	// calls for it: more than two channels or more than 16 bits.
+	WAVFormatAuto = "auto"
+)
+
+var wavFormats = []string{WAVFormatPCM, WAVFormatAuto, WAVFormatExtensible}
+
+// wavFormat is the -wav-format fmt chunk layout used by every WAV the
+// daemon writes. Set once at startup.
+var wavFormat = WAVFormatPCM
+
+const (
+	wavFormatTagPCM        = 1
+	wavFormatTagExtensible = 0xFFFE
+	// fmt chunk body sizes of the two layouts.
+	fmtSizePCM        = 16
+	fmtSizeExtensible = 40
+)
+
+// ksdataformatSubtypePCM is KSDATAFORMAT_SUBTYPE_PCM,
+// 00000001-0000-0010-8000-00aa00389b71, in its on-disk byte order.
+var ksdataformatSubtypePCM = [16]byte{
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00,
+	0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71,
+}
+
+// Speaker positions of the dwChannelMask bits.
+const (
+	speakerFrontLeft    = 0x1
+	speakerFrontRight   = 0x2
+	speakerFrontCenter  = 0x4
+	speakerLowFrequency = 0x8
+	speakerBackLeft     = 0x10
+	speakerBackRight    = 0x20
+	speakerBackCenter   = 0x100
+	speakerSideLeft     = 0x200
+	speakerSideRight    = 0x400
+)
+
+// channelMasks are the default speaker layouts for each channel count,
+// the same ones common encoders assume. Counts without an entry get mask 0,
+// which declares the channels to have no speaker positions, as is true of
+// most mic arrays.
+var channelMasks = map[int]uint32{
+	1: speakerFrontCenter,
+	2: speakerFrontLeft | speakerFrontRight,
+	3: speakerFrontLeft | speakerFrontRight | speakerFrontCenter,
+	4: speakerFrontLeft | speakerFrontRight | speakerBackLeft | speakerBackRight,
+	5: speakerFrontLeft | speakerFrontRight | speakerFrontCenter | speakerBackLeft | speakerBackRight,
+	6: speakerFrontLeft | speakerFrontRight | speakerFrontCenter | speakerLowFrequency | speakerBackLeft | speakerBackRight,
+	7: speakerFrontLeft | speakerFrontRight | speakerFrontCenter | speakerLowFrequency | speakerBackCenter | speakerSideLeft | speakerSideRight,
+	8: speakerFrontLeft | speakerFrontRight | speakerFrontCenter | speakerLowFrequency | speakerBackLeft | speakerBackRight | speakerSideLeft | speakerSideRight,
+}
+
+// parseWAVFormat checks a -wav-format value.
+func parseWAVFormat(s string) error {
+	for _, f := range wavFormats {
+		if s == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown WAV format %q; expected pcm, auto or extensible", s)
+}
+
+// useExtensible reports whether a WAV of this layout gets the extensible
+// fmt chunk under wavFormat.
+func useExtensible(channels, bytesPerSample int) bool {
+	switch wavFormat {
+	case WAVFormatExtensible:
+		return true
+	case WAVFormatAuto:
+		return channels > 2 || bytesPerSample > 2
+	}
+	return false
+}
+
+// wavHeaderLen is the length of the RIFF, fmt and data headers in front of
+// the PCM of a WAV of this layout.
+func wavHeaderLen(channels, bytesPerSample int) int {
+	fmtSize := fmtSizePCM
+	if useExtensible(channels, bytesPerSample) {
+		fmtSize = fmtSizeExtensible
+	}
+	return 12 + 8 + fmtSize + 8
+}
+
+// writeExtensibleFmt writes a WAVE_FORMAT_EXTENSIBLE fmt chunk: the basic
+// fields with the 0xFFFE tag, then the valid bits, the channel mask and
+// the PCM subformat GUID.
+func writeExtensibleFmt(buf *bytes.Buffer, sampleRate, channels int, spec sampleFormatSpec) {
+	blockAlign := channels * spec.bytes
+	buf.WriteString("fmt ")
+	binary.Write(buf, binary.LittleEndian, uint32(fmtSizeExtensible))
+	binary.Write(buf, binary.LittleEndian, uint16(wavFormatTagExtensible))
+	binary.Write(buf, binary.LittleEndian, uint16(channels))
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	binary.Write(buf, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(buf, binary.LittleEndian, uint16(8*spec.bytes)) // container bits
+	binary.Write(buf, binary.LittleEndian, uint16(22))           // cbSize
+	binary.Write(buf, binary.LittleEndian, uint16(spec.bits))    // wValidBitsPerSample
+	binary.Write(buf, binary.LittleEndian, channelMasks[channels])
+	buf.Write(ksdataformatSubtypePCM[:])
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWAVExtensibleHeader(t *testing.T) {
+	defer func(f string) { wavFormat = f }(wavFormat)
+	wavFormat = WAVFormatExtensible
+	for _, tc := range []struct {
+		channels, bps int
+		mask          uint32
+	}{
+		{1, 2, speakerFrontCenter},
+		{2, 3, speakerFrontLeft | speakerFrontRight},
+		{6, 4, speakerFrontLeft | speakerFrontRight | speakerFrontCenter | speakerLowFrequency | speakerBackLeft | speakerBackRight},
+		{10, 2, 0},
+	} {
+		pcm := make([]byte, 10*tc.channels*tc.bps)
+		h := wavChunk(pcm, 48000, tc.channels, tc.bps)
+		if n := wavHeaderLen(tc.channels, tc.bps); n != 68 || len(h) != n+len(pcm) {
+			t.Fatalf("%d ch %d bytes: header %d bytes, wavHeaderLen %d; want 68", tc.channels, tc.bps, len(h)-len(pcm), n)
+		}
+		if string(h[12:16]) != "fmt " || string(h[60:64]) != "data" {
+			t.Fatalf("%d ch %d bytes: unexpected chunk ids in % x", tc.channels, tc.bps, h[:68])
+		}
+		le := binary.LittleEndian
+		blockAlign := tc.channels * tc.bps
+		for _, f := range []struct {
+			name      string
+			got, want uint32
+		}{
+			{"RIFF size", le.Uint32(h[4:]), uint32(60 + len(pcm))},
+			{"fmt size", le.Uint32(h[16:]), fmtSizeExtensible},
+			{"format tag", uint32(le.Uint16(h[20:])), wavFormatTagExtensible},
+			{"channels", uint32(le.Uint16(h[22:])), uint32(tc.channels)},
+			{"sample rate", le.Uint32(h[24:]), 48000},
+			{"byte rate", le.Uint32(h[28:]), uint32(48000 * blockAlign)},
+			{"block align", uint32(le.Uint16(h[32:])), uint32(blockAlign)},
+			{"container bits", uint32(le.Uint16(h[34:])), uint32(8 * tc.bps)},
+			{"cbSize", uint32(le.Uint16(h[36:])), 22},
+			{"valid bits", uint32(le.Uint16(h[38:])), uint32(specFor(tc.bps).bits)},
+			{"channel mask", le.Uint32(h[40:]), tc.mask},
+			{"data size", le.Uint32(h[64:]), uint32(len(pcm))},
+		} {
+			if f.got != f.want {
+				t.Errorf("%d ch %d bytes: %s %#x, want %#x", tc.channels, tc.bps, f.name, f.got, f.want)
+			}
+		}
+		if !bytes.Equal(h[44:60], ksdataformatSubtypePCM[:]) {
+			t.Errorf("%d ch %d bytes: subformat % x, want the PCM GUID", tc.channels, tc.bps, h[44:60])
+		}
+	}
+}
+
+func TestWAVFormatAuto(t *testing.T) {
+	defer func(f string) { wavFormat = f }(wavFormat)
+	for _, tc := range []struct {
+		format        string
+		channels, bps int
+		want          bool
+	}{
+		{WAVFormatPCM, 6, 4, false},
+		{WAVFormatAuto, 1, 2, false},
+		{WAVFormatAuto, 2, 2, false},
+		{WAVFormatAuto, 3, 2, true},
+		{WAVFormatAuto, 1, 3, true},
+		{WAVFormatAuto, 2, 4, true},
+		{WAVFormatExtensible, 1, 2, true},
+	} {
+		wavFormat = tc.format
+		if got := useExtensible(tc.channels, tc.bps); got != tc.want {
+			t.Errorf("%s with %d ch %d bytes: extensible %v, want %v", tc.format, tc.channels, tc.bps, got, tc.want)
+		}
+		want := 44
+		if tc.want {
+			want = 68
+		}
+		if got := len(wavStreamHeader(16000, tc.channels, tc.bps)); got != want {
+			t.Errorf("%s with %d ch %d bytes: header %d bytes, want %d", tc.format, tc.channels, tc.bps, got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import "fmt"
+
+// WAVSizePolicy decides what a WAV header's RIFF and data size fields hold,
+// for clients that read them to know how much PCM follows.
+type WAVSizePolicy string
+
+const (
+	// WAVSizePerChunk writes the exact size of the PCM in the frame the
+	// header starts. Right for wav-chunks, where every frame is a file.
+	WAVSizePerChunk WAVSizePolicy = "per-chunk"
+	// WAVSizeStreaming writes the 0xFFFFFFFF unknown-length sentinel.
+	// Right for wav-stream, whose length is not known when the header goes
+	// out.
+	WAVSizeStreaming WAVSizePolicy = "streaming-sentinel"
+	// WAVSizeFinalized writes the size of the whole file once it is
+	// complete. Only clips, which are assembled before they are sent, can do
+	// this.
+	WAVSizeFinalized WAVSizePolicy = "finalized"
+)
+
+// parseWAVSize checks s against the policies the given format and clip mode
+// can honour; an empty s keeps the format's default.
+func parseWAVSize(s, format string, clip bool) (WAVSizePolicy, error) {
+	p := WAVSizePolicy(s)
+	switch p {
+	case "", WAVSizePerChunk, WAVSizeStreaming, WAVSizeFinalized:
+	default:
+		return "", fmt.Errorf("unknown wavSize %q", s)
+	}
+	if p == "" {
+		return p, nil
+	}
+	switch {
+	case clip:
+		if p == WAVSizeStreaming {
+			return "", fmt.Errorf("wavSize %s does not apply to clips, which are sent complete", p)
+		}
+	case format == "" || format == FormatWAVChunks:
+		if p == WAVSizeFinalized {
+			return "", fmt.Errorf("wavSize %s needs clipSeconds; use %s for wav-chunks", p, WAVSizePerChunk)
+		}
+	case format == FormatWAVStream:
+		if p != WAVSizeStreaming {
+			return "", fmt.Errorf("wavSize %s cannot describe a wav-stream, whose length is unknown", p)
+		}
+	default:
+		return "", fmt.Errorf("wavSize only applies to WAV output, not %s", format)
+	}
+	return p, nil
+}
+
+// wavSizeFields returns the RIFF and data size fields of a header of
+// headerLen bytes in front of dataLen bytes of PCM.
+func wavSizeFields(p WAVSizePolicy, headerLen, dataLen int) (riff, data uint32) {
+	if p == WAVSizeStreaming {
+		return wavStreamingSize, wavStreamingSize
+	}
+	return uint32(headerLen - 8 + dataLen), uint32(dataLen)
+}
+
+// wavSizer is implemented by encoders whose WAV headers can take more than
+// one size policy; wav-stream only ever writes the sentinel.
+type wavSizer interface {
+	setWAVSize(p WAVSizePolicy)
+}
+
+func (e *wavChunkEncoder) setWAVSize(p WAVSizePolicy) { e.sizes = p }
+func (e *clipEncoder) setWAVSize(p WAVSizePolicy)     { e.sizes = p }
+
+// setWAVSize picks the size-field policy of this connection's WAV headers;
+// empty keeps each format's default.
+func (c *client) setWAVSize(p WAVSizePolicy) {
+	c.encMu.Lock()
+	c.wavSize = p
+	c.dropEncoder()
+	c.encMu.Unlock()
+}
+
+// outputMode returns the connection's format and whether it is in clip mode.
+func (c *client) outputMode() (string, bool) {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+	return c.format, c.clipSeconds > 0
+}
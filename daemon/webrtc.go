@@ -0,0 +1,15 @@
+package main
+
+import "net/http"
+
+// handleWebRTCOffer reserves the SDP offer/answer endpoint for a WebRTC
+// audio track, which is not implemented: the daemon has no Opus encoder or
+// WebRTC stack to answer with. Offers are refused rather than left to time
+// out, so clients can fall back to another transport.
+func handleWebRTCOffer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.Error(w, "WebRTC is not supported by this build; stream over the websocket or /hls/stream.m3u8 instead", http.StatusNotImplemented)
+}